@@ -0,0 +1,36 @@
+// Package child tags LogDoc messages with request ID set by chi middleware.RequestID. It is a separate module
+// depending on chi only, so that appender itself doesn't depend on chi.
+package child
+
+import (
+	"context"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+// RequestIDKey is key of request ID field.
+const RequestIDKey = "request_id"
+
+// RequestID returns request ID of chi middleware.RequestID in ctx, for logrusld.Hook.ContextFields:
+//
+//	hook.ContextFields = logrusld.ChainContextFields(otelld.ExtractTraceContext, child.RequestID)
+//	logger.WithContext(r.Context()).Info("request handled")
+func RequestID(ctx context.Context) map[string]interface{} {
+	id := middleware.GetReqID(ctx)
+	if id == "" {
+		return nil
+	}
+	return map[string]interface{}{RequestIDKey: id}
+}
+
+// ZapFields returns request ID in ctx as zap field, since zap entries have no context:
+//
+//	logger.Info("request handled", child.ZapFields(r.Context())...)
+func ZapFields(ctx context.Context) []zap.Field {
+	id := middleware.GetReqID(ctx)
+	if id == "" {
+		return nil
+	}
+	return []zap.Field{zap.String(RequestIDKey, id)}
+}
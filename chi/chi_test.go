@@ -0,0 +1,34 @@
+package child
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestRequestID(t *testing.T) {
+	if fields := RequestID(context.Background()); fields != nil {
+		t.Fatalf("fields without request ID = %v", fields)
+	}
+	if fields := ZapFields(context.Background()); fields != nil {
+		t.Fatalf("zap fields without request ID = %v", fields)
+	}
+
+	var ctx context.Context
+	handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(middleware.RequestIDHeader, "req-42")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if fields := RequestID(ctx); fields[RequestIDKey] != "req-42" {
+		t.Fatalf("fields = %v", fields)
+	}
+	if fields := ZapFields(ctx); len(fields) != 1 || fields[0].Key != RequestIDKey || fields[0].String != "req-42" {
+		t.Fatalf("zap fields = %v", fields)
+	}
+}
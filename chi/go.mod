@@ -0,0 +1,13 @@
+module github.com/LogDoc-org/logdoc-go-appender/chi
+
+go 1.20
+
+require (
+	github.com/go-chi/chi/v5 v5.0.12
+	go.uber.org/zap v1.24.0
+)
+
+require (
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+)
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AppSource is where name of application sent in app field comes from.
+type AppSource string
+
+const (
+	AppExplicit   AppSource = "explicit"   // Set in configuration.
+	AppEnv        AppSource = "env"        // LOGDOC_APP environment variable.
+	AppKubernetes AppSource = "kubernetes" // Deployment or StatefulSet of pod named HOSTNAME.
+	AppBinary     AppSource = "binary"     // Base name of os.Args[0].
+)
+
+// MaxAppLength is the longest application name sent, longer ones are cut by SanitizeApp.
+const MaxAppLength = 128
+
+// ResolveApp returns app sanitized by SanitizeApp if it is not empty, otherwise DefaultApp.
+func ResolveApp(app string, kubernetes bool) (string, AppSource) {
+	if app = SanitizeApp(app); app != "" {
+		return app, AppExplicit
+	}
+	return DefaultApp(kubernetes)
+}
+
+// DefaultApp returns name of application which has not set it: LOGDOC_APP environment variable, with kubernetes
+// name of Deployment or StatefulSet derived from pod name in HOSTNAME, see DeploymentName, or base name of
+// os.Args[0]. Name is sanitized by SanitizeApp, so that events are not rejected or sent without application.
+func DefaultApp(kubernetes bool) (string, AppSource) {
+	if app := SanitizeApp(os.Getenv("LOGDOC_APP")); app != "" {
+		return app, AppEnv
+	}
+	if kubernetes {
+		if app := SanitizeApp(DeploymentName(os.Getenv("HOSTNAME"))); app != "" {
+			return app, AppKubernetes
+		}
+	}
+	var app string
+	if len(os.Args) > 0 {
+		app = SanitizeApp(filepath.Base(os.Args[0]))
+	}
+	return app, AppBinary
+}
+
+// DeploymentName returns name of workload of Kubernetes pod named hostname, billing for pods billing-7d9f8b6c5-x2kqz
+// of Deployment, billing-5 of StatefulSet and billing-x2kqz of DaemonSet, or empty string for other names.
+func DeploymentName(hostname string) string {
+	name, suffix := cutLast(hostname)
+	switch {
+	case name == "":
+		return ""
+	case isDigits(suffix):
+		return name
+	case len(suffix) != 5 || !isPodSuffix(suffix):
+		return ""
+	}
+	if prefix, hash := cutLast(name); prefix != "" && len(hash) >= 6 && len(hash) <= 10 && isPodSuffix(hash) {
+		return prefix
+	}
+	return name
+}
+
+// cutLast splits s by its last "-".
+func cutLast(s string) (before, after string) {
+	i := strings.LastIndexByte(s, '-')
+	if i <= 0 {
+		return "", s
+	}
+	return s[:i], s[i+1:]
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isPodSuffix reports whether s consists of characters Kubernetes uses for generated names, without vowels
+// and similar looking characters.
+func isPodSuffix(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !strings.ContainsRune("bcdfghjklmnpqrstvwxz2456789", rune(s[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// SanitizeApp returns application name LogDoc accepts: spaces around it are trimmed, characters other than ASCII
+// letters, digits, ".", "-" and "_" are replaced with "_", and it is cut to MaxAppLength bytes.
+func SanitizeApp(app string) string {
+	app = strings.TrimSpace(app)
+	if len(app) > MaxAppLength {
+		app = app[:MaxAppLength]
+	}
+	for i := 0; i < len(app); i++ {
+		if !appChar(app[i]) {
+			return strings.Map(func(r rune) rune {
+				if r < 0x80 && appChar(byte(r)) {
+					return r
+				}
+				return '_'
+			}, app)
+		}
+	}
+	return app
+}
+
+func appChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_'
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeploymentName(t *testing.T) {
+	for hostname, want := range map[string]string{
+		"billing-7d9f8b6c5-x2kqz":         "billing",
+		"billing-worker-5c8d7f9b4d-7xwtz": "billing-worker",
+		"billing-0":                       "billing",
+		"billing-x2kqz":                   "billing",
+		"billing-worker":                  "",
+		"billing":                         "",
+		"build-host-01.example.com":       "",
+		"-12":                             "",
+		"":                                "",
+	} {
+		if got := DeploymentName(hostname); got != want {
+			t.Errorf("DeploymentName(%q) = %q, want %q", hostname, got, want)
+		}
+	}
+}
+
+func TestSanitizeApp(t *testing.T) {
+	for app, want := range map[string]string{
+		"billing":                            "billing",
+		" billing.api_v2-eu ":                "billing.api_v2-eu",
+		"billing worker":                     "billing_worker",
+		"app=x\nlvl=fatal":                   "app_x_lvl_fatal",
+		"платежи":                            "_______",
+		"":                                   "",
+		strings.Repeat("a", MaxAppLength+10): strings.Repeat("a", MaxAppLength),
+	} {
+		if got := SanitizeApp(app); got != want {
+			t.Errorf("SanitizeApp(%q) = %q, want %q", app, got, want)
+		}
+	}
+}
+
+func TestResolveApp(t *testing.T) {
+	binary := SanitizeApp(filepath.Base(os.Args[0]))
+	for _, tc := range []struct {
+		name, app, env, hostname string
+		kubernetes               bool
+		want                     string
+		source                   AppSource
+	}{
+		{"explicit", "billing api", "from-env", "billing-7d9f8b6c5-x2kqz", true, "billing_api", AppExplicit},
+		{"env", "", "from-env", "billing-7d9f8b6c5-x2kqz", true, "from-env", AppEnv},
+		{"blank env", " ", " ", "billing-7d9f8b6c5-x2kqz", true, "billing", AppKubernetes},
+		{"kubernetes off", "", "", "billing-7d9f8b6c5-x2kqz", false, binary, AppBinary},
+		{"not a pod", "", "", "build-host", true, binary, AppBinary},
+		{"binary", "", "", "", false, binary, AppBinary},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("LOGDOC_APP", tc.env)
+			t.Setenv("HOSTNAME", tc.hostname)
+			if app, source := ResolveApp(tc.app, tc.kubernetes); app != tc.want || source != tc.source {
+				t.Fatalf("ResolveApp(%q) = %q, %s", tc.app, app, source)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"runtime/debug"
+)
+
+// BuildInfoKeys are keys of build information fields, see BuildFields. Empty key means the default one.
+type BuildInfoKeys struct {
+	Revision  string // vcs.revision: VCS revision, shortened to RevisionLength characters.
+	Time      string // vcs.time: time of VCS revision.
+	GoVersion string // go.version: version of Go which built the binary.
+	Version   string // module.version: version of the main module.
+}
+
+// RevisionLength is length of VCS revision sent in build information.
+const RevisionLength = 12
+
+func (k BuildInfoKeys) key(key, def string) string {
+	if key == "" {
+		return def
+	}
+	return key
+}
+
+// BuildFields returns build information of the binary read by read, debug.ReadBuildInfo if nil, as static fields
+// with keys: VCS revision and its time, Go version and version of the main module. Missing values, e.g. VCS
+// information of binary built by go run or version (devel) of the main module, are omitted, and without build
+// information nil is returned.
+func BuildFields(read func() (*debug.BuildInfo, bool), keys BuildInfoKeys) map[string]string {
+	if read == nil {
+		read = debug.ReadBuildInfo
+	}
+	info, ok := read()
+	if !ok || info == nil {
+		return nil
+	}
+	fields := map[string]string{}
+	add := func(key, def, value string) {
+		if value != "" {
+			fields[keys.key(key, def)] = value
+		}
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision := s.Value
+			if len(revision) > RevisionLength {
+				revision = revision[:RevisionLength]
+			}
+			add(keys.Revision, "vcs.revision", revision)
+		case "vcs.time":
+			add(keys.Time, "vcs.time", s.Value)
+		}
+	}
+	add(keys.GoVersion, "go.version", info.GoVersion)
+	if info.Main.Version != "(devel)" {
+		add(keys.Version, "module.version", info.Main.Version)
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// MergeStaticFields returns fields of all maps, later maps win, or nil if there are none.
+func MergeStaticFields(maps ...map[string]string) map[string]string {
+	var merged map[string]string
+	for _, m := range maps {
+		for k, v := range m {
+			if merged == nil {
+				merged = make(map[string]string, len(m))
+			}
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,58 @@
+package common
+
+import (
+	"reflect"
+	"runtime/debug"
+	"testing"
+)
+
+func stubBuildInfo(info *debug.BuildInfo) func() (*debug.BuildInfo, bool) {
+	return func() (*debug.BuildInfo, bool) { return info, info != nil }
+}
+
+func TestBuildFields(t *testing.T) {
+	stamped := &debug.BuildInfo{
+		GoVersion: "go1.20.4",
+		Main:      debug.Module{Path: "example.com/billing", Version: "v1.4.2"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: "4b825dc642cb6eb9a060e54bf8d69288fbee4904"},
+			{Key: "vcs.time", Value: "2023-04-05T06:07:08Z"},
+			{Key: "vcs.modified", Value: "false"},
+		},
+	}
+	for _, tc := range []struct {
+		name string
+		info *debug.BuildInfo
+		keys BuildInfoKeys
+		want map[string]string
+	}{
+		{"stamped", stamped, BuildInfoKeys{}, map[string]string{
+			"vcs.revision": "4b825dc642cb", "vcs.time": "2023-04-05T06:07:08Z", "go.version": "go1.20.4", "module.version": "v1.4.2",
+		}},
+		{"renamed", stamped, BuildInfoKeys{Revision: "commit", Version: "build"}, map[string]string{
+			"commit": "4b825dc642cb", "vcs.time": "2023-04-05T06:07:08Z", "go.version": "go1.20.4", "build": "v1.4.2",
+		}},
+		{"go run", &debug.BuildInfo{GoVersion: "go1.20.4", Main: debug.Module{Path: "command-line-arguments", Version: "(devel)"}},
+			BuildInfoKeys{}, map[string]string{"go.version": "go1.20.4"}},
+		{"empty", &debug.BuildInfo{}, BuildInfoKeys{}, nil},
+		{"missing", nil, BuildInfoKeys{}, nil},
+	} {
+		if got := BuildFields(stubBuildInfo(tc.info), tc.keys); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: BuildFields() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if fields := BuildFields(nil, BuildInfoKeys{}); fields["go.version"] == "" {
+		t.Errorf("BuildFields of test binary = %v", fields)
+	}
+}
+
+func TestMergeStaticFields(t *testing.T) {
+	if merged := MergeStaticFields(nil, map[string]string{}); merged != nil {
+		t.Fatalf("merged = %v", merged)
+	}
+	merged := MergeStaticFields(map[string]string{"go.version": "go1.20", "env": "dev"}, nil, map[string]string{"env": "prod"})
+	if !reflect.DeepEqual(merged, map[string]string{"go.version": "go1.20", "env": "prod"}) {
+		t.Fatalf("merged = %v", merged)
+	}
+}
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"runtime"
+	"strings"
+)
+
+// CallerAbove returns frame of the calling goroutine which called caller through skip more frames, e.g. of
+// logging facade, skipping frames of packages too, such as "github.com/acme/log". It returns false if caller is
+// not on the stack or it has not enough frames.
+func CallerAbove(caller runtime.Frame, skip int, packages []string) (runtime.Frame, bool) {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	found := false
+	for {
+		frame, more := frames.Next()
+		if !found {
+			found = frame.Function == caller.Function && frame.File == caller.File && frame.Line == caller.Line
+		}
+		if found {
+			switch {
+			case inPackages(frame.Function, packages):
+			case skip > 0:
+				skip--
+			default:
+				return frame, true
+			}
+		}
+		if !more {
+			return runtime.Frame{}, false
+		}
+	}
+}
+
+// inPackages reports whether function belongs to one of packages.
+func inPackages(function string, packages []string) bool {
+	return len(packages) > 0 && contains(packages, FunctionPackage(function))
+}
+
+// FunctionPackage returns import path of package of function, e.g. github.com/acme/log for
+// github.com/acme/log.(*Logger).Info.
+func FunctionPackage(function string) string {
+	slash := strings.LastIndexByte(function, '/')
+	if dot := strings.IndexByte(function[slash+1:], '.'); dot >= 0 {
+		return function[:slash+1+dot]
+	}
+	return function
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
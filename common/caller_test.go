@@ -0,0 +1,51 @@
+package common
+
+import (
+	"runtime"
+	"testing"
+)
+
+// logAt returns frame of its caller, like logger does.
+func logAt(skip int, packages []string) (runtime.Frame, runtime.Frame, bool) {
+	pcs := make([]uintptr, 1)
+	runtime.Callers(2, pcs)
+	caller, _ := runtime.CallersFrames(pcs).Next()
+	above, ok := CallerAbove(caller, skip, packages)
+	return caller, above, ok
+}
+
+func facade(skip int, packages []string) (runtime.Frame, runtime.Frame, bool) {
+	return logAt(skip, packages)
+}
+
+func TestCallerAbove(t *testing.T) {
+	_, _, line, _ := runtime.Caller(0)
+	caller, above, ok := facade(1, nil)
+	if !ok || caller.Function != "github.com/LogDoc-org/logdoc-go-appender/common.facade" ||
+		above.Function != "github.com/LogDoc-org/logdoc-go-appender/common.TestCallerAbove" || above.Line != line+1 {
+		t.Fatalf("caller = %s, above = %s:%d, %v", caller.Function, above.Function, above.Line, ok)
+	}
+	if _, above, ok := facade(0, nil); !ok || above.Function != caller.Function {
+		t.Fatalf("skip 0 = %s, %v", above.Function, ok)
+	}
+	if _, _, ok := facade(1000, nil); ok {
+		t.Fatal("skipped beyond stack")
+	}
+	// Every frame of the package is skipped, including the test itself.
+	if _, above, ok := facade(0, []string{"github.com/LogDoc-org/logdoc-go-appender/common"}); !ok || above.Function != "testing.tRunner" {
+		t.Fatalf("package skip = %s, %v", above.Function, ok)
+	}
+}
+
+func TestFunctionPackage(t *testing.T) {
+	for function, want := range map[string]string{
+		"github.com/acme/log.(*Logger).Info": "github.com/acme/log",
+		"github.com/acme/log.Info.func1":     "github.com/acme/log",
+		"main.main":                          "main",
+		"runtime.goexit":                     "runtime",
+	} {
+		if got := FunctionPackage(function); got != want {
+			t.Errorf("FunctionPackage(%q) = %q, want %q", function, got, want)
+		}
+	}
+}
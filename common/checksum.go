@@ -0,0 +1,136 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"sort"
+	"strings"
+)
+
+// Checksum is algorithm of checksum field, which lets LogDoc side detect events changed or cut in transit
+// and at rest.
+type Checksum int
+
+const (
+	ChecksumNone   Checksum = iota // No checksum field, by default.
+	ChecksumCRC32                  // CRC-32 (IEEE), detects accidental damage.
+	ChecksumSHA256                 // SHA-256, detects tampering too.
+)
+
+// ChecksumKey is key of checksum field. Its value is algorithm and hex digest, e.g. "crc32:1a2b3c4d".
+const ChecksumKey = "checksum"
+
+func (c Checksum) name() string {
+	switch c {
+	case ChecksumCRC32:
+		return "crc32"
+	case ChecksumSHA256:
+		return "sha256"
+	}
+	return ""
+}
+
+func (c Checksum) hash() hash.Hash {
+	switch c {
+	case ChecksumCRC32:
+		return crc32.NewIEEE()
+	case ChecksumSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// Sum returns value of checksum field for fields. Digest is computed over canonical form of event: fields
+// other than checksum one, sorted by key and value, each written as key, zero byte, 4 bytes of big-endian
+// length of value and value.
+func (c Checksum) Sum(fields []Field) string {
+	h := c.hash()
+	if h == nil {
+		return ""
+	}
+	type pair struct{ key, value string }
+	sorted := make([]pair, 0, len(fields))
+	for _, f := range fields {
+		if f.Key != ChecksumKey {
+			sorted = append(sorted, pair{f.Key, stringValue(f.Value)})
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].key != sorted[j].key {
+			return sorted[i].key < sorted[j].key
+		}
+		return sorted[i].value < sorted[j].value
+	})
+	var canonical []byte
+	for _, p := range sorted {
+		canonical = append(append(canonical, p.key...), 0)
+		canonical = binary.BigEndian.AppendUint32(canonical, uint32(len(p.value)))
+		canonical = append(canonical, p.value...)
+	}
+	h.Write(canonical)
+	return c.name() + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+func stringValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+// AppendChecksum appends checksum field to pairs written by encoder.
+func AppendChecksum(encoder FieldEncoder, data []byte, checksum Checksum) ([]byte, error) {
+	if checksum == ChecksumNone {
+		return data, nil
+	}
+	encoder = Encoding(encoder)
+	fields, err := ReadFieldsWith(encoder, data)
+	if err != nil {
+		return data, err
+	}
+	encoder.WriteField(ChecksumKey, checksum.Sum(fields), &data)
+	return data, nil
+}
+
+// ChecksumSize returns size of checksum field written by encoder, 0 for ChecksumNone.
+func ChecksumSize(encoder FieldEncoder, checksum Checksum) int {
+	if checksum == ChecksumNone {
+		return 0
+	}
+	var field []byte
+	Encoding(encoder).WriteField(ChecksumKey, checksum.Sum(nil), &field)
+	return len(field)
+}
+
+// ErrNoChecksum is returned by VerifyChecksum for event without checksum field.
+var ErrNoChecksum = errors.New("no LogDoc checksum field")
+
+// VerifyChecksum recomputes checksum of decoded event, e.g. read by ReadFrame, and returns error if it
+// doesn't match checksum field.
+func VerifyChecksum(fields []Field) error {
+	var sum string
+	found := false
+	for _, f := range fields {
+		if f.Key == ChecksumKey {
+			sum, found = stringValue(f.Value), true
+		}
+	}
+	if !found {
+		return ErrNoChecksum
+	}
+	algorithm, _, _ := strings.Cut(sum, ":")
+	for _, c := range []Checksum{ChecksumCRC32, ChecksumSHA256} {
+		if c.name() == algorithm {
+			if want := c.Sum(fields); want != sum {
+				return fmt.Errorf("LogDoc checksum mismatch: %s, computed %s", sum, want)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported LogDoc checksum %q", sum)
+}
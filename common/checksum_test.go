@@ -0,0 +1,56 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	for _, checksum := range []Checksum{ChecksumCRC32, ChecksumSHA256} {
+		for _, encoder := range []FieldEncoder{TextEncoding, BinaryEncoding} {
+			var data []byte
+			encoder.WriteField("msg", "line 1\nline 2", &data)
+			encoder.WriteField("lvl", "info", &data)
+			encoder.WriteField("user", "42", &data)
+			data, err := AppendChecksum(encoder, data, checksum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := ChecksumSize(encoder, checksum); size <= 0 || !strings.Contains(string(data[len(data)-size:]), checksum.name()+":") {
+				t.Errorf("%s: ChecksumSize = %d for %q", checksum.name(), size, data)
+			}
+			fields, err := ReadFieldsWith(encoder, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyChecksum(fields); err != nil {
+				t.Errorf("%s: %v", checksum.name(), err)
+			}
+
+			// Order of fields doesn't matter.
+			fields[0], fields[2] = fields[2], fields[0]
+			if err := VerifyChecksum(fields); err != nil {
+				t.Errorf("%s: reordered: %v", checksum.name(), err)
+			}
+			changed := append([]Field(nil), fields...)
+			changed[1].Value = "error"
+			if err := VerifyChecksum(changed); err == nil {
+				t.Errorf("%s: changed value is not detected", checksum.name())
+			}
+			if err := VerifyChecksum(fields[1:]); err == nil {
+				t.Errorf("%s: dropped field is not detected", checksum.name())
+			}
+		}
+	}
+
+	if err := VerifyChecksum([]Field{{"msg", "text"}}); !errors.Is(err, ErrNoChecksum) {
+		t.Errorf("VerifyChecksum = %v, want ErrNoChecksum", err)
+	}
+	if err := VerifyChecksum([]Field{{"msg", "text"}, {ChecksumKey, "md5:00"}}); err == nil {
+		t.Error("unknown algorithm is accepted")
+	}
+	if data, _ := AppendChecksum(nil, []byte("msg=text\n"), ChecksumNone); string(data) != "msg=text\n" {
+		t.Errorf("ChecksumNone: %q", data)
+	}
+}
@@ -2,23 +2,118 @@ package common
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"path"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
+// Pairs of LogDoc Native Protocol frame are written as "key=value\n". Values with line breaks are written
+// as complex pairs: "key\n", 4 bytes of big-endian length and the value as is, so any value round-trips.
+// Keys can't be escaped, so '=', '\n' and '\r' in keys are replaced with '_', empty key is written as "_".
+
+// Protocol is framing of LogDoc Native Protocol: frame is header, pairs and terminator.
+// Zero value is the default revision, nil Header or Terminator are taken from DefaultProtocol.
+type Protocol struct {
+	Header     []byte
+	Terminator []byte
+	// CompressedHeader starts block of compressed frames, followed by 4 bytes of big-endian length and
+	// compressed data. There is no default, it should be set for server which accepts such blocks.
+	CompressedHeader []byte
+}
+
+// Compression is algorithm of compressed blocks.
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+)
+
+// Compress packs frames into compressed block.
+func (p Protocol) Compress(frames []byte, compression Compression) ([]byte, error) {
+	if compression != CompressionGzip {
+		return nil, fmt.Errorf("unsupported compression %d", compression)
+	}
+	var buf bytes.Buffer
+	buf.Write(p.CompressedHeader)
+	buf.Write([]byte{0, 0, 0, 0})
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(frames)
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	block := buf.Bytes()
+	binary.BigEndian.PutUint32(block[len(p.CompressedHeader):], uint32(len(block)-len(p.CompressedHeader)-4))
+	return block, nil
+}
+
+// DefaultProtocol is framing accepted by LogDoc server by default.
+var DefaultProtocol = Protocol{Header: []byte{6, 3}, Terminator: []byte("\n")}
+
+func (p Protocol) header() []byte {
+	if p.Header == nil {
+		return DefaultProtocol.Header
+	}
+	return p.Header
+}
+
+func (p Protocol) terminator() []byte {
+	if p.Terminator == nil {
+		return DefaultProtocol.Terminator
+	}
+	return p.Terminator
+}
+
+// Frame wraps encoded pairs into frame.
+func (p Protocol) Frame(fields []byte) []byte {
+	header, terminator := p.header(), p.terminator()
+	result := make([]byte, 0, len(header)+len(fields)+len(terminator))
+	// Пишем заголовок
+	result = append(result, header...)
+	result = append(result, fields...)
+	// Финальный байт, завершаем
+	result = append(result, terminator...)
+	return result
+}
+
+// Overhead returns size of frame except pairs.
+func (p Protocol) Overhead() int {
+	return len(p.header()) + len(p.terminator())
+}
+
+// WritePair writes pair, value is cut at "@@" where custom fields of message start.
 func WritePair(key string, value string, arr *[]byte) {
-	sepIdx := strings.Index(value, "@@")
-	msg := ""
-	if sepIdx != -1 {
-		msg = value[0:sepIdx]
+	WritePairWith(TextEncoding, key, value, arr)
+}
+
+// WriteField writes pair with value as is.
+func WriteField(key string, value string, arr *[]byte) {
+	key = safeKey(key)
+	if strings.ContainsAny(value, "\r\n") {
+		writeComplexPair(key, value, arr)
 	} else {
-		msg = value
+		writeSimplePair(key, value, arr)
 	}
-	if strings.Contains(msg, "\n") {
-		writeComplexPair(key, msg, arr)
-	} else {
-		writeSimplePair(key, msg, arr)
+}
+
+func safeKey(key string) string {
+	if key == "" {
+		return "_"
 	}
+	if !strings.ContainsAny(key, "=\r\n") {
+		return key
+	}
+	return strings.NewReplacer("=", "_", "\r", "_", "\n", "_").Replace(key)
 }
 
 func writeComplexPair(key string, value string, arr *[]byte) {
@@ -33,21 +128,355 @@ func writeSimplePair(key string, value string, arr *[]byte) {
 }
 
 func ProcessCustomFields(msg string, arr *[]byte) {
+	for _, f := range MessageFields(msg)[1:] {
+		WriteField(UserKey(f.Key, CollisionPrefix), f.Value.(string), arr)
+	}
+}
+
+// Field is message field before encoding.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// SplitLines returns the first line of value and the rest after it. If maxLines > 0, the rest is cut to
+// maxLines lines and "... N more lines" is appended.
+func SplitLines(value string, maxLines int) (first, rest string) {
+	i := strings.IndexByte(value, '\n')
+	if i < 0 {
+		return value, ""
+	}
+	first, rest = strings.TrimSuffix(value[:i], "\r"), value[i+1:]
+	if maxLines <= 0 {
+		return first, rest
+	}
+	end := 0
+	for n := 0; n < maxLines; n++ {
+		j := strings.IndexByte(rest[end:], '\n')
+		if j < 0 {
+			return first, rest
+		}
+		end += j + 1
+	}
+	if end == len(rest) {
+		return first, rest
+	}
+	more := strings.Count(strings.TrimSuffix(rest[end:], "\n"), "\n") + 1
+	return first, rest[:end] + fmt.Sprintf("... %d more lines", more)
+}
+
+// ReplaceField is called for every message field before encoding, except app, tsrc, ip, host, pid, seq,
+// event_id and checksum added by transport. It returns new key and value, empty key drops the field.
+type ReplaceField func(key string, value interface{}) (string, interface{})
+
+// MessageFields returns msg field with text of message and custom fields written in message
+// after "@@" as key=value separated by "@".
+func MessageFields(msg string) []Field {
 	// Обработка кастом полей
 	sepIdx := strings.Index(msg, "@@")
-	rawFields := ""
+	if sepIdx == -1 {
+		return []Field{{"msg", msg}}
+	}
+	fields := []Field{{"msg", msg[:sepIdx]}}
+	for _, pair := range strings.Split(msg[sepIdx+2:], "@") {
+		keyValue := strings.Split(pair, "=")
+		if len(keyValue) == 2 {
+			fields = append(fields, Field{keyValue[0], keyValue[1]})
+		}
+	}
+	return fields
+}
 
-	if sepIdx != -1 {
-		rawFields = msg[sepIdx+2:]
-		keyValuePairs := strings.Split(rawFields, "@")
+// DefaultMaxValueSize is max size in bytes of field value, longer values are cut by Sanitize.
+const DefaultMaxValueSize = 1 << 20
 
-		for _, pair := range keyValuePairs {
-			keyValue := strings.Split(pair, "=")
-			if len(keyValue) == 2 {
-				*arr = append(*arr, []byte(keyValue[0]+"="+keyValue[1]+"\n")...)
+// Sanitize makes value safe for LogDoc UI: invalid UTF-8 is replaced with U+FFFD, control characters
+// other than tab and line breaks are escaped as \xNN, and if maxSize > 0, value longer than maxSize bytes
+// is cut at rune boundary, not inside escape, and "..." is appended.
+func Sanitize(value string, maxSize int) string {
+	value, _ = SanitizeValue(value, maxSize)
+	return value
+}
+
+// SanitizeValue is Sanitize which also reports whether value was cut to maxSize.
+func SanitizeValue(value string, maxSize int) (string, bool) {
+	clean := utf8.ValidString(value)
+	for i := 0; clean && i < len(value); i++ {
+		clean = !isControl(value[i])
+	}
+	if !clean {
+		var b strings.Builder
+		b.Grow(len(value))
+		for _, r := range strings.ToValidUTF8(value, string(utf8.RuneError)) {
+			if r < utf8.RuneSelf && isControl(byte(r)) {
+				fmt.Fprintf(&b, "\\x%02x", r)
+			} else {
+				b.WriteRune(r)
 			}
 		}
+		value = b.String()
+	}
+	if maxSize > 0 && len(value) > maxSize {
+		return cutValue(value, maxSize), true
+	}
+	return value, false
+}
+
+// FieldsTruncatedKey is field with number of user fields dropped by LimitFields.
+const FieldsTruncatedKey = "fields_truncated"
+
+// LimitFields keeps the first maxFields of fields, if there are more, and appends fields_truncated field
+// with number of dropped ones, which is returned too. There is no limit if maxFields is 0.
+func LimitFields(fields []Field, maxFields int) ([]Field, int) {
+	if maxFields <= 0 || len(fields) <= maxFields {
+		return fields, 0
+	}
+	dropped := len(fields) - maxFields
+	fields = append(fields[:maxFields], Field{Key: FieldsTruncatedKey, Value: strconv.Itoa(dropped)})
+	return fields, dropped
+}
+
+func isControl(c byte) bool {
+	return (c < 0x20 && c != '\t' && c != '\n' && c != '\r') || c == 0x7f
+}
+
+// ReservedFields are written by appender itself, user fields can't overwrite them, see Collision.
+var ReservedFields = []string{"msg", "app", "tsrc", "lvl", "ip", "host", "pid", "seq", "event_id", "src", "checksum"}
+
+// ReservedPrefix is prepended to keys of user fields colliding with ReservedFields.
+const ReservedPrefix = "attr_"
+
+// Collision is what is done with user field whose key is one of ReservedFields.
+type Collision int
+
+const (
+	CollisionPrefix Collision = iota // Key gets ReservedPrefix, e.g. attr_app, by default.
+	CollisionDrop                    // Field is dropped with a warning.
+)
+
+// IsReserved reports whether key is one of ReservedFields.
+func IsReserved(key string) bool {
+	return FieldNames{}.Reserved(key)
+}
+
+// UserKey returns key user field is written with, empty if the field is dropped.
+func UserKey(key string, collision Collision) string {
+	return FieldNames{}.UserKey(key, collision)
+}
+
+// StaticFields returns fields ordered by key, e.g. static fields added to every message.
+func StaticFields(fields map[string]string) []Field {
+	result := make([]Field, 0, len(fields))
+	for k, v := range fields {
+		result = append(result, Field{Key: k, Value: v})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// CheckStaticFields returns error if any of fields is named as one of ReservedFields.
+func CheckStaticFields(fields map[string]string) error {
+	return FieldNames{}.CheckStaticFields(fields)
+}
+
+// Marshaler encodes structs, maps and slices of field values.
+type Marshaler func(v interface{}) ([]byte, error)
+
+// FormatValue formats field value for LogDoc: numbers and bools with strconv, times in RFC 3339,
+// errors and Stringers with their methods, structs, maps and slices as JSON. Nil is formatted as empty string.
+func FormatValue(value interface{}) string {
+	s, _ := FormatValueWith(value, nil)
+	return s
+}
+
+// FormatValueWith formats field value like FormatValue, encoding structs, maps and slices with marshal
+// (json.Marshal if nil). If marshal fails, value is formatted with %+v and the error is returned. Values
+// nested too deep or referencing themselves are not marshaled, see ValueFormat.Format.
+func FormatValueWith(value interface{}, marshal Marshaler) (string, error) {
+	return ValueFormat{}.Format(value, marshal)
+}
+
+func formatValue(value interface{}, marshal Marshaler, maxDepth int) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if rv.IsNil() {
+			return "", nil
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case uintptr:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return v.String(), nil
+	case error:
+		return v.Error(), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	}
+	switch reflect.Indirect(reflect.ValueOf(value)).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		if maxDepth > 0 && !withinDepth(reflect.ValueOf(value), maxDepth, map[uintptr]bool{}) {
+			return "", ErrValueDepth
+		}
+		if marshal == nil {
+			marshal = json.Marshal
+		}
+		b, err := marshal(value)
+		return string(b), err
+	}
+	return fmt.Sprint(value), nil
+}
+
+// DefaultTimeFormat is format of tsrc field with milliseconds, use e.g. "060201150405.000000" for microseconds.
+const DefaultTimeFormat = "060201150405.000"
+
+// Timestamp formats time of message for tsrc field in UTC, current time if t is zero.
+func Timestamp(t time.Time, format string) string {
+	return TimestampIn(t, format, nil)
+}
+
+// TimestampIn formats time of message for tsrc field in time zone loc, UTC if nil. Default format has
+// no zone, so local time is ambiguous when clocks go back; UTC is recommended.
+func TimestampIn(t time.Time, format string, loc *time.Location) string {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	if format == "" {
+		format = DefaultTimeFormat
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format(format)
+}
+
+// SourceFormat is format of src field.
+type SourceFormat int
+
+const (
+	SourceFunc      SourceFormat = iota // pkg.Func:line, default, with full package path.
+	SourceFile                          // file:line.
+	SourceFuncFile                      // pkg.Func file:line.
+	SourceShortFunc                     // pkg.Func:line without package path, e.g. handlers.(*User).Get:42.
+	SourceRelFile                       // file:line relative to root of its module, e.g. internal/handlers/user.go:42.
+)
+
+// Source formats call site of message for src field.
+func Source(function, file string, line int, format SourceFormat) string {
+	return SourceTrimmed(function, file, line, format, nil)
+}
+
+// SourceTrimmed is Source with the first of trimPrefixes which function or file starts with, e.g. build
+// directory or module path, stripped from it. SourceRelFile without such prefix keeps part of file after module
+// cache directory, path relative to the main module root for its packages, or directory and name of file otherwise.
+func SourceTrimmed(function, file string, line int, format SourceFormat, trimPrefixes []string) string {
+	function, _ = trimPrefix(function, trimPrefixes)
+	file, trimmed := trimPrefix(file, trimPrefixes)
+	switch format {
+	case SourceFile:
+		return file + ":" + strconv.Itoa(line)
+	case SourceFuncFile:
+		return function + " " + file + ":" + strconv.Itoa(line)
+	case SourceShortFunc:
+		return function[strings.LastIndexByte(function, '/')+1:] + ":" + strconv.Itoa(line)
+	case SourceRelFile:
+		if !trimmed {
+			file = relativeFile(function, file)
+		}
+		return file + ":" + strconv.Itoa(line)
+	default:
+		return function + ":" + strconv.Itoa(line)
+	}
+}
+
+func trimPrefix(s string, prefixes []string) (string, bool) {
+	for _, p := range prefixes {
+		if p != "" && strings.HasPrefix(s, p) {
+			return s[len(p):], true
+		}
+	}
+	return s, false
+}
+
+// relativeFile returns path of file in module cache, path relative to the main module root if function is in
+// package of it, or directory and name of file.
+func relativeFile(function, file string) string {
+	if i := strings.LastIndex(file, "/pkg/mod/"); i >= 0 {
+		return file[i+len("/pkg/mod/"):]
+	}
+	// Package directory relative to module root is the rest of its import path.
+	if module := mainModule(); module != "" {
+		pkg := packagePath(function)
+		if rel, ok := strings.CutPrefix(pkg, module); ok && (rel == "" || rel[0] == '/') {
+			return path.Join(strings.TrimPrefix(rel, "/"), path.Base(file))
+		}
+	}
+	// Like zapcore.EntryCaller.TrimmedPath.
+	if i := strings.LastIndexByte(file, '/'); i >= 0 {
+		if j := strings.LastIndexByte(file[:i], '/'); j >= 0 {
+			return file[j+1:]
+		}
+	}
+	return file
+}
+
+// mainModule returns path of the main module of binary, empty if it is unknown. Replaced by tests.
+var mainModule = func() func() string {
+	var once sync.Once
+	var module string
+	return func() string {
+		once.Do(func() {
+			if info, ok := debug.ReadBuildInfo(); ok {
+				module = info.Main.Path
+			}
+		})
+		return module
+	}
+}()
+
+// packagePath returns import path of package of function, e.g. github.com/acme/billing/handlers
+// of github.com/acme/billing/handlers.(*User).Get.
+func packagePath(function string) string {
+	slash := strings.LastIndexByte(function, '/')
+	if dot := strings.IndexByte(function[slash+1:], '.'); dot >= 0 {
+		return function[:slash+1+dot]
 	}
+	return function
 }
 
 func writeInt(in int) []byte {
@@ -0,0 +1,417 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+	"unicode/utf8"
+)
+
+type testStruct struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestFormatValue(t *testing.T) {
+	var nilErr *net.OpError
+	for _, tc := range []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, ""},
+		{"text", "text"},
+		{[]byte("bytes"), "bytes"},
+		{true, "true"},
+		{-42, "-42"},
+		{int8(-8), "-8"},
+		{int64(1 << 40), "1099511627776"},
+		{uint(7), "7"},
+		{uint64(1 << 63), "9223372036854775808"},
+		{float32(1.5), "1.5"},
+		{0.1, "0.1"},
+		{1500 * time.Millisecond, "1.5s"},
+		{time.Date(2023, 4, 5, 6, 7, 8, 9e6, time.UTC), "2023-04-05T06:07:08.009Z"},
+		{errors.New("timeout"), "timeout"},
+		{nilErr, ""},
+		{net.IPv4(10, 0, 0, 1), "10.0.0.1"},
+		{testStruct{"a", 1}, `{"name":"a","count":1}`},
+		{&testStruct{"b", 2}, `{"name":"b","count":2}`},
+		{map[string]int{"x": 1}, `{"x":1}`},
+		{[]string{"a", "b"}, `["a","b"]`},
+		{[]int(nil), ""},
+		{complex(1, 2), "(1+2i)"},
+	} {
+		if got := FormatValue(tc.value); got != tc.want {
+			t.Errorf("FormatValue(%#v) = %q, want %q", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestFormatValueWith(t *testing.T) {
+	failing := func(v interface{}) ([]byte, error) { return nil, errors.New("unsupported") }
+	if s, err := FormatValueWith(testStruct{"a", 1}, failing); err == nil || s != "{Name:a Count:1}" {
+		t.Fatalf("failed marshal = %q, %v", s, err)
+	}
+	// Marshaler is used only for complex values.
+	if s, err := FormatValueWith(42, failing); err != nil || s != "42" {
+		t.Fatalf("int = %q, %v", s, err)
+	}
+}
+
+// decodePairs is reference decoder of frame body: pairs terminated by empty line.
+func decodePairs(data []byte) ([][2]string, error) {
+	var pairs [][2]string
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return nil, errors.New("frame is not terminated")
+		}
+		line := string(data[:i])
+		data = data[i+1:]
+		if line == "" {
+			if len(data) != 0 {
+				return nil, fmt.Errorf("%d bytes after frame end", len(data))
+			}
+			return pairs, nil
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			pairs = append(pairs, [2]string{key, value})
+			continue
+		}
+		if len(data) < 4 {
+			return nil, errors.New("truncated value length")
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if len(data) < 4+size {
+			return nil, errors.New("truncated value")
+		}
+		pairs = append(pairs, [2]string{line, string(data[4 : 4+size])})
+		data = data[4+size:]
+	}
+}
+
+func FuzzWriteField(f *testing.F) {
+	for _, seed := range [][2]string{
+		{"msg", "plain"},
+		{"trace", "line 1\nline 2\n"},
+		{"cr", "a\rb"},
+		{"a=b", "c=d"},
+		{"", ""},
+		{"\n", "\n"},
+		{"k", "=\n=\n\n"},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, key, value string) {
+		var frame []byte
+		WriteField(key, value, &frame)
+		WriteField("next", "pair", &frame)
+		frame = append(frame, '\n')
+
+		pairs, err := decodePairs(frame)
+		if err != nil {
+			t.Fatalf("%q: %v", frame, err)
+		}
+		if len(pairs) != 2 || pairs[1] != [2]string{"next", "pair"} {
+			t.Fatalf("%q decoded as %q", frame, pairs)
+		}
+		if pairs[0][0] == "" || strings.ContainsAny(pairs[0][0], "=\r\n") || pairs[0][1] != value {
+			t.Fatalf("pair %q %q decoded as %q", key, value, pairs[0])
+		}
+		if !strings.ContainsAny(key, "=\r\n") && key != "" && pairs[0][0] != key {
+			t.Fatalf("key %q decoded as %q", key, pairs[0][0])
+		}
+	})
+}
+
+func TestSource(t *testing.T) {
+	tests := map[SourceFormat]string{
+		SourceFunc:     "main.main:42",
+		SourceFile:     "/app/main.go:42",
+		SourceFuncFile: "main.main /app/main.go:42",
+	}
+	for format, want := range tests {
+		if got := Source("main.main", "/app/main.go", 42, format); got != want {
+			t.Errorf("Source(%d) = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestSourceTrimmed(t *testing.T) {
+	const (
+		function = "github.com/acme/billing/handlers.(*User).Get"
+		file     = "/home/ci/build/billing/handlers/user.go"
+		cached   = "/root/go/pkg/mod/github.com/acme/lib@v1.2.0/db/query.go"
+	)
+	trim := []string{"github.com/acme/billing/", "/home/ci/build/billing/"}
+	tests := []struct {
+		format       SourceFormat
+		file         string
+		trimPrefixes []string
+		want         string
+	}{
+		{SourceFunc, file, nil, function + ":42"},
+		{SourceFunc, file, trim, "handlers.(*User).Get:42"},
+		{SourceShortFunc, file, nil, "handlers.(*User).Get:42"},
+		{SourceFile, file, trim, "handlers/user.go:42"},
+		{SourceFuncFile, file, trim, "handlers.(*User).Get handlers/user.go:42"},
+		{SourceRelFile, file, trim, "handlers/user.go:42"},
+		{SourceRelFile, "/home/ci/build/billing/internal/handlers/user.go", []string{"/home/ci/build/billing/"}, "internal/handlers/user.go:42"},
+		{SourceRelFile, file, nil, "handlers/user.go:42"},
+		{SourceRelFile, cached, nil, "github.com/acme/lib@v1.2.0/db/query.go:42"},
+		{SourceRelFile, "main.go", nil, "main.go:42"},
+	}
+	for _, tc := range tests {
+		if got := SourceTrimmed(function, tc.file, 42, tc.format, tc.trimPrefixes); got != tc.want {
+			t.Errorf("SourceTrimmed(%d, %q, %q) = %q, want %q", tc.format, tc.file, tc.trimPrefixes, got, tc.want)
+		}
+	}
+}
+
+func TestSourceRelFileNestedPackage(t *testing.T) {
+	defer func(f func() string) { mainModule = f }(mainModule)
+	mainModule = func() string { return "github.com/acme/billing" }
+
+	for _, tc := range []struct{ function, file, want string }{
+		{"github.com/acme/billing/internal/handlers.(*User).Get", "/home/ci/build/billing/internal/handlers/user.go", "internal/handlers/user.go:42"},
+		{"github.com/acme/billing.main", "/home/ci/build/billing/main.go", "main.go:42"},
+		// Not a package of the main module.
+		{"github.com/acme/billingtools/handlers.Get", "/home/ci/build/billingtools/handlers/user.go", "handlers/user.go:42"},
+	} {
+		if got := SourceTrimmed(tc.function, tc.file, 42, SourceRelFile, nil); got != tc.want {
+			t.Errorf("SourceTrimmed(%q, %q) = %q, want %q", tc.function, tc.file, got, tc.want)
+		}
+	}
+}
+
+func TestUserKey(t *testing.T) {
+	for _, key := range ReservedFields {
+		if got := UserKey(key, CollisionPrefix); got != "attr_"+key {
+			t.Errorf("UserKey(%s) = %q", key, got)
+		}
+		if got := UserKey(key, CollisionDrop); got != "" {
+			t.Errorf("UserKey(%s, CollisionDrop) = %q", key, got)
+		}
+	}
+	if got := UserKey("user", CollisionDrop); got != "user" {
+		t.Errorf("UserKey(user) = %q", got)
+	}
+}
+
+func TestFieldNames(t *testing.T) {
+	names := FieldNames{Message: "message", Level: "level"}
+	if got := names.Name("lvl"); got != "level" {
+		t.Errorf("Name(lvl) = %q", got)
+	}
+	if got := names.Name("app"); got != "app" {
+		t.Errorf("Name(app) = %q", got)
+	}
+	if got := names.UserKey("level", CollisionPrefix); got != "attr_level" {
+		t.Errorf("UserKey(level) = %q", got)
+	}
+	if got := names.UserKey("lvl", CollisionPrefix); got != "lvl" {
+		t.Errorf("UserKey(lvl) = %q", got)
+	}
+	if err := names.Check(); err != nil {
+		t.Error(err)
+	}
+	if err := (FieldNames{Level: "msg"}).Check(); err == nil {
+		t.Error("no error for duplicate name")
+	}
+	if err := names.CheckStaticFields(map[string]string{"message": "m", "lvl": "l"}); err == nil || !strings.Contains(err.Error(), "message") || strings.Contains(err.Error(), "lvl") {
+		t.Errorf("CheckStaticFields = %v", err)
+	}
+}
+
+func TestTimestampIn(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Clocks in Berlin go back from 03:00 to 02:00, both instants are 02:30 there.
+	before := time.Date(2023, 10, 29, 0, 30, 0, 0, time.UTC)
+	after := before.Add(time.Hour)
+	tests := []struct {
+		t      time.Time
+		format string
+		loc    *time.Location
+		want   string
+	}{
+		{before, "", nil, "232910003000.000"},
+		{before.In(berlin), "", nil, "232910003000.000"},
+		{before, "", time.UTC, "232910003000.000"},
+		{before, "", berlin, "232910023000.000"},
+		{after, "", berlin, "232910023000.000"},
+		{after, "", time.FixedZone("UTC-5", -5*3600), "232810203000.000"},
+		{after, time.RFC3339, berlin, "2023-10-29T02:30:00+01:00"},
+		{before, time.RFC3339, berlin, "2023-10-29T02:30:00+02:00"},
+	}
+	for _, test := range tests {
+		if got := TimestampIn(test.t, test.format, test.loc); got != test.want {
+			t.Errorf("TimestampIn(%v, %q, %v) = %q, want %q", test.t, test.format, test.loc, got, test.want)
+		}
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		value       string
+		maxLines    int
+		first, rest string
+	}{
+		{"single line", 0, "single line", ""},
+		{"first\nsecond\nthird", 0, "first", "second\nthird"},
+		{"first\r\nsecond\r\n", 0, "first", "second\r\n"},
+		{"first\n1\n2\n3\n4\n", 2, "first", "1\n2\n... 2 more lines"},
+		{"first\n1\n2\n3", 2, "first", "1\n2\n... 1 more lines"},
+		{"first\n1\n2\n", 2, "first", "1\n2\n"},
+		{"first\n1\n2", 2, "first", "1\n2"},
+	}
+	for _, test := range tests {
+		if first, rest := SplitLines(test.value, test.maxLines); first != test.first || rest != test.rest {
+			t.Errorf("SplitLines(%q, %d) = %q, %q, want %q, %q", test.value, test.maxLines, first, rest, test.first, test.rest)
+		}
+	}
+}
+
+func TestNewEventID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := map[string]bool{}
+	previous := ""
+	for i := 0; i < 1000; i++ {
+		id := NewEventID()
+		if !uuid.MatchString(id) || seen[id] {
+			t.Fatalf("NewEventID() = %q, duplicate %v", id, seen[id])
+		}
+		// Time prefix doesn't go back.
+		if id[:13] < previous {
+			t.Fatalf("NewEventID() = %q after %q", id, previous)
+		}
+		seen[id], previous = true, id[:13]
+	}
+	want := fmt.Sprintf("%012x", time.Now().UnixMilli())
+	if got := strings.ReplaceAll(NewEventID()[:13], "-", ""); got[:8] != want[:8] {
+		t.Errorf("time prefix = %s, want %s", got, want)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { NewEventID() }); allocs > 1 {
+		t.Errorf("NewEventID allocates %v times", allocs)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		value   string
+		maxSize int
+		want    string
+	}{
+		{"plain", 0, "plain"},
+		{"line 1\nline 2\r\n\tindented", 0, "line 1\nline 2\r\n\tindented"},
+		{"bad \xff\xfe bytes", 0, "bad \uFFFD bytes"},
+		{"nul\x00bell\x07del\x7f", 0, `nul\x00bell\x07del\x7f`},
+		{"привет", 0, "привет"},
+		{"0123456789", 4, "0123..."},
+		{"привет", 3, "п..."},
+		{"short", 10, "short"},
+	}
+	for _, test := range tests {
+		if got := Sanitize(test.value, test.maxSize); got != test.want {
+			t.Errorf("Sanitize(%q, %d) = %q, want %q", test.value, test.maxSize, got, test.want)
+		}
+		if _, cut := SanitizeValue(test.value, test.maxSize); cut != strings.HasSuffix(test.want, "...") {
+			t.Errorf("SanitizeValue(%q, %d) cut = %v", test.value, test.maxSize, cut)
+		}
+	}
+}
+
+func TestLimitFields(t *testing.T) {
+	fields := []Field{{"a", 1}, {"b", 2}, {"c", 3}}
+	if got, dropped := LimitFields(fields, 0); len(got) != 3 || dropped != 0 {
+		t.Fatalf("no limit: %v, %d", got, dropped)
+	}
+	if got, dropped := LimitFields(fields, 3); len(got) != 3 || dropped != 0 {
+		t.Fatalf("at limit: %v, %d", got, dropped)
+	}
+	got, dropped := LimitFields(fields, 1)
+	want := []Field{{"a", 1}, {FieldsTruncatedKey, "2"}}
+	if dropped != 2 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("LimitFields = %v, %d, want %v", got, dropped, want)
+	}
+}
+
+func FuzzSanitize(f *testing.F) {
+	for _, seed := range []string{"plain", "\xff\x00", "a\nb\r\tc", "привет\x1b[31m", ""} {
+		f.Add(seed, 8)
+	}
+	f.Fuzz(func(t *testing.T, value string, maxSize int) {
+		got := Sanitize(value, maxSize)
+		if !utf8.ValidString(got) {
+			t.Fatalf("Sanitize(%q) = %q is not valid UTF-8", value, got)
+		}
+		for i := 0; i < len(got); i++ {
+			if isControl(got[i]) {
+				t.Fatalf("Sanitize(%q) = %q has control character", value, got)
+			}
+		}
+		if maxSize > 0 && len(got) > maxSize+len("...") {
+			t.Fatalf("Sanitize(%q, %d) = %q is too long", value, maxSize, got)
+		}
+		if Sanitize(got, 0) != got {
+			t.Fatalf("Sanitize(%q) is not stable", got)
+		}
+	})
+}
+
+func TestProtocolFrame(t *testing.T) {
+	var fields []byte
+	WriteField("msg", "hello", &fields)
+	WriteField("trace", "a\nb", &fields)
+	tests := map[string]struct {
+		protocol Protocol
+		want     []byte
+	}{
+		"default": {Protocol{}, []byte("\x06\x03msg=hello\ntrace\n\x00\x00\x00\x03a\nb\n")},
+		"v3":      {DefaultProtocol, []byte("\x06\x03msg=hello\ntrace\n\x00\x00\x00\x03a\nb\n")},
+		"custom":  {Protocol{Header: []byte{7, 1}, Terminator: []byte{0}}, []byte("\x07\x01msg=hello\ntrace\n\x00\x00\x00\x03a\nb\x00")},
+	}
+	for name, test := range tests {
+		if got := test.protocol.Frame(fields); !bytes.Equal(got, test.want) {
+			t.Errorf("%s: frame = %q, want %q", name, got, test.want)
+		}
+		if got := test.protocol.Overhead(); got != len(test.want)-len(fields) {
+			t.Errorf("%s: overhead = %d", name, got)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	p := Protocol{CompressedHeader: []byte{6, 'z'}}
+	frames := bytes.Repeat(p.Frame([]byte("msg=repeated\n")), 100)
+	block, err := p.Compress(frames, CompressionGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(block, []byte{6, 'z'}) || int(binary.BigEndian.Uint32(block[2:])) != len(block)-6 {
+		t.Fatalf("block = %q", block)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(block[6:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil || !bytes.Equal(got, frames) {
+		t.Fatalf("decompressed %q, %v", got, err)
+	}
+	if _, err := p.Compress(frames, CompressionNone); err == nil {
+		t.Fatal("no error for CompressionNone")
+	}
+}
@@ -0,0 +1,155 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FieldEncoder is encoding of pairs in frame.
+type FieldEncoder interface {
+	// WriteField writes pair with value as is.
+	WriteField(key, value string, arr *[]byte)
+	// ReadField reads pair written by WriteField, io.EOF is returned if r is at its end.
+	ReadField(r *bufio.Reader) (Field, error)
+}
+
+var (
+	// TextEncoding writes pairs as key=value lines, see WriteField. It is default encoding.
+	TextEncoding FieldEncoder = textEncoding{}
+	// BinaryEncoding writes key and value as is, each after 4 bytes of its big-endian length, so any bytes
+	// are sent without escaping. It should be used only with server which accepts it.
+	BinaryEncoding FieldEncoder = binaryEncoding{}
+)
+
+// Encoding returns encoder, TextEncoding if it is nil.
+func Encoding(encoder FieldEncoder) FieldEncoder {
+	if encoder == nil {
+		return TextEncoding
+	}
+	return encoder
+}
+
+// WritePairWith writes pair with encoder, value is cut at "@@" like in WritePair.
+func WritePairWith(encoder FieldEncoder, key string, value string, arr *[]byte) {
+	Encoding(encoder).WriteField(key, PairValue(value), arr)
+}
+
+// PairValue returns value cut at "@@" where custom fields of message start.
+func PairValue(value string) string {
+	if i := strings.Index(value, "@@"); i != -1 {
+		return value[:i]
+	}
+	return value
+}
+
+type textEncoding struct{}
+
+func (textEncoding) WriteField(key, value string, arr *[]byte) {
+	WriteField(key, value, arr)
+}
+
+func (textEncoding) ReadField(r *bufio.Reader) (Field, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line == "" {
+			return Field{}, io.EOF
+		}
+		return Field{}, errors.New("pair is not terminated")
+	}
+	line = line[:len(line)-1]
+	if key, value, ok := strings.Cut(line, "="); ok {
+		return Field{key, value}, nil
+	}
+	value, err := readValue(r)
+	return Field{line, value}, err
+}
+
+type binaryEncoding struct{}
+
+func (binaryEncoding) WriteField(key, value string, arr *[]byte) {
+	*arr = binary.BigEndian.AppendUint32(*arr, uint32(len(key)))
+	*arr = append(*arr, key...)
+	*arr = binary.BigEndian.AppendUint32(*arr, uint32(len(value)))
+	*arr = append(*arr, value...)
+}
+
+func (binaryEncoding) ReadField(r *bufio.Reader) (Field, error) {
+	if _, err := r.Peek(1); err == io.EOF {
+		return Field{}, io.EOF
+	}
+	key, err := readValue(r)
+	if err != nil {
+		return Field{}, err
+	}
+	value, err := readValue(r)
+	return Field{key, value}, err
+}
+
+// readValue reads 4 bytes of big-endian length and the value.
+func readValue(r *bufio.Reader) (string, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return "", errors.New("truncated value length")
+	}
+	n := int64(binary.BigEndian.Uint32(size[:]))
+	// Not allocated at once, length may be garbage.
+	var value bytes.Buffer
+	if _, err := io.CopyN(&value, r, n); err != nil {
+		return "", errors.New("truncated value")
+	}
+	return value.String(), nil
+}
+
+// ReadFields decodes pairs written by TextEncoding, values are strings.
+func ReadFields(data []byte) ([]Field, error) {
+	return ReadFieldsWith(TextEncoding, data)
+}
+
+// ReadFieldsWith decodes pairs written by encoder, values are strings.
+func ReadFieldsWith(encoder FieldEncoder, data []byte) ([]Field, error) {
+	// Data is in memory, bigger buffer is not needed.
+	r := bufio.NewReaderSize(bytes.NewReader(data), len(data))
+	var fields []Field
+	for {
+		f, err := Encoding(encoder).ReadField(r)
+		if err == io.EOF {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+}
+
+// ReadFrame reads frame of protocol with pairs written by encoder, e.g. in LogDoc server stub.
+func ReadFrame(r *bufio.Reader, protocol Protocol, encoder FieldEncoder) ([]Field, error) {
+	header := make([]byte, len(protocol.header()))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header, protocol.header()) {
+		return nil, fmt.Errorf("unexpected frame header %v", header)
+	}
+	terminator := protocol.terminator()
+	var fields []Field
+	for {
+		if next, err := r.Peek(len(terminator)); err == nil && bytes.Equal(next, terminator) {
+			_, _ = r.Discard(len(terminator))
+			return fields, nil
+		}
+		f, err := Encoding(encoder).ReadField(r)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+}
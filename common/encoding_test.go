@@ -0,0 +1,87 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	for name, encoder := range map[string]FieldEncoder{"text": TextEncoding, "binary": BinaryEncoding} {
+		roundTrip := func(key, value string) bool {
+			if encoder == TextEncoding {
+				// Text keys are sanitized.
+				key = "key"
+			}
+			var data []byte
+			encoder.WriteField(key, value, &data)
+			encoder.WriteField("next", "pair", &data)
+			fields, err := ReadFieldsWith(encoder, data)
+			return err == nil && len(fields) == 2 && fields[0] == Field{key, value} && fields[1] == Field{"next", "pair"}
+		}
+		config := &quick.Config{MaxCount: 1000, Rand: rand.New(rand.NewSource(1))}
+		if err := quick.Check(roundTrip, config); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		// Random bytes, not only valid strings.
+		for i := 0; i < 1000; i++ {
+			key, value := make([]byte, rand.Intn(16)), make([]byte, rand.Intn(256))
+			rand.Read(key)
+			rand.Read(value)
+			if !roundTrip(string(key), string(value)) {
+				t.Fatalf("%s: %q=%q doesn't round-trip", name, key, value)
+			}
+		}
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	protocol := Protocol{Header: []byte{6, 4}}
+	var stream []byte
+	for _, value := range []string{"first", "\x00\n\xff=", ""} {
+		var fields []byte
+		BinaryEncoding.WriteField("msg", value, &fields)
+		BinaryEncoding.WriteField("", "empty key", &fields)
+		stream = append(stream, protocol.Frame(fields)...)
+	}
+	r := bufio.NewReader(bytes.NewReader(stream))
+	for _, value := range []string{"first", "\x00\n\xff=", ""} {
+		fields, err := ReadFrame(r, protocol, BinaryEncoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fields) != 2 || fields[0] != (Field{"msg", value}) || fields[1] != (Field{"", "empty key"}) {
+			t.Fatalf("fields = %q", fields)
+		}
+	}
+	if _, err := ReadFrame(r, protocol, BinaryEncoding); err != io.EOF {
+		t.Fatalf("error at stream end = %v", err)
+	}
+
+	var fields []byte
+	WriteField("trace", "a\nb", &fields)
+	got, err := ReadFrame(bufio.NewReader(bytes.NewReader(DefaultProtocol.Frame(fields))), Protocol{}, nil)
+	if err != nil || len(got) != 1 || got[0] != (Field{"trace", "a\nb"}) {
+		t.Fatalf("text frame = %q, %v", got, err)
+	}
+}
+
+func FuzzBinaryEncoding(f *testing.F) {
+	f.Add("msg", "plain")
+	f.Add("", "\x00\x00\x00\x05")
+	f.Add("\n", "\n\n")
+	f.Fuzz(func(t *testing.T, key, value string) {
+		var data []byte
+		BinaryEncoding.WriteField(key, value, &data)
+		fields, err := ReadFieldsWith(BinaryEncoding, data)
+		if err != nil || len(fields) != 1 || fields[0] != (Field{key, value}) {
+			t.Fatalf("%q=%q decoded as %q, %v", key, value, fields, err)
+		}
+		// Garbage doesn't panic or allocate by its length.
+		_, _ = ReadFieldsWith(BinaryEncoding, []byte(value))
+		_, _ = ReadFieldsWith(TextEncoding, []byte(value))
+	})
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"time"
+)
+
+// NewEventID returns UUIDv7: 48 bits of Unix time in milliseconds followed by random bits, so IDs of events
+// sort by time. Random bits are not cryptographically secure, IDs are only unique.
+func NewEventID() string {
+	var id [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	r := rand.Uint64()
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	id[6] = 0x70 | byte(r>>60)&0x0f // Version 7.
+	id[7] = byte(r >> 52)
+	r2 := rand.Uint64()
+	id[8] = 0x80 | byte(r2>>56)&0x3f // Variant 10.
+	for i := 9; i < 16; i++ {
+		id[i] = byte(r2 >> (64 - 8*(i-7)))
+	}
+
+	var s [36]byte
+	hex.Encode(s[0:8], id[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], id[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], id[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], id[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], id[10:])
+	return string(s[:])
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// KeyFilter keeps user fields by their keys: ones matching DenyKeys are dropped, and if AllowKeys are set,
+// only fields matching them are kept. Pattern is glob of path.Match, e.g. internal.*, or key, which also matches
+// keys of its namespace, e.g. http matches http.method.
+type KeyFilter struct {
+	AllowKeys []string
+	DenyKeys  []string
+}
+
+// Check returns error of malformed pattern.
+func (f KeyFilter) Check() error {
+	for _, patterns := range [][]string{f.AllowKeys, f.DenyKeys} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid key pattern %q: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Empty reports whether filter keeps all fields.
+func (f KeyFilter) Empty() bool {
+	return len(f.AllowKeys) == 0 && len(f.DenyKeys) == 0
+}
+
+// Filter returns value of field key to send, false if field is dropped, and number of dropped fields.
+// Fields of nested maps, such as zap namespaces in JSON format, are filtered by their full keys joined with ".",
+// and the map is copied then; map without fields left is dropped.
+func (f KeyFilter) Filter(key string, value interface{}) (interface{}, bool, int) {
+	if matchKeys(f.DenyKeys, key) {
+		return nil, false, 1
+	}
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		if len(f.AllowKeys) > 0 && !matchKeys(f.AllowKeys, key) {
+			return nil, false, 1
+		}
+		return value, true, 0
+	}
+	kept := make(map[string]interface{}, len(nested))
+	dropped := 0
+	for k, v := range nested {
+		v, ok, n := f.Filter(key+"."+k, v)
+		dropped += n
+		if ok {
+			kept[k] = v
+		}
+	}
+	if dropped == 0 {
+		return value, true, 0
+	}
+	return kept, len(kept) > 0, dropped
+}
+
+// MatchKey reports whether key matches pattern of KeyFilter.
+func MatchKey(pattern, key string) bool {
+	if key == pattern || strings.HasPrefix(key, pattern) && key[len(pattern)] == '.' {
+		return true
+	}
+	ok, _ := path.Match(pattern, key)
+	return ok
+}
+
+func matchKeys(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if MatchKey(p, key) {
+			return true
+		}
+	}
+	return false
+}
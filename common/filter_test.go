@@ -0,0 +1,59 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchKey(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"internal.*", "internal.dump", true},
+		{"internal.*", "internal.dump.heap", true},
+		{"internal.*", "internal", false},
+		{"internal", "internal.dump", true},
+		{"internal", "internals", false},
+		{"*_debug", "sql_debug", true},
+		{"user.?d", "user.id", true},
+		{"status", "status", true},
+	} {
+		if got := MatchKey(tc.pattern, tc.key); got != tc.want {
+			t.Errorf("MatchKey(%q, %q) = %v", tc.pattern, tc.key, got)
+		}
+	}
+}
+
+func TestKeyFilter(t *testing.T) {
+	f := KeyFilter{AllowKeys: []string{"http.method", "http.client", "status"}, DenyKeys: []string{"http.client.secret"}}
+	if _, ok, n := f.Filter("user", "bob"); ok || n != 1 {
+		t.Fatalf("not allowed = %v, %d", ok, n)
+	}
+	if v, ok, n := f.Filter("status", 200); !ok || n != 0 || v != 200 {
+		t.Fatalf("allowed = %v, %v, %d", v, ok, n)
+	}
+	nested := map[string]interface{}{
+		"method": "GET",
+		"path":   "/",
+		"client": map[string]interface{}{"ip": "10.0.0.1", "secret": "x"},
+	}
+	v, ok, n := f.Filter("http", nested)
+	want := map[string]interface{}{"method": "GET", "client": map[string]interface{}{"ip": "10.0.0.1"}}
+	if !ok || n != 2 || !reflect.DeepEqual(v, want) {
+		t.Fatalf("nested = %v, %v, %d", v, ok, n)
+	}
+	if len(nested) != 3 {
+		t.Fatal("nested map was modified")
+	}
+	if _, ok, n := f.Filter("debug", map[string]interface{}{"heap": 1, "gc": 2}); ok || n != 2 {
+		t.Fatalf("empty nested = %v, %d", ok, n)
+	}
+
+	if err := (KeyFilter{DenyKeys: []string{"a[", "ok"}}).Check(); err == nil {
+		t.Fatal("malformed pattern accepted")
+	}
+	if !(KeyFilter{}).Empty() {
+		t.Fatal("zero filter is not empty")
+	}
+}
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"runtime"
+	"strconv"
+)
+
+// GoroutineIDKey is key of field with ID of the logging goroutine.
+const GoroutineIDKey = "gid"
+
+// GoroutineID returns ID of the calling goroutine parsed from the first line of its stack, "goroutine 42 [running]:",
+// or 0 if it can't be parsed. It takes microseconds, so it should be called only if the ID is needed.
+func GoroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	const prefix = "goroutine "
+	if len(b) <= len(prefix) || string(b[:len(prefix)]) != prefix {
+		return 0
+	}
+	b = b[len(prefix):]
+	end := 0
+	for end < len(b) && b[end] >= '0' && b[end] <= '9' {
+		end++
+	}
+	id, err := strconv.ParseUint(string(b[:end]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
@@ -0,0 +1,33 @@
+package common
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestGoroutineID(t *testing.T) {
+	id := GoroutineID()
+	if id == 0 || GoroutineID() != id {
+		t.Fatalf("GoroutineID() = %d, then %d", id, GoroutineID())
+	}
+	ids := make([]uint64, 2)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i] = GoroutineID()
+		}(i)
+	}
+	wg.Wait()
+	if ids[0] == 0 || ids[0] == ids[1] || ids[0] == id || ids[1] == id {
+		t.Fatalf("goroutine IDs = %v, test goroutine %d", ids, id)
+	}
+}
+
+func BenchmarkGoroutineID(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		GoroutineID()
+	}
+}
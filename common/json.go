@@ -0,0 +1,76 @@
+package common
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Format is payload of frame.
+type Format int
+
+const (
+	FormatKV   Format = iota // Pairs written by FieldEncoder, default.
+	FormatJSON               // JSON object, see EncodeJSON.
+)
+
+// EncodeJSON encodes fields as JSON object with marshal, json.Marshal if nil. Values keep their types,
+// nested maps become nested objects; errors, durations and values JSON can't represent are written as
+// strings, like in key=value format. Later field with the same key wins. If marshal fails, all values are
+// written as strings and the error is returned too.
+func EncodeJSON(fields []Field, marshal Marshaler) ([]byte, error) {
+	if marshal == nil {
+		marshal = json.Marshal
+	}
+	object := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		object[f.Key] = jsonValue(f.Value)
+	}
+	data, err := marshal(object)
+	if err != nil {
+		return EncodeJSONStrings(fields), err
+	}
+	return data, nil
+}
+
+// EncodeJSONStrings encodes fields as JSON object with values formatted by FormatValue, it never fails.
+func EncodeJSONStrings(fields []Field) []byte {
+	object := make(map[string]string, len(fields))
+	for _, f := range fields {
+		object[f.Key] = FormatValue(f.Value)
+	}
+	data, _ := json.Marshal(object)
+	return data
+}
+
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error, time.Duration, complex64, complex128:
+		return FormatValue(v)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return FormatValue(v)
+		}
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return FormatValue(v)
+		}
+	case map[string]interface{}:
+		nested := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			nested[k] = jsonValue(item)
+		}
+		return nested
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = jsonValue(item)
+		}
+		return items
+	}
+	if kind := reflect.ValueOf(value).Kind(); kind == reflect.Func || kind == reflect.Chan {
+		return FormatValue(value)
+	}
+	return value
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	fields := []Field{
+		{"msg", "hello"},
+		{"status", 200},
+		{"ok", true},
+		{"ratio", 0.5},
+		{"nan", math.NaN()},
+		{"err", errors.New("timeout")},
+		{"took", 1500 * time.Millisecond},
+		{"at", time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)},
+		{"http", map[string]interface{}{"method": "GET", "took": time.Second}},
+		{"ids", []int{1, 2}},
+		{"callback", func() {}},
+		{"none", nil},
+		{"msg", "later wins"},
+	}
+	data, err := EncodeJSON(fields, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+	want := map[string]interface{}{
+		"msg":    "later wins",
+		"status": 200.0,
+		"ok":     true,
+		"ratio":  0.5,
+		"nan":    "NaN",
+		"err":    "timeout",
+		"took":   "1.5s",
+		"at":     "2023-04-05T06:07:08Z",
+		"http":   map[string]interface{}{"method": "GET", "took": "1s"},
+		"ids":    []interface{}{1.0, 2.0},
+		"none":   nil,
+	}
+	if _, ok := got["callback"].(string); !ok {
+		t.Fatalf("callback = %v", got["callback"])
+	}
+	delete(got, "callback")
+	if g, w := mustJSON(t, got), mustJSON(t, want); g != w {
+		t.Fatalf("JSON = %s, want %s", g, w)
+	}
+
+	failing := func(v interface{}) ([]byte, error) { return nil, errors.New("broken") }
+	data, err = EncodeJSON(fields[:2], failing)
+	if err == nil || string(data) != `{"msg":"hello","status":"200"}` {
+		t.Fatalf("EncodeJSON with failing marshaler = %s, %v", data, err)
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"os"
+	"strings"
+)
+
+// Keys of Kubernetes metadata fields, see KubernetesFields.
+const (
+	KubernetesPodKey         = "k8s.pod"
+	KubernetesNamespaceKey   = "k8s.namespace"
+	KubernetesNodeKey        = "k8s.node"
+	KubernetesContainerIDKey = "k8s.container_id"
+)
+
+// Files Kubernetes metadata is read from, replaced by tests.
+var (
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	cgroupFile                  = "/proc/self/cgroup"
+)
+
+// KubernetesFields returns static fields of the pod: k8s.pod, k8s.namespace and k8s.node from POD_NAME,
+// POD_NAMESPACE and NODE_NAME environment variables set by downward API, k8s.namespace from mounted service
+// account if the variable is not set, and k8s.container_id from /proc/self/cgroup. Values of overrides win and
+// other keys of them are added, override with empty value omits the field. Missing values are omitted, nil is
+// returned if there are none.
+func KubernetesFields(overrides map[string]string) map[string]string {
+	fields := map[string]string{}
+	lookup := func(key string, value func() string) {
+		v, ok := overrides[key]
+		if !ok {
+			v = value()
+		}
+		if v = strings.TrimSpace(v); v != "" {
+			fields[key] = v
+		}
+	}
+	lookup(KubernetesPodKey, func() string { return os.Getenv("POD_NAME") })
+	lookup(KubernetesNamespaceKey, func() string {
+		if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+			return namespace
+		}
+		data, _ := os.ReadFile(serviceAccountNamespaceFile)
+		return string(data)
+	})
+	lookup(KubernetesNodeKey, func() string { return os.Getenv("NODE_NAME") })
+	lookup(KubernetesContainerIDKey, func() string {
+		data, _ := os.ReadFile(cgroupFile)
+		return ContainerID(string(data))
+	})
+	for k, v := range overrides {
+		if _, ok := fields[k]; !ok && v != "" {
+			fields[k] = v
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// ContainerID returns ID of container in /proc/self/cgroup content, e.g.
+// 0::/kubepods.slice/kubepods-pod1.slice/cri-containerd-<id>.scope, or empty string if there is none.
+func ContainerID(cgroup string) string {
+	for _, line := range strings.Split(cgroup, "\n") {
+		// hierarchy-ID:controllers:path, ID is the last 64 hex digits part of path.
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		path := parts[2]
+		for len(path) > 0 {
+			i := strings.LastIndexAny(path, "/-")
+			part := strings.TrimSuffix(path[i+1:], ".scope")
+			if len(part) == 64 && isHex(part) {
+				return part
+			}
+			if i < 0 {
+				break
+			}
+			path = path[:i]
+		}
+	}
+	return ""
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
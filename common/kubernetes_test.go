@@ -0,0 +1,68 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testContainerID = "3f4e9a1b2c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f7"
+
+func TestContainerID(t *testing.T) {
+	for name, tc := range map[string]struct{ cgroup, want string }{
+		"containerd": {"0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod1.slice/cri-containerd-" + testContainerID + ".scope\n", testContainerID},
+		"docker":     {"12:memory:/kubepods/burstable/pod7a1c/" + testContainerID + "\n11:cpu:/kubepods\n", testContainerID},
+		"cgroup v2":  {"0::/\n", ""},
+		"empty":      {"", ""},
+		"short":      {"0::/docker/3f4e9a1b\n", ""},
+	} {
+		if got := ContainerID(tc.cgroup); got != tc.want {
+			t.Errorf("%s: ContainerID() = %q", name, got)
+		}
+	}
+}
+
+func TestKubernetesFields(t *testing.T) {
+	dir := t.TempDir()
+	defer func(namespace, cgroup string) {
+		serviceAccountNamespaceFile, cgroupFile = namespace, cgroup
+	}(serviceAccountNamespaceFile, cgroupFile)
+	serviceAccountNamespaceFile, cgroupFile = filepath.Join(dir, "namespace"), filepath.Join(dir, "cgroup")
+	t.Setenv("POD_NAME", "")
+	t.Setenv("POD_NAMESPACE", "")
+	t.Setenv("NODE_NAME", "")
+
+	if fields := KubernetesFields(nil); fields != nil {
+		t.Fatalf("fields outside Kubernetes = %v", fields)
+	}
+
+	if err := os.WriteFile(serviceAccountNamespaceFile, []byte("billing\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cgroupFile, []byte("0::/kubepods/pod1/"+testContainerID+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("POD_NAME", "billing-7d9f8b6c5-x2kqz")
+	want := map[string]string{
+		KubernetesPodKey:         "billing-7d9f8b6c5-x2kqz",
+		KubernetesNamespaceKey:   "billing",
+		KubernetesContainerIDKey: testContainerID,
+	}
+	if fields := KubernetesFields(nil); !reflect.DeepEqual(fields, want) {
+		t.Fatalf("fields = %v", fields)
+	}
+
+	t.Setenv("POD_NAMESPACE", "payments")
+	t.Setenv("NODE_NAME", "node-1")
+	want = map[string]string{
+		KubernetesPodKey:       "billing-7d9f8b6c5-x2kqz",
+		KubernetesNamespaceKey: "payments",
+		KubernetesNodeKey:      "node-7",
+		"k8s.cluster":          "eu-1",
+	}
+	fields := KubernetesFields(map[string]string{KubernetesNodeKey: "node-7", KubernetesContainerIDKey: "", "k8s.cluster": "eu-1"})
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("fields with overrides = %v", fields)
+	}
+}
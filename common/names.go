@@ -0,0 +1,104 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldNames renames fields written by appender, e.g. to level and message for dashboards built around them.
+// Empty names keep the default ones, see ReservedFields. Renamed fields are reserved instead of the default
+// names, so user field lvl is sent as is when Level is "level", and user field level gets ReservedPrefix.
+type FieldNames struct {
+	Message string // msg
+	App     string // app
+	Time    string // tsrc
+	Level   string // lvl
+	IP      string // ip
+	Host    string // host
+	PID     string // pid
+	Seq     string // seq
+	EventID string // event_id
+	Source  string // src
+	Body    string // body, written only for multi-line messages split in two fields
+}
+
+// Name returns name field is written with, key is its default name, e.g. "lvl".
+func (n FieldNames) Name(key string) string {
+	var name string
+	switch key {
+	case "msg":
+		name = n.Message
+	case "app":
+		name = n.App
+	case "tsrc":
+		name = n.Time
+	case "lvl":
+		name = n.Level
+	case "ip":
+		name = n.IP
+	case "host":
+		name = n.Host
+	case "pid":
+		name = n.PID
+	case "seq":
+		name = n.Seq
+	case "event_id":
+		name = n.EventID
+	case "src":
+		name = n.Source
+	case "body":
+		name = n.Body
+	}
+	if name == "" {
+		return key
+	}
+	return name
+}
+
+// Reserved reports whether key is name of one of ReservedFields.
+func (n FieldNames) Reserved(key string) bool {
+	for _, k := range ReservedFields {
+		if n.Name(k) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// UserKey returns key user field is written with, empty if the field is dropped.
+func (n FieldNames) UserKey(key string, collision Collision) string {
+	if !n.Reserved(key) {
+		return key
+	}
+	if collision == CollisionDrop {
+		return ""
+	}
+	return ReservedPrefix + key
+}
+
+// CheckStaticFields returns error if any of fields is named as one of ReservedFields.
+func (n FieldNames) CheckStaticFields(fields map[string]string) error {
+	var reserved []string
+	for _, f := range StaticFields(fields) {
+		if n.Reserved(f.Key) {
+			reserved = append(reserved, f.Key)
+		}
+	}
+	if len(reserved) > 0 {
+		return fmt.Errorf("fields %s are reserved by LogDoc", strings.Join(reserved, ", "))
+	}
+	return nil
+}
+
+// Check returns error if two fields get the same name, e.g. Level is "msg".
+func (n FieldNames) Check() error {
+	names := map[string]string{}
+	for _, k := range append(ReservedFields[:len(ReservedFields):len(ReservedFields)], "body") {
+		name := n.Name(k)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("fields %s and %s are both named %q", other, k, name)
+		}
+		names[name] = k
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redacted is value sent instead of value of redacted field.
+const Redacted = "[REDACTED]"
+
+// Redactor replaces values of fields with sensitive keys, such as password or authorization, before encoding.
+type Redactor struct {
+	keys   map[string]struct{}
+	redact func(key, value string) string
+}
+
+// NewRedactor returns Redactor of fields whose key, or its last part after ".", e.g. password of http.password,
+// is one of keys, case-insensitive. Values are replaced with redact(key, value), Redacted if redact is nil;
+// values other than strings are formatted with fmt.Sprint first. It returns nil if keys are empty.
+func NewRedactor(keys []string, redact func(key, value string) string) *Redactor {
+	if len(keys) == 0 {
+		return nil
+	}
+	r := &Redactor{keys: make(map[string]struct{}, len(keys)), redact: redact}
+	for _, k := range keys {
+		r.keys[strings.ToLower(k)] = struct{}{}
+	}
+	return r
+}
+
+// Matches reports whether value of field key is redacted.
+func (r *Redactor) Matches(key string) bool {
+	if r == nil {
+		return false
+	}
+	key = strings.ToLower(key)
+	if _, ok := r.keys[key]; ok {
+		return true
+	}
+	if i := strings.LastIndexByte(key, '.'); i >= 0 {
+		_, ok := r.keys[key[i+1:]]
+		return ok
+	}
+	return false
+}
+
+// Redact returns value of field key to send and whether it was redacted. Fields of nested maps, such as zap
+// namespaces in JSON format, are redacted too, and the map is copied then. Nil Redactor returns value as is.
+func (r *Redactor) Redact(key string, value interface{}) (interface{}, bool) {
+	if r == nil {
+		return value, false
+	}
+	if r.Matches(key) {
+		s, ok := value.(string)
+		if !ok {
+			s = fmt.Sprint(value)
+		}
+		if r.redact == nil {
+			return Redacted, true
+		}
+		return r.redact(key, s), true
+	}
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		return value, false
+	}
+	var redacted map[string]interface{}
+	for k, v := range nested {
+		if v, ok := r.Redact(k, v); ok {
+			if redacted == nil {
+				redacted = make(map[string]interface{}, len(nested))
+				for k, v := range nested {
+					redacted[k] = v
+				}
+			}
+			redacted[k] = v
+		}
+	}
+	if redacted == nil {
+		return value, false
+	}
+	return redacted, true
+}
+
+// KeepLast returns redaction function which keeps the last n characters of value and replaces the others
+// with "*", e.g. ************4242 for card number. Values of n characters or shorter are Redacted entirely.
+func KeepLast(n int) func(key, value string) string {
+	return func(key, value string) string {
+		count := utf8.RuneCountInString(value)
+		if count <= n {
+			return Redacted
+		}
+		i := len(value)
+		for kept := 0; kept < n; kept++ {
+			_, size := utf8.DecodeLastRuneInString(value[:i])
+			i -= size
+		}
+		return strings.Repeat("*", count-n) + value[i:]
+	}
+}
@@ -0,0 +1,55 @@
+package common
+
+import "testing"
+
+func TestRedactor(t *testing.T) {
+	r := NewRedactor([]string{"password", "Authorization"}, nil)
+	for _, tc := range []struct {
+		key      string
+		value    interface{}
+		want     interface{}
+		redacted bool
+	}{
+		{"password", "secret", Redacted, true},
+		{"PASSWORD", 42, Redacted, true},
+		{"authorization", "Bearer x", Redacted, true},
+		{"http.Password", "secret", Redacted, true},
+		{"password_hint", "pet", "pet", false},
+		{"user", "bob", "bob", false},
+	} {
+		got, redacted := r.Redact(tc.key, tc.value)
+		if got != tc.want || redacted != tc.redacted {
+			t.Errorf("Redact(%q, %v) = %v, %v", tc.key, tc.value, got, redacted)
+		}
+	}
+
+	nested := map[string]interface{}{"user": "bob", "password": "secret"}
+	got, redacted := r.Redact("http", nested)
+	if m := got.(map[string]interface{}); !redacted || m["password"] != Redacted || m["user"] != "bob" {
+		t.Fatalf("nested = %v, %v", got, redacted)
+	}
+	if nested["password"] != "secret" {
+		t.Fatal("nested map was modified")
+	}
+
+	if NewRedactor(nil, nil) != nil {
+		t.Fatal("redactor without keys")
+	}
+	var none *Redactor
+	if got, redacted := none.Redact("password", "secret"); got != "secret" || redacted {
+		t.Fatalf("nil redactor = %v, %v", got, redacted)
+	}
+}
+
+func TestKeepLast(t *testing.T) {
+	r := NewRedactor([]string{"card"}, KeepLast(4))
+	if got, _ := r.Redact("card", "4242424242424242"); got != "************4242" {
+		t.Fatalf("card = %v", got)
+	}
+	if got, _ := r.Redact("card", 4242); got != Redacted {
+		t.Fatalf("short = %v", got)
+	}
+	if got := KeepLast(2)("name", "Жанна"); got != "***на" {
+		t.Fatalf("unicode = %q", got)
+	}
+}
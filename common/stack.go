@@ -0,0 +1,136 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// StackTraceKey is field with stack trace of message.
+const StackTraceKey = "stacktrace"
+
+// DefaultStackTraceDepth is the number of frames in stack trace if depth is not set.
+const DefaultStackTraceDepth = 32
+
+// CallerStack returns stack trace of the calling goroutine above logging library, whose functions start with
+// one of libraries, e.g. "github.com/sirupsen/logrus.": frames up to and including the library are skipped, all
+// frames are kept if it is not found. At most depth frames are formatted, see FormatStack.
+func CallerStack(libraries []string, depth int) string {
+	if depth <= 0 {
+		depth = DefaultStackTraceDepth
+	}
+	// Frames of appender and library are skipped, so more are captured.
+	pcs := make([]uintptr, depth+64)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+
+	var callers []runtime.Frame
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		callers = append(callers, frame)
+		if !more {
+			break
+		}
+	}
+	start := 0
+	for i := 0; i < len(callers); i++ {
+		if hasPrefix(callers[i].Function, libraries) {
+			for i < len(callers) && hasPrefix(callers[i].Function, libraries) {
+				i++
+			}
+			start = i
+			break
+		}
+	}
+	return formatFrames(callers[start:], depth)
+}
+
+// FormatStack formats at most depth frames of return program counters, as runtime.Callers returns them,
+// like stack of panic output: function on one line and its file:line on the next one, indented with tab.
+func FormatStack(pcs []uintptr, depth int) string {
+	if depth <= 0 {
+		depth = DefaultStackTraceDepth
+	}
+	var callers []runtime.Frame
+	frames := runtime.CallersFrames(pcs)
+	for len(callers) < depth {
+		frame, more := frames.Next()
+		callers = append(callers, frame)
+		if !more {
+			break
+		}
+	}
+	return formatFrames(callers, depth)
+}
+
+func formatFrames(frames []runtime.Frame, depth int) string {
+	var b strings.Builder
+	written := 0
+	for _, frame := range frames {
+		if written == depth {
+			break
+		}
+		if frame.Function == "runtime.goexit" {
+			continue
+		}
+		if written > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(frame.Function)
+		b.WriteString("()\n\t")
+		b.WriteString(frame.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(frame.Line))
+		written++
+	}
+	return b.String()
+}
+
+// ValueStack returns program counters of stack trace carried by value, such as error of github.com/pkg/errors,
+// whose StackTrace method returns slice of uintptr based frames. Errors are unwrapped and the deepest stack,
+// the closest to origin of error, is returned. It returns nil if value has no stack.
+func ValueStack(value interface{}) []uintptr {
+	pcs := stackOf(value)
+	if err, ok := value.(error); ok {
+		for err = errors.Unwrap(err); err != nil; err = errors.Unwrap(err) {
+			if deeper := stackOf(err); deeper != nil {
+				pcs = deeper
+			}
+		}
+	}
+	return pcs
+}
+
+func stackOf(value interface{}) []uintptr {
+	if value == nil {
+		return nil
+	}
+	method := reflect.ValueOf(value).MethodByName("StackTrace")
+	if !method.IsValid() {
+		return nil
+	}
+	t := method.Type()
+	if t.NumIn() != 0 || t.NumOut() != 1 || t.Out(0).Kind() != reflect.Slice || t.Out(0).Elem().Kind() != reflect.Uintptr {
+		return nil
+	}
+	frames := method.Call(nil)[0]
+	if frames.Len() == 0 {
+		return nil
+	}
+	pcs := make([]uintptr, frames.Len())
+	for i := range pcs {
+		pcs[i] = uintptr(frames.Index(i).Uint())
+	}
+	return pcs
+}
+
+func hasPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Like github.com/pkg/errors.
+type frame uintptr
+
+type stackError struct {
+	msg   string
+	stack []frame
+	cause error
+}
+
+func (e *stackError) Error() string { return e.msg }
+
+func (e *stackError) Unwrap() error { return e.cause }
+
+func (e *stackError) StackTrace() []frame { return e.stack }
+
+func newStackError(msg string, cause error) *stackError {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	e := &stackError{msg: msg, cause: cause}
+	for _, pc := range pcs[:n] {
+		e.stack = append(e.stack, frame(pc))
+	}
+	return e
+}
+
+func originOfError() error {
+	return newStackError("origin", nil)
+}
+
+func libraryLog(depth int) string {
+	return CallerStack([]string{"github.com/LogDoc-org/logdoc-go-appender/common.library"}, depth)
+}
+
+func TestCallerStack(t *testing.T) {
+	stack := libraryLog(2)
+	lines := strings.Split(stack, "\n")
+	if len(lines) != 4 || lines[0] != "github.com/LogDoc-org/logdoc-go-appender/common.TestCallerStack()" ||
+		!strings.HasPrefix(lines[1], "\t") || !strings.Contains(lines[1], "stack_test.go:") {
+		t.Fatalf("stack = %q", stack)
+	}
+	if stack := CallerStack(nil, 0); !strings.HasPrefix(stack, "github.com/LogDoc-org/logdoc-go-appender/common.TestCallerStack()") {
+		t.Fatalf("stack without library = %q", stack)
+	}
+}
+
+func TestValueStack(t *testing.T) {
+	if pcs := ValueStack(fmt.Errorf("plain")); pcs != nil {
+		t.Fatalf("plain error stack = %v", pcs)
+	}
+	if pcs := ValueStack(nil); pcs != nil {
+		t.Fatalf("nil stack = %v", pcs)
+	}
+	err := fmt.Errorf("handler: %w", newStackError("wrapped", originOfError()))
+	stack := FormatStack(ValueStack(err), 1)
+	if !strings.HasPrefix(stack, "github.com/LogDoc-org/logdoc-go-appender/common.originOfError()\n\t") || strings.Count(stack, "\n") != 1 {
+		t.Fatalf("stack = %q", stack)
+	}
+}
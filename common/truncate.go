@@ -0,0 +1,85 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// truncatable reports whether value of field may be cut to fit the event into size limit,
+// service fields other than msg are kept as is.
+func (n FieldNames) truncatable(key string) bool {
+	return key == n.Name("msg") || !n.Reserved(key) && key != FieldsTruncatedKey
+}
+
+// Truncate cuts the longest values of msg and user fields so that encoded fields take at most maxBytes,
+// and adds truncated=true and original_size fields. Keys and service fields are kept. It returns false
+// if fields can't be brought under the limit.
+func Truncate(data []byte, maxBytes int) ([]byte, bool) {
+	return TruncateWith(TextEncoding, data, maxBytes)
+}
+
+// TruncateWith is Truncate for pairs written by encoder.
+func TruncateWith(encoder FieldEncoder, data []byte, maxBytes int) ([]byte, bool) {
+	return FieldNames{}.TruncateWith(encoder, data, maxBytes)
+}
+
+// TruncateWith is TruncateWith for fields renamed with n.
+func (n FieldNames) TruncateWith(encoder FieldEncoder, data []byte, maxBytes int) ([]byte, bool) {
+	if len(data) <= maxBytes {
+		return data, true
+	}
+	encoder = Encoding(encoder)
+	fields, err := ReadFieldsWith(encoder, data)
+	if err != nil {
+		return data, false
+	}
+	encode := func(limit int) []byte {
+		var result []byte
+		for _, f := range fields {
+			value := f.Value.(string)
+			if n.truncatable(f.Key) {
+				value = cutValue(value, limit)
+			}
+			encoder.WriteField(f.Key, value, &result)
+		}
+		encoder.WriteField("truncated", "true", &result)
+		encoder.WriteField("original_size", strconv.Itoa(len(data)), &result)
+		return result
+	}
+
+	// The largest value limit that fits.
+	longest := 0
+	for _, f := range fields {
+		if size := len(f.Value.(string)); n.truncatable(f.Key) && size > longest {
+			longest = size
+		}
+	}
+	lo, hi := 0, longest
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if len(encode(mid)) <= maxBytes {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	result := encode(lo)
+	return result, len(result) <= maxBytes
+}
+
+// cutValue cuts value longer than limit bytes at rune boundary, but not inside \xNN escape written
+// by Sanitize, and appends "...".
+func cutValue(value string, limit int) string {
+	if len(value) <= limit {
+		return value
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	if i := strings.LastIndex(value[:cut], `\x`); i >= 0 && i+4 > cut {
+		cut = i
+	}
+	return value[:cut] + "..."
+}
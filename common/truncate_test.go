@@ -0,0 +1,78 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTruncate(t *testing.T) {
+	var data []byte
+	WriteField("msg", strings.Repeat("m", 1000), &data)
+	WriteField("payload", strings.Repeat("p", 5000)+"\nend", &data)
+	WriteField("user", "bob", &data)
+	WriteField("app", "service", &data)
+	WriteField("src", strings.Repeat("s", 100), &data)
+
+	got, ok := Truncate(data, 600)
+	if !ok || len(got) > 600 {
+		t.Fatalf("Truncate = %d bytes, %v", len(got), ok)
+	}
+	pairs, err := decodePairs(append(got, '\n'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{}
+	for _, p := range pairs {
+		values[p[0]] = p[1]
+	}
+	if values["user"] != "bob" || values["app"] != "service" || values["src"] != strings.Repeat("s", 100) ||
+		values["truncated"] != "true" || values["original_size"] != strconv.Itoa(len(data)) {
+		t.Fatalf("values = %q", values)
+	}
+	if !strings.HasSuffix(values["msg"], "...") || !strings.HasSuffix(values["payload"], "...") {
+		t.Fatalf("values = %q", values)
+	}
+
+	if got, ok := Truncate(data, len(data)); !ok || string(got) != string(data) {
+		t.Fatalf("fitting fields are changed")
+	}
+	if _, ok := Truncate(data, 150); ok {
+		t.Fatalf("service fields are truncated")
+	}
+}
+
+func TestCutValue(t *testing.T) {
+	tests := []struct {
+		value string
+		limit int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"привет", 3, "п..."},
+		{`ab\x00cd`, 4, "ab..."},
+		{`ab\x00cd`, 6, `ab\x00...`},
+	}
+	for _, test := range tests {
+		if got := cutValue(test.value, test.limit); got != test.want {
+			t.Errorf("cutValue(%q, %d) = %q, want %q", test.value, test.limit, got, test.want)
+		}
+	}
+}
+
+func FuzzTruncate(f *testing.F) {
+	f.Add("msg", "value\nwith lines", 20)
+	f.Add("key", "\x00\xff", 5)
+	f.Fuzz(func(t *testing.T, key, value string, maxBytes int) {
+		var data []byte
+		WriteField("msg", Sanitize(value, 0), &data)
+		WriteField(key, value, &data)
+		got, ok := Truncate(data, maxBytes)
+		if ok && len(got) > maxBytes {
+			t.Fatalf("Truncate = %d bytes, limit %d", len(got), maxBytes)
+		}
+		if _, err := ReadFields(got); err != nil {
+			t.Fatalf("%q: %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package common
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// DurationFormat is how time.Duration values are sent.
+type DurationFormat int
+
+const (
+	DurationString  DurationFormat = iota // 1.5s, default.
+	DurationMillis                        // Milliseconds, 1500 for 1.5s, number in JSON.
+	DurationSeconds                       // Seconds, 0.003 for 3ms, number in JSON.
+)
+
+// ValueFormat is how durations, times and floats of user fields are sent, in key=value and JSON formats alike.
+// Zero value keeps defaults of FormatValue and EncodeJSON.
+type ValueFormat struct {
+	Duration DurationFormat
+	// TimeLayout is layout of times, time.RFC3339Nano if empty.
+	TimeLayout string
+	// FloatPrecision, if positive, is number of digits after point of floats, otherwise the shortest
+	// representation is used. NaN and infinities are kept as is.
+	FloatPrecision int
+	// MaxDepth limits nesting of structs, maps and slices encoded as JSON in key=value format,
+	// DefaultMaxValueDepth if 0, negative – no limit. See Format.
+	MaxDepth int
+}
+
+// DefaultMaxValueDepth is nesting limit of field values if ValueFormat.MaxDepth is not set.
+const DefaultMaxValueDepth = 32
+
+// ErrValueDepth is returned by Format for value nested deeper than MaxDepth or referencing itself.
+var ErrValueDepth = errors.New("value is nested too deep or references itself")
+
+// Format formats field value like FormatValueWith, but struct, map or slice nested deeper than MaxDepth or
+// referencing itself, so that neither marshal nor %+v would finish, is formatted as its type in brackets
+// with ErrValueDepth. Only fields, elements and values marshal gets to are checked, like exported fields
+// of structs and values without MarshalJSON method.
+func (f ValueFormat) Format(value interface{}, marshal Marshaler) (string, error) {
+	s, err := formatValue(value, marshal, f.maxDepth())
+	if err == ErrValueDepth {
+		return fmt.Sprintf("[%T]", value), err
+	}
+	if err != nil {
+		return fmt.Sprintf("%+v", value), err
+	}
+	return s, nil
+}
+
+func (f ValueFormat) maxDepth() int {
+	if f.MaxDepth == 0 {
+		return DefaultMaxValueDepth
+	}
+	return f.MaxDepth
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// withinDepth reports whether structs, maps and slices of v are nested at most depth levels, without cycles.
+// Pointers, maps and slices on the path are in visiting.
+func withinDepth(v reflect.Value, depth int, visiting map[uintptr]bool) bool {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return true
+		}
+		if v.Kind() == reflect.Pointer {
+			if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+				return true
+			}
+			if visiting[v.Pointer()] {
+				return false
+			}
+			visiting[v.Pointer()] = true
+			defer delete(visiting, v.Pointer())
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+	default:
+		return true
+	}
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return true
+	}
+	if depth == 0 {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).IsExported() && !withinDepth(v.Field(i), depth-1, visiting) {
+				return false
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return true
+		}
+		if visiting[v.Pointer()] {
+			return false
+		}
+		visiting[v.Pointer()] = true
+		defer delete(visiting, v.Pointer())
+		for it := v.MapRange(); it.Next(); {
+			if !withinDepth(it.Value(), depth-1, visiting) {
+				return false
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			if v.Len() == 0 || v.Type().Elem().Kind() == reflect.Uint8 {
+				// Empty or []byte.
+				return true
+			}
+			if visiting[v.Pointer()] {
+				return false
+			}
+			visiting[v.Pointer()] = true
+			defer delete(visiting, v.Pointer())
+		}
+		for i := 0; i < v.Len(); i++ {
+			if !withinDepth(v.Index(i), depth-1, visiting) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Apply returns value to encode instead of value: number of milliseconds or seconds for duration, formatted time
+// or rounded float as json.Number. Values of nested maps, such as zap namespaces in JSON format, are applied too.
+func (f ValueFormat) Apply(value interface{}) interface{} {
+	if f.Duration == DurationString && f.TimeLayout == "" && f.FloatPrecision <= 0 {
+		return value
+	}
+	switch v := value.(type) {
+	case time.Duration:
+		switch f.Duration {
+		case DurationMillis:
+			return f.float(float64(v)/float64(time.Millisecond), 64)
+		case DurationSeconds:
+			return f.float(v.Seconds(), 64)
+		}
+	case time.Time:
+		if f.TimeLayout != "" {
+			return v.Format(f.TimeLayout)
+		}
+	case float64:
+		return f.float(v, 64)
+	case float32:
+		return f.float(float64(v), 32)
+	case map[string]interface{}:
+		applied := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			applied[k] = f.Apply(item)
+		}
+		return applied
+	}
+	return value
+}
+
+func (f ValueFormat) float(v float64, bitSize int) interface{} {
+	if f.FloatPrecision <= 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		if bitSize == 32 {
+			return float32(v)
+		}
+		return v
+	}
+	return json.Number(strconv.FormatFloat(v, 'f', f.FloatPrecision, bitSize))
+}
@@ -0,0 +1,119 @@
+package common
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestValueFormat(t *testing.T) {
+	at := time.Date(2023, 4, 5, 6, 7, 8, 9e6, time.UTC)
+	tests := []struct {
+		name   string
+		format ValueFormat
+		value  interface{}
+		kv     string
+		json   string
+	}{
+		{"duration default", ValueFormat{}, 3 * time.Millisecond, "3ms", `"3ms"`},
+		{"duration millis", ValueFormat{Duration: DurationMillis}, 1500 * time.Microsecond, "1.5", `1.5`},
+		{"duration seconds", ValueFormat{Duration: DurationSeconds}, 3 * time.Millisecond, "0.003", `0.003`},
+		{"duration seconds rounded", ValueFormat{Duration: DurationSeconds, FloatPrecision: 2}, 1234 * time.Millisecond, "1.23", `1.23`},
+		{"time default", ValueFormat{}, at, "2023-04-05T06:07:08.009Z", `"2023-04-05T06:07:08.009Z"`},
+		{"time layout", ValueFormat{TimeLayout: time.RFC3339}, at, "2023-04-05T06:07:08Z", `"2023-04-05T06:07:08Z"`},
+		{"float default", ValueFormat{}, 2.0 / 3, "0.6666666666666666", `0.6666666666666666`},
+		{"float precision", ValueFormat{FloatPrecision: 3}, 2.0 / 3, "0.667", `0.667`},
+		{"float32 precision", ValueFormat{FloatPrecision: 1}, float32(2.25), "2.2", `2.2`},
+		{"float NaN", ValueFormat{FloatPrecision: 2}, math.NaN(), "NaN", `"NaN"`},
+		{"other", ValueFormat{FloatPrecision: 2}, 42, "42", `42`},
+	}
+	for _, tc := range tests {
+		value := tc.format.Apply(tc.value)
+		if got := FormatValue(value); got != tc.kv {
+			t.Errorf("%s: key=value %q, want %q", tc.name, got, tc.kv)
+		}
+		data, err := EncodeJSON([]Field{{Key: "v", Value: value}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(data), `{"v":`+tc.json+`}`; got != want {
+			t.Errorf("%s: JSON %s, want %s", tc.name, got, want)
+		}
+	}
+
+	nested := ValueFormat{Duration: DurationMillis}.Apply(map[string]interface{}{"took": time.Second, "path": "/"})
+	if m := nested.(map[string]interface{}); m["took"] != 1000.0 || m["path"] != "/" {
+		t.Fatalf("nested = %v", nested)
+	}
+}
+
+type node struct {
+	Name string
+	Next *node
+}
+
+func TestValueFormatDepth(t *testing.T) {
+	type header struct {
+		Name   string
+		Values []string
+	}
+	type request struct {
+		Method  string
+		Headers []header
+		Matrix  [][]int
+		At      time.Time
+		secret  string
+	}
+	req := request{
+		Method:  "GET",
+		Headers: []header{{"Accept", []string{"text/html", "application/json"}}},
+		Matrix:  [][]int{{1, 2}, {3}},
+		At:      time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC),
+		secret:  "hidden",
+	}
+	want := `{"Method":"GET","Headers":[{"Name":"Accept","Values":["text/html","application/json"]}],"Matrix":[[1,2],[3]],"At":"2023-04-05T06:07:08Z"}`
+	if s, err := FormatValueWith(req, nil); err != nil || s != want {
+		t.Fatalf("request = %s, %v", s, err)
+	}
+	if s, err := (ValueFormat{MaxDepth: 4}).Format(&req, nil); err != nil || s != want {
+		t.Fatalf("request within depth = %s, %v", s, err)
+	}
+	if s, err := (ValueFormat{MaxDepth: 3}).Format(req, nil); err != ErrValueDepth || s != "[common.request]" {
+		t.Fatalf("request over depth = %s, %v", s, err)
+	}
+
+	loop := &node{Name: "a"}
+	loop.Next = &node{Name: "b", Next: loop}
+	if s, err := FormatValueWith(loop, nil); err != ErrValueDepth || s != "[*common.node]" {
+		t.Fatalf("cyclic list = %s, %v", s, err)
+	}
+	self := map[string]interface{}{"name": "self"}
+	self["self"] = self
+	if s, err := FormatValueWith(self, nil); err != ErrValueDepth || s != "[map[string]interface {}]" {
+		t.Fatalf("cyclic map = %s, %v", s, err)
+	}
+	// Shared, but not cyclic.
+	shared := &node{Name: "shared"}
+	if s, err := FormatValueWith([]*node{shared, shared}, nil); err != nil || s != `[{"Name":"shared","Next":null},{"Name":"shared","Next":null}]` {
+		t.Fatalf("shared = %s, %v", s, err)
+	}
+
+	deep := &node{Name: "0"}
+	for i := 1; i < 100; i++ {
+		deep = &node{Name: "n", Next: deep}
+	}
+	if _, err := FormatValueWith(deep, nil); err != ErrValueDepth {
+		t.Fatalf("deep list error = %v", err)
+	}
+	if _, err := (ValueFormat{MaxDepth: -1}).Format(deep, nil); err != nil {
+		t.Fatalf("deep list without limit error = %v", err)
+	}
+
+	type withChan struct {
+		Name string
+		C    chan int
+	}
+	if s, err := FormatValueWith(withChan{Name: "c"}, nil); err == nil || s != "{Name:c C:<nil>}" {
+		t.Fatalf("marshal error = %s, %v", s, err)
+	}
+}
@@ -0,0 +1,177 @@
+package logrusld
+
+import (
+	"time"
+
+	"github.com/LogDoc-org/logdoc-go-appender/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBatchInterval        = 50 * time.Millisecond
+	defaultCompressionThreshold = 1024
+)
+
+// batching reports whether async workers write several messages at once.
+func (h *Hook) batching() bool {
+	return h.BatchSize > 1 && !h.isDatagram() && !h.isHTTP()
+}
+
+// sendBatches sends messages from async buffer in batches of up to BatchSize messages, at least every
+// BatchInterval. Error and more severe messages are sent at once with the batch collected before them.
+func (h *Hook) sendBatches() {
+	interval := h.BatchInterval
+	if interval <= 0 {
+		interval = defaultBatchInterval
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	var batch []batched
+	flush := func() {
+		if len(batch) > 0 {
+			if err := h.protect("sendBatch", func() { h.sendBatch(batch) }); err != nil {
+				h.deadLetterBatch(batch, err)
+			}
+			h.pending.Add(-int64(len(batch)))
+			batch = batch[:0]
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(interval)
+	}
+
+	for {
+		select {
+		case msg := <-h.fireChannel:
+			batch = append(batch, batched{msg: msg, fields: h.encoded(msg)})
+			if len(batch) >= h.BatchSize || msg.entry.Level <= h.flushLevel() {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		case <-h.flushBatch:
+			// Collect queued messages first.
+			for collecting := true; collecting; {
+				select {
+				case msg := <-h.fireChannel:
+					batch = append(batch, batched{msg: msg, fields: h.encoded(msg)})
+					if len(batch) >= h.BatchSize {
+						flush()
+					}
+				default:
+					collecting = false
+				}
+			}
+			flush()
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// flushLevel returns the least severe level written without waiting for batch.
+func (h *Hook) flushLevel() logrus.Level {
+	if h.FlushOnLevel == logrus.PanicLevel {
+		return logrus.ErrorLevel
+	}
+	return h.FlushOnLevel
+}
+
+// requestFlush asks async workers to write collected batch now.
+func (h *Hook) requestFlush() {
+	if h.flushBatch != nil {
+		select {
+		case h.flushBatch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// batched is encoded message waiting in batch.
+type batched struct {
+	msg    queued
+	fields []byte
+}
+
+// sendBatch writes messages with a single write.
+func (h *Hook) sendBatch(batch []batched) {
+	if h.keeping() && h.keptPending() {
+		// Keep order: new messages go after the kept ones.
+		h.keepBatch(batch)
+		return
+	}
+	if !h.keeping() && !h.waitConnected() {
+		h.drop(len(batch))
+		h.deadLetterBatch(batch, ErrNotConnected)
+		h.reportError(ErrNotConnected)
+		return
+	}
+
+	var data []byte
+	for _, b := range batch {
+		if h.oversizedEvent(b.fields) {
+			h.oversized.Add(1)
+			h.deadLetter(b.msg, b.fields, ErrOversized)
+			h.reportError(ErrOversized)
+			continue
+		}
+		data = append(data, h.frame(b.fields)...)
+	}
+	if len(data) == 0 {
+		return
+	}
+	// Compressed once, retries write the same block.
+	err := h.compress(&data)
+	if err == nil {
+		err = h.retry(func() error { return h.write(data) })
+	}
+	switch {
+	case err == nil:
+		if h.Fallback != nil {
+			h.fallbackRecovered()
+		}
+	case h.keeping():
+		h.keepBatch(batch)
+	case err == ErrNotConnected || err == ErrCircuitOpen:
+		h.drop(len(batch))
+		h.deadLetterBatch(batch, err)
+		h.reportError(err)
+	default:
+		h.deadLetterBatch(batch, err)
+		h.reportError(err)
+		if h.OnError == nil {
+			logrus.Errorf("Ошибка записи в соединение, %s", err.Error())
+		}
+	}
+}
+
+// compress replaces batch with compressed block, if Compression is set and batch is big enough.
+func (h *Hook) compress(data *[]byte) error {
+	if h.Compression == common.CompressionNone || len(*data) < h.compressionThreshold() {
+		return nil
+	}
+	block, err := h.Protocol.Compress(*data, h.Compression)
+	if err != nil {
+		return err
+	}
+	*data = block
+	return nil
+}
+
+func (h *Hook) compressionThreshold() int {
+	if h.CompressionThreshold > 0 {
+		return h.CompressionThreshold
+	}
+	return defaultCompressionThreshold
+}
+
+func (h *Hook) deadLetterBatch(batch []batched, err error) {
+	for _, b := range batch {
+		h.deadLetter(b.msg, b.fields, err)
+	}
+}
@@ -0,0 +1,238 @@
+package logrusld
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LogDoc-org/logdoc-go-appender/common"
+	"github.com/sirupsen/logrus"
+)
+
+func TestBatching(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.BatchSize = 10
+	hook.BatchInterval = time.Hour
+	hook.MakeAsync()
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []string
+	for i := 0; i < 25; i++ {
+		want = append(want, fmt.Sprint("batched ", i))
+		_ = hook.Fire(testEntry(want[i]))
+	}
+	// Partial batch is written by Flush.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := hook.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitMessages(t, frames, want)
+	if writes := hook.PoolStats()[0].Writes; writes != 3 {
+		t.Fatalf("%d writes for 25 messages", writes)
+	}
+
+	// Error doesn't wait for the batch to fill.
+	_ = hook.Fire(testEntry("info"))
+	entry := testEntry("error")
+	entry.Level = logrus.ErrorLevel
+	_ = hook.Fire(entry)
+	waitMessages(t, frames, []string{"info", "error"})
+}
+
+func BenchmarkAsyncBatching(b *testing.B) {
+	for _, size := range []int{1, 100} {
+		b.Run(fmt.Sprint("batch=", size), func(b *testing.B) {
+			srv := startTestServer(b, "127.0.0.1:0")
+			defer srv.stop()
+			go func() {
+				for range srv.lines {
+				}
+			}()
+
+			hook := NewLazyHook("tcp", srv.ln.Addr().String())
+			hook.BatchSize = size
+			hook.OverflowPolicy = OverflowBlock
+			hook.MakeAsync()
+			defer hook.Close()
+			if err := hook.Connect(); err != nil {
+				b.Fatal(err)
+			}
+			entry := testEntry("benchmark")
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = hook.Fire(entry)
+			}
+			_ = hook.Flush(context.Background())
+			b.ReportMetric(float64(hook.PoolStats()[0].Writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func TestFlushOnLevelSync(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.BatchSize = 10
+	hook.BatchInterval = time.Hour
+	hook.FlushOnLevel = logrus.WarnLevel
+	hook.FlushOnLevelSync = true
+	hook.MakeAsync()
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = hook.Fire(testEntry("info"))
+	entry := testEntry("warning")
+	entry.Level = logrus.WarnLevel
+	_ = hook.Fire(entry)
+	if hook.pending.Load() > 1 {
+		t.Fatalf("%d messages queued", hook.pending.Load())
+	}
+	// Warning is written by Fire, info is flushed with it, both without waiting for BatchInterval.
+	received := map[string]bool{}
+	for len(received) < 2 {
+		select {
+		case f := <-frames:
+			received[f["msg"]] = true
+		case <-time.After(time.Second):
+			t.Fatalf("received only %v", received)
+		}
+	}
+	if !received["info"] || !received["warning"] {
+		t.Fatalf("received %v", received)
+	}
+}
+
+// recordingConn fails the first write and records the rest.
+type recordingConn struct {
+	net.Conn
+	mu     *sync.Mutex
+	writes *[][]byte
+	failed *atomic.Bool
+}
+
+func (c recordingConn) Write(b []byte) (int, error) {
+	if !c.failed.Swap(true) {
+		return 0, errors.New("connection reset")
+	}
+	c.mu.Lock()
+	*c.writes = append(*c.writes, append([]byte(nil), b...))
+	c.mu.Unlock()
+	return len(b), nil
+}
+
+func TestBatchCompression(t *testing.T) {
+	var mu sync.Mutex
+	var writes [][]byte
+	var failed atomic.Bool
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.BatchSize = 50
+	hook.BatchInterval = time.Hour
+	hook.MaxSendRetries = 3
+	hook.ReconnectBaseDelay = time.Millisecond
+	hook.Compression = common.CompressionGzip
+	hook.CompressionThreshold = 512
+	hook.Protocol.CompressedHeader = []byte{6, 'z'}
+	hook.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		client, _ := net.Pipe()
+		return recordingConn{Conn: client, mu: &mu, writes: &writes, failed: &failed}, nil
+	}
+	if err := hook.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	hook.MakeAsync()
+	defer hook.Close()
+
+	for i := 0; i < 50; i++ {
+		_ = hook.Fire(testEntry(fmt.Sprint("compressed ", i)))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hook.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = hook.Fire(testEntry("small"))
+	if err := hook.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(writes) != 2 {
+		t.Fatalf("%d writes", len(writes))
+	}
+	block := writes[0]
+	if !bytes.HasPrefix(block, []byte{6, 'z'}) || int(binary.BigEndian.Uint32(block[2:])) != len(block)-6 {
+		t.Fatalf("block = %q", block)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(block[6:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(frames, []byte("msg=compressed ")); n != 50 {
+		t.Fatalf("%d messages in block", n)
+	}
+	// Batch below threshold is sent as is.
+	if !bytes.HasPrefix(writes[1], []byte{6, 3}) || !bytes.Contains(writes[1], []byte("msg=small\n")) {
+		t.Fatalf("write = %q", writes[1])
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.Compression = common.CompressionGzip
+	if err := hook.Validate(); err == nil || !strings.Contains(err.Error(), "CompressedHeader") {
+		t.Fatalf("Validate = %v", err)
+	}
+}
+
+func BenchmarkBatchCompression(b *testing.B) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.Protocol.CompressedHeader = []byte{6, 'z'}
+	var batch []byte
+	for i := 0; i < 100; i++ {
+		entry := testEntry(fmt.Sprint("request handled @@status=200@path=/api/v1/items/", i))
+		entry.Data = logrus.Fields{"request_id": fmt.Sprintf("%016x", i), "duration": time.Duration(i) * time.Millisecond}
+		batch = append(batch, hook.frame(hook.encodeFields(entry, "benchmark", ""))...)
+	}
+	for name, compression := range map[string]common.Compression{"none": common.CompressionNone, "gzip": common.CompressionGzip} {
+		b.Run(name, func(b *testing.B) {
+			hook.Compression = compression
+			size := 0
+			b.SetBytes(int64(len(batch)))
+			for i := 0; i < b.N; i++ {
+				data := batch
+				if err := hook.compress(&data); err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.ReportMetric(float64(size), "wire-bytes/batch")
+		})
+	}
+}
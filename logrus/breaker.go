@@ -0,0 +1,116 @@
+package logrusld
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultBreakerCooldown = 5 * time.Second
+
+// BreakerState is state of circuit breaker around LogDoc connection.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Messages are sent.
+	BreakerOpen                         // Messages are not sent until cooldown passes.
+	BreakerHalfOpen                     // One message probes connection, the rest are not sent.
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker counts consecutive failed sends, guarded by its own lock.
+type breaker struct {
+	sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trips    uint64
+	rejected uint64
+}
+
+// breakerAllow reports whether message may be sent. After cooldown the first caller probes connection.
+func (h *Hook) breakerAllow() bool {
+	b := &h.breaker
+	b.Lock()
+	switch {
+	case b.state == BreakerClosed:
+		b.Unlock()
+		return true
+	case b.state == BreakerOpen && time.Since(b.openedAt) >= h.breakerCooldown():
+		b.state = BreakerHalfOpen
+		b.Unlock()
+		h.notifyBreaker(BreakerHalfOpen)
+		return true
+	}
+	b.rejected++
+	b.Unlock()
+	return false
+}
+
+// breakerResult records result of allowed send.
+func (h *Hook) breakerResult(err error) {
+	b := &h.breaker
+	b.Lock()
+	if err == nil || err == ErrOversized {
+		closed := b.state != BreakerClosed
+		b.state, b.failures = BreakerClosed, 0
+		b.Unlock()
+		if closed {
+			h.notifyBreaker(BreakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == BreakerOpen || (b.state == BreakerClosed && b.failures < h.BreakerThreshold) {
+		b.Unlock()
+		return
+	}
+	// Threshold is reached or probe failed.
+	b.state, b.openedAt = BreakerOpen, time.Now()
+	b.trips++
+	b.Unlock()
+	h.notifyBreaker(BreakerOpen)
+}
+
+func (h *Hook) notifyBreaker(state BreakerState) {
+	if h.OnBreakerState != nil {
+		h.OnBreakerState(state)
+	}
+}
+
+func (h *Hook) breakerCooldown() time.Duration {
+	if h.BreakerCooldown > 0 {
+		return h.BreakerCooldown
+	}
+	return defaultBreakerCooldown
+}
+
+// Breaker returns circuit breaker state.
+func (h *Hook) Breaker() BreakerState {
+	h.breaker.Lock()
+	defer h.breaker.Unlock()
+	return h.breaker.state
+}
+
+// BreakerTrips returns number of times circuit breaker opened.
+func (h *Hook) BreakerTrips() uint64 {
+	h.breaker.Lock()
+	defer h.breaker.Unlock()
+	return h.breaker.trips
+}
+
+// BreakerRejected returns number of messages not sent because circuit breaker was open.
+func (h *Hook) BreakerRejected() uint64 {
+	h.breaker.Lock()
+	defer h.breaker.Unlock()
+	return h.breaker.rejected
+}
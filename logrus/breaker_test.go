@@ -0,0 +1,114 @@
+package logrusld
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeConn counts writes and fails them while down is set.
+type fakeConn struct {
+	net.Conn
+	down     *atomic.Bool
+	attempts *atomic.Int32
+}
+
+func (c fakeConn) Write(b []byte) (int, error) {
+	c.attempts.Add(1)
+	if c.down.Load() {
+		return 0, errors.New("connection reset")
+	}
+	return len(b), nil
+}
+
+func TestBreaker(t *testing.T) {
+	var down atomic.Bool
+	var attempts atomic.Int32
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.ReconnectBaseDelay = time.Millisecond
+	hook.MaxReconnectDelay = time.Millisecond
+	hook.BreakerThreshold = 3
+	hook.BreakerCooldown = 50 * time.Millisecond
+	hook.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		client, _ := net.Pipe()
+		return fakeConn{Conn: client, down: &down, attempts: &attempts}, nil
+	}
+	var mu sync.Mutex
+	var states []BreakerState
+	hook.OnBreakerState = func(state BreakerState) {
+		mu.Lock()
+		states = append(states, state)
+		mu.Unlock()
+	}
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := hook.encodeFields(testEntry("breaker"), "", "")
+	down.Store(true)
+	for i := 0; i < 3; i++ {
+		if err := hook.sendFields(fields); err == nil || err == ErrCircuitOpen {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	if hook.Breaker() != BreakerOpen {
+		t.Fatalf("breaker is %s after 3 failures", hook.Breaker())
+	}
+	touched := attempts.Load()
+	for i := 0; i < 10; i++ {
+		if err := hook.sendFields(fields); err != ErrCircuitOpen {
+			t.Fatalf("send with open breaker: %v", err)
+		}
+	}
+	if attempts.Load() != touched || hook.BreakerRejected() != 10 {
+		t.Fatalf("open breaker wrote %d times, rejected %d", attempts.Load()-touched, hook.BreakerRejected())
+	}
+
+	// Failed probe opens breaker again.
+	time.Sleep(60 * time.Millisecond)
+	waitConnected(t, hook)
+	if err := hook.sendFields(fields); err == nil || err == ErrCircuitOpen {
+		t.Fatalf("probe: %v", err)
+	}
+	if hook.Breaker() != BreakerOpen || hook.BreakerTrips() != 2 {
+		t.Fatalf("breaker is %s, trips %d after failed probe", hook.Breaker(), hook.BreakerTrips())
+	}
+
+	// Successful probe closes it.
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	waitConnected(t, hook)
+	if err := hook.sendFields(fields); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if hook.Breaker() != BreakerClosed {
+		t.Fatalf("breaker is %s after successful probe", hook.Breaker())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(states) != len(want) {
+		t.Fatalf("states = %v", states)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Fatalf("states = %v", states)
+		}
+	}
+}
+
+func waitConnected(t *testing.T, hook *Hook) {
+	t.Helper()
+	for i := 0; !hook.Connected(); i++ {
+		if i == 100 {
+			t.Fatal("not reconnected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
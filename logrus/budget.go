@@ -0,0 +1,50 @@
+package logrusld
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const retryBudgetPeriod = time.Minute
+
+// retryBudget counts messages retried in the current period, guarded by its own lock.
+type retryBudget struct {
+	sync.Mutex
+	start  time.Time
+	used   int
+	warned bool
+}
+
+// retryAllowed reports whether one more message may be retried. When budget is exhausted,
+// single error is logged per period instead of one per message.
+func (h *Hook) retryAllowed() bool {
+	if h.RetryBudget <= 0 {
+		return true
+	}
+	b := &h.budget
+	b.Lock()
+	now := time.Now()
+	if now.Sub(b.start) >= retryBudgetPeriod {
+		b.start, b.used, b.warned = now, 0, false
+	}
+	if b.used < h.RetryBudget {
+		b.used++
+		b.Unlock()
+		return true
+	}
+	warn := !b.warned
+	b.warned = true
+	b.Unlock()
+	if warn {
+		logrus.Errorf("Исчерпан бюджет повторов LogDoc (%d сообщений в минуту), сообщения не отправляются повторно, проверьте адрес %s",
+			h.RetryBudget, h.address)
+	}
+	return false
+}
+
+// GivenUp returns number of sends that failed after all allowed retries.
+func (h *Hook) GivenUp() uint64 {
+	return h.givenUp.Load()
+}
@@ -0,0 +1,60 @@
+package logrusld
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRetryBudget(t *testing.T) {
+	var output bytes.Buffer
+	logrus.SetOutput(&output)
+	defer logrus.SetOutput(os.Stderr)
+
+	down := &atomic.Bool{}
+	down.Store(true)
+	var attempts atomic.Int32
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.ReconnectBaseDelay = time.Millisecond
+	hook.MaxReconnectDelay = time.Millisecond
+	hook.MaxSendRetries = 3
+	hook.RetryBudget = 2
+	hook.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		client, _ := net.Pipe()
+		return fakeConn{Conn: client, down: down, attempts: &attempts}, nil
+	}
+	var dead atomic.Int32
+	hook.OnDeadLetter = func(payload []byte, entry *logrus.Entry, err error) {
+		dead.Add(1)
+	}
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		_ = hook.Fire(testEntry("misconfigured"))
+	}
+	if hook.Retries() != 2*3 {
+		t.Fatalf("retries = %d", hook.Retries())
+	}
+	if hook.GivenUp() != 10 {
+		t.Fatalf("given up = %d", hook.GivenUp())
+	}
+	for i := 0; dead.Load() < 10; i++ {
+		if i == 100 {
+			t.Fatalf("%d dead letters", dead.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := strings.Count(output.String(), "Исчерпан бюджет повторов"); n != 1 {
+		t.Fatalf("budget error logged %d times", n)
+	}
+}
@@ -0,0 +1,905 @@
+package logrusld
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDialTimeout              = 5 * time.Second
+	defaultWriteTimeout             = 5 * time.Second
+	defaultKeepAlive                = 30 * time.Second
+	defaultCloseTimeout             = time.Second
+	defaultReconnectBaseDelay       = 100 * time.Millisecond
+	defaultReconnectDelayMultiplier = 2
+	defaultMaxReconnectDelay        = 10 * time.Second
+	defaultMaxDatagramSize          = 65507 // Max UDP payload over IPv4.
+)
+
+// Errors returned by Fire if ReturnErrors or Sync is set, ErrClosed is returned always. Other delivery errors,
+// including network ones, are passed to OnError and Errors.
+var (
+	ErrNotConnected = errors.New("no connection to LogDoc server")
+	ErrClosed       = errors.New("LogDoc hook is closed")
+	ErrOversized    = errors.New("message exceeds max datagram or event size")
+	ErrQueueFull    = errors.New("LogDoc async buffer is full")
+	ErrCircuitOpen  = errors.New("LogDoc circuit breaker is open")
+)
+
+// link is one managed connection to LogDoc server with its own reconnect state.
+// Its fields, except writeMu and counters, are guarded by hook lock.
+type link struct {
+	hook           *Hook
+	writeMu        sync.Mutex
+	conn           net.Conn
+	endpoint       *endpoint // Endpoint conn is connected to.
+	localIP        string    // Local IP of conn, empty for unix socket.
+	dialed         bool
+	reconnecting   bool
+	reconnectDelay time.Duration
+	nextDial       time.Time
+	writes         atomic.Uint64
+	errors         atomic.Uint64
+	lastWrite      atomic.Int64 // Unix nanoseconds of the last successful write or dial.
+}
+
+// ConnStats describes state of a single connection in the pool.
+type ConnStats struct {
+	Connected bool
+	Writes    uint64 // Successful writes.
+	Errors    uint64 // Failed writes.
+}
+
+// Connect dials LogDoc server synchronously, respecting DialTimeout.
+// It is the way to know whether the initial dial succeeded: on failure error is returned
+// and hook keeps reconnecting in background. Returns nil if connection is already established.
+// With PoolSize > 1 every connection of the pool is dialed and the first error is returned.
+func (h *Hook) Connect() error {
+	if h.isHTTP() {
+		// Every message is a separate HTTP request.
+		return nil
+	}
+
+	h.Lock()
+	links := h.ensureLinks()
+	h.Unlock()
+
+	var firstErr error
+	for _, l := range links {
+		if err := l.connect(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if h.SpoolDir != "" {
+		// Replay messages spooled by previous run.
+		h.spoolPending()
+	}
+	return firstErr
+}
+
+// Ping checks that messages can be delivered to LogDoc server, e.g. for readiness probes:
+// hook must hold a connection and the active endpoint must accept a fresh dial before ctx is done.
+// For HTTP transport the intake endpoint is requested instead. Safe to call concurrently with logging.
+func (h *Hook) Ping(ctx context.Context) error {
+	h.RLock()
+	closed := h.closed
+	h.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if h.isHTTP() {
+		return h.pingHTTP(ctx)
+	}
+
+	h.Lock()
+	connected := h.pick() != nil
+	h.Unlock()
+	if !connected {
+		return ErrNotConnected
+	}
+	conn, err := h.dialAddress(ctx, h.ActiveAddress())
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Connected reports whether hook currently holds at least one established connection.
+func (h *Hook) Connected() bool {
+	if h.isHTTP() {
+		return true
+	}
+
+	h.RLock()
+	defer h.RUnlock()
+	for _, l := range h.links {
+		if l.conn != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconnects returns how many reconnect attempts were made since hook creation.
+// The initial dial is not counted.
+func (h *Hook) Reconnects() uint64 {
+	return h.reconnects.Load()
+}
+
+// Dropped returns how many messages were dropped because there was no connection to LogDoc server
+// or async buffer was full.
+func (h *Hook) Dropped() uint64 {
+	return h.dropped.Load()
+}
+
+// Overflowed returns how many messages were dropped because async buffer was full.
+func (h *Hook) Overflowed() uint64 {
+	return h.overflowed.Load()
+}
+
+// drop counts dropped messages and reports them to OnDropped.
+func (h *Hook) drop(n int) {
+	h.dropped.Add(uint64(n))
+	if h.OnDropped != nil {
+		h.OnDropped(n)
+	}
+}
+
+// PoolStats returns state of every connection in the pool.
+func (h *Hook) PoolStats() []ConnStats {
+	h.RLock()
+	defer h.RUnlock()
+	stats := make([]ConnStats, len(h.links))
+	for i, l := range h.links {
+		stats[i] = ConnStats{Connected: l.conn != nil, Writes: l.writes.Load(), Errors: l.errors.Load()}
+	}
+	return stats
+}
+
+// Close stops accepting new messages, waits up to CloseTimeout for queued and in-flight ones,
+// then stops reconnecting and closes connections owned by hook. Repeated calls are no-op.
+func (h *Hook) Close() error {
+	if h.closing.Swap(true) {
+		return nil
+	}
+	timeout := h.CloseTimeout
+	if timeout <= 0 {
+		timeout = defaultCloseTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	_ = h.waitIdle(ctx)
+	cancel()
+	return errors.Join(h.release(), h.closeRoutes(nil))
+}
+
+// Shutdown stops accepting new messages and tries to deliver everything queued, including retries
+// and replay buffer, until ctx is done. Then it closes hook like Close does. Messages fired meanwhile
+// are rejected with error. If not everything was delivered, error reports how many messages were abandoned.
+func (h *Hook) Shutdown(ctx context.Context) error {
+	if h.closing.Swap(true) {
+		return nil
+	}
+	flushErr := h.Flush(ctx)
+	abandoned := h.pending.Load()
+	h.ring.Lock()
+	abandoned += int64(len(h.ring.msgs))
+	h.ring.Unlock()
+	abandoned += int64(h.startupPending())
+
+	err := errors.Join(h.release(), h.closeRoutes(ctx))
+	if flushErr != nil {
+		return fmt.Errorf("LogDoc hook shutdown, %d messages abandoned: %w", abandoned, flushErr)
+	}
+	return err
+}
+
+// release stops background work and closes connections.
+func (h *Hook) release() error {
+	h.Lock()
+	if h.closed {
+		h.Unlock()
+		return nil
+	}
+	h.closed = true
+	close(h.done)
+	var conns []net.Conn
+	for _, l := range h.links {
+		if l.conn != nil {
+			conns = append(conns, l.conn)
+			l.conn = nil
+		}
+	}
+	h.cond.Broadcast()
+	h.Unlock()
+	if h.SpoolDir != "" {
+		h.closeSpool()
+	}
+
+	var err error
+	for _, conn := range conns {
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Flush waits until messages queued in async buffer, spool and replay buffer are written
+// and in-flight writes are completed, or ctx is done; route hooks are flushed too. It may be called
+// concurrently with logging, messages fired meanwhile are waited for too. Close flushes async buffer
+// itself, waiting at most CloseTimeout, and leaves spool for the next run; Shutdown flushes everything with ctx.
+func (h *Hook) Flush(ctx context.Context) error {
+	if err := h.waitIdle(ctx); err != nil {
+		return err
+	}
+	for _, r := range h.Routes {
+		if r.Hook != nil && r.Hook != h {
+			if err := r.Hook.Flush(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	if !h.keeping() {
+		return nil
+	}
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for h.keptPending() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// waitIdle waits until there are no in-flight or queued messages.
+func (h *Hook) waitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for h.inflight.Load() > 0 || h.pending.Load() > 0 {
+		h.requestFlush()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// ensureLinks creates connection pool on first use, so PoolSize can be set after hook creation.
+// Must be called with h locked.
+func (h *Hook) ensureLinks() []*link {
+	if h.links == nil {
+		size := h.PoolSize
+		if size < 1 {
+			size = 1
+		}
+		h.links = make([]*link, size)
+		for i := range h.links {
+			h.links[i] = &link{hook: h}
+		}
+		if h.HeartbeatInterval > 0 && !h.isDatagram() && !h.isHTTP() {
+			go h.heartbeat()
+		}
+	}
+	return h.links
+}
+
+// pick returns the next connected link round-robin, starting reconnect of broken ones on the way.
+// Must be called with h locked.
+func (h *Hook) pick() *link {
+	links := h.ensureLinks()
+	start := h.next.Add(1)
+	for i := range links {
+		l := links[(start+uint64(i))%uint64(len(links))]
+		if l.conn != nil {
+			return l
+		}
+		l.startReconnect()
+	}
+	return nil
+}
+
+// dialAddress connects a single LogDoc endpoint. The whole dial, including proxy and TLS handshakes,
+// is limited by dial timeout and ctx.
+func (h *Hook) dialAddress(ctx context.Context, address string) (net.Conn, error) {
+	dialer := h.dialer()
+	if err := h.bindLocalAddr(dialer); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialer.Timeout)
+	defer cancel()
+
+	cfg, err := h.tlsConfig(address)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	if h.DialFunc != nil {
+		conn, err = h.DialFunc(ctx)
+	} else {
+		conn, err = h.dialRaw(ctx, dialer, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := h.tuneConn(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if cfg == nil {
+		return conn, nil
+	}
+
+	// Handshake is done here, so its errors are reported as dial errors.
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("TLS handshake with LogDoc server %s failed: %w", address, err)
+	}
+	return tlsConn, nil
+}
+
+// dialer returns copy of Dialer (if set) with defaults applied.
+// Keepalive is on by default, so idle connections dropped by firewalls are detected before the next burst of logs.
+func (h *Hook) dialer() *net.Dialer {
+	dialer := &net.Dialer{}
+	if h.Dialer != nil {
+		*dialer = *h.Dialer
+	}
+	if dialer.Timeout == 0 {
+		dialer.Timeout = h.DialTimeout
+	}
+	if dialer.Timeout == 0 {
+		dialer.Timeout = defaultDialTimeout
+	}
+	if dialer.KeepAlive == 0 {
+		dialer.KeepAlive = h.KeepAlive
+	}
+	if dialer.KeepAlive == 0 {
+		dialer.KeepAlive = defaultKeepAlive
+	}
+	return dialer
+}
+
+// bindLocalAddr sets LocalAddr as source address of dialer, if dialer has none.
+func (h *Hook) bindLocalAddr(dialer *net.Dialer) error {
+	if h.LocalAddr == "" || dialer.LocalAddr != nil || strings.HasPrefix(h.protocol, "unix") {
+		return nil
+	}
+	ip := net.ParseIP(strings.Trim(h.LocalAddr, "[]"))
+	if ip == nil {
+		return fmt.Errorf("invalid local address %q", h.LocalAddr)
+	}
+	if h.isDatagram() {
+		dialer.LocalAddr = &net.UDPAddr{IP: ip}
+	} else {
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	return nil
+}
+
+// tuneConn applies socket options to TCP connection.
+func (h *Hook) tuneConn(conn net.Conn) error {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if h.DisableNoDelay {
+		if err := tcp.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if h.WriteBufferSize > 0 {
+		if err := tcp.SetWriteBuffer(h.WriteBufferSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tlsConfig returns config for the next dial, nil if TLS is not used.
+func (h *Hook) tlsConfig(address string) (*tls.Config, error) {
+	clientCert := h.ClientCertificate != nil || h.ClientCertFile != ""
+	if h.TLSConfig == nil && !clientCert {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+	if h.TLSConfig != nil {
+		cfg = h.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		// SNI and certificate verification use the configured host.
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+
+	switch {
+	case h.ClientCertificate != nil:
+		cfg.Certificates = []tls.Certificate{*h.ClientCertificate}
+	case h.ClientCertFile != "":
+		cert, err := h.loadClientCert()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	return cfg, nil
+}
+
+// loadClientCert reads client certificate from disk. It is cached after the first load
+// unless ReloadClientCert is set, in which case rotated files are picked up on every dial.
+func (h *Hook) loadClientCert() (*tls.Certificate, error) {
+	h.certMu.Lock()
+	defer h.certMu.Unlock()
+	if h.clientCert != nil && !h.ReloadClientCert {
+		return h.clientCert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(h.ClientCertFile, h.ClientKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading LogDoc client certificate: %w", err)
+	}
+	h.clientCert = &cert
+	return h.clientCert, nil
+}
+
+func (l *link) connect() error {
+	h := l.hook
+	h.RLock()
+	connected := l.conn != nil
+	h.RUnlock()
+	if connected {
+		return nil
+	}
+
+	conn, ep, err := h.dial()
+
+	h.Lock()
+	defer h.Unlock()
+	l.dialed = true
+	if h.closed {
+		if conn != nil {
+			_ = conn.Close()
+		}
+		return ErrClosed
+	}
+	if err != nil {
+		l.backoff()
+		l.startReconnect()
+		return err
+	}
+	if l.conn != nil {
+		// Background reconnect was faster.
+		_ = conn.Close()
+		return nil
+	}
+	l.setConn(conn, ep)
+	return nil
+}
+
+// setConn installs established connection and resets backoff state.
+// Must be called with hook locked.
+func (l *link) setConn(conn net.Conn, ep *endpoint) {
+	l.conn = conn
+	l.endpoint = ep
+	l.localIP = ""
+	if conn != nil {
+		switch addr := conn.LocalAddr().(type) {
+		case *net.TCPAddr:
+			l.localIP = addr.IP.String()
+		case *net.UDPAddr:
+			l.localIP = addr.IP.String()
+		}
+	}
+	l.lastWrite.Store(time.Now().UnixNano())
+	l.reconnectDelay = 0
+	l.nextDial = time.Time{}
+	if conn != nil && l.hook.readsResponses() {
+		go l.readResponses(conn)
+	}
+	l.hook.cond.Broadcast()
+}
+
+// backoff grows reconnect delay exponentially and schedules the next dial with jitter.
+// Delay is kept on link, so it spans the whole outage and is reset only after successful dial.
+// Must be called with hook locked.
+func (l *link) backoff() {
+	h := l.hook
+	base := h.ReconnectBaseDelay
+	if base <= 0 {
+		base = defaultReconnectBaseDelay
+	}
+	multiplier := h.ReconnectDelayMultiplier
+	if multiplier < 1 {
+		multiplier = defaultReconnectDelayMultiplier
+	}
+	maxDelay := h.MaxReconnectDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxReconnectDelay
+	}
+
+	if l.reconnectDelay == 0 {
+		l.reconnectDelay = base
+	} else {
+		l.reconnectDelay = time.Duration(float64(l.reconnectDelay) * multiplier)
+	}
+	if l.reconnectDelay > maxDelay {
+		l.reconnectDelay = maxDelay
+	}
+
+	// Add jitter, so that many processes don't hit the server at the same moment.
+	d := l.reconnectDelay
+	l.nextDial = time.Now().Add(d/2 + time.Duration(rand.Int63n(int64(d/2)+1)))
+}
+
+// retry calls send until it succeeds, at most MaxSendRetries more times with backoff and jitter.
+// Retries are done by the sending goroutine, so messages are not reordered and buffer is not refilled.
+// If circuit breaker is open, send is not called and ErrCircuitOpen is returned.
+func (h *Hook) retry(send func() error) error {
+	if h.BreakerThreshold <= 0 {
+		return h.retrySend(send)
+	}
+	if !h.breakerAllow() {
+		return ErrCircuitOpen
+	}
+	err := h.retrySend(send)
+	h.breakerResult(err)
+	return err
+}
+
+func (h *Hook) retrySend(send func() error) error {
+	delay := h.ReconnectBaseDelay
+	if delay <= 0 {
+		delay = defaultReconnectBaseDelay
+	}
+	maxDelay := h.MaxReconnectDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxReconnectDelay
+	}
+	var err error
+	for attempt := 0; attempt <= h.MaxSendRetries; attempt++ {
+		if attempt > 0 {
+			if attempt == 1 && !h.retryAllowed() {
+				break
+			}
+			h.retries.Add(1)
+			select {
+			case <-h.done:
+				return ErrClosed
+			case <-time.After(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))):
+			}
+			delay = time.Duration(float64(delay) * defaultReconnectDelayMultiplier)
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+		}
+		if err = send(); err == nil || err == ErrOversized {
+			return err
+		}
+	}
+	h.givenUp.Add(1)
+	return err
+}
+
+// Retries returns number of resent messages.
+func (h *Hook) Retries() uint64 {
+	return h.retries.Load()
+}
+
+// startReconnect runs reconnect loop in background unless it is already running.
+// Must be called with hook locked.
+func (l *link) startReconnect() {
+	if l.reconnecting || l.hook.closed || l.conn != nil {
+		return
+	}
+	l.reconnecting = true
+	go l.reconnectLoop()
+}
+
+func (l *link) reconnectLoop() {
+	h := l.hook
+	for attempt := 0; ; {
+		h.RLock()
+		wait := time.Until(l.nextDial)
+		h.RUnlock()
+		if wait > 0 {
+			select {
+			case <-h.done:
+				l.stopReconnect()
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		conn, ep, err := h.dial()
+
+		h.Lock()
+		initial := !l.dialed
+		l.dialed = true
+		if !initial {
+			attempt++
+		}
+		switch {
+		case h.closed:
+			if conn != nil {
+				_ = conn.Close()
+			}
+			l.reconnecting = false
+		case err == nil:
+			l.setConn(conn, ep)
+			l.reconnecting = false
+		default:
+			l.backoff()
+			if h.MaxReconnectRetries > 0 && attempt >= h.MaxReconnectRetries {
+				// Give up for now, next message starts reconnecting again.
+				l.reconnecting = false
+				h.cond.Broadcast()
+			}
+		}
+		running := l.reconnecting
+		h.Unlock()
+
+		if !initial {
+			h.reconnects.Add(1)
+			if h.OnReconnect != nil {
+				h.OnReconnect(attempt, err)
+			}
+		}
+		if !running {
+			return
+		}
+	}
+}
+
+func (l *link) stopReconnect() {
+	l.hook.Lock()
+	l.reconnecting = false
+	l.hook.cond.Broadcast()
+	l.hook.Unlock()
+}
+
+// waitConnected blocks until some connection is established, reconnect gave up or hook is closed.
+// Used by async sender, so that queued messages wait for reconnect instead of being dropped.
+func (h *Hook) waitConnected() bool {
+	if h.isHTTP() {
+		return true
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	for started := false; ; started = true {
+		if h.closed {
+			return false
+		}
+		reconnecting := false
+		for _, l := range h.ensureLinks() {
+			if l.conn != nil {
+				return true
+			}
+			if !started {
+				// Once reconnect gives up, it is not restarted until the next wait.
+				l.startReconnect()
+			}
+			reconnecting = reconnecting || l.reconnecting
+		}
+		if !reconnecting {
+			return false
+		}
+		h.cond.Wait()
+	}
+}
+
+// write sends data to LogDoc server. It never waits for reconnect: if there is no connection,
+// reconnect is started in background and ErrNotConnected is returned.
+func (h *Hook) write(data []byte) error {
+	if h.isDatagram() && len(data) > h.maxDatagramSize() {
+		// Datagram would be truncated or rejected by the network stack.
+		h.oversized.Add(1)
+		return ErrOversized
+	}
+
+	h.Lock()
+	l := h.pick()
+	h.Unlock()
+	if l == nil {
+		return ErrNotConnected
+	}
+	return l.write(data)
+}
+
+func (l *link) write(data []byte) error {
+	if err := l.writeData(data); err != nil {
+		return err
+	}
+	l.writes.Add(1)
+	return nil
+}
+
+func (l *link) connected() bool {
+	l.hook.RLock()
+	defer l.hook.RUnlock()
+	return l.conn != nil
+}
+
+// writeData writes data to link connection, re-dialing it if it is broken.
+func (l *link) writeData(data []byte) error {
+	h := l.hook
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	h.RLock()
+	conn, ep := l.conn, l.endpoint
+	h.RUnlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	if h.MaxIdle > 0 && !h.isDatagram() && !h.readsResponses() && time.Since(time.Unix(0, l.lastWrite.Load())) > h.MaxIdle && !alive(conn) {
+		// NAT or server dropped idle connection. Dial a fresh one now,
+		// instead of losing this message to discover the dead socket.
+		var err error
+		if conn, ep, err = l.redial(conn); err != nil {
+			return err
+		}
+	}
+
+	// Without deadline a server that accepts but doesn't read would block writers forever.
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = defaultWriteTimeout
+	}
+	if timeout > 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	if _, err := conn.Write(data); err != nil {
+		l.errors.Add(1)
+		h.notifyFailover(h.endpointFailed(ep))
+		if h.isDatagram() {
+			// UDP socket is not a session, there is nothing to reconnect.
+			return err
+		}
+		// Connection is broken or timed out in the middle of a message, so the stream can't be
+		// continued: drop it and dial again in background.
+		// Other connections of the pool are not affected.
+		_ = conn.Close()
+		h.Lock()
+		if l.conn == conn {
+			l.conn = nil
+		}
+		l.startReconnect()
+		h.Unlock()
+		return err
+	}
+	l.lastWrite.Store(time.Now().UnixNano())
+	if ep != nil && ep.failures.Load() != 0 {
+		ep.failures.Store(0)
+	}
+	return nil
+}
+
+// alive probes idle connection with short read: closed connection reports EOF or reset at once.
+// Deadline is set slightly in the future, because expired deadline fails read without looking at the socket.
+func alive(conn net.Conn) bool {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+	var buf [1]byte
+	_, err := conn.Read(buf[:])
+	var netErr net.Error
+	return err == nil || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// redial replaces dead connection synchronously, within dial timeout.
+// Must be called with writeMu held.
+func (l *link) redial(dead net.Conn) (net.Conn, *endpoint, error) {
+	h := l.hook
+	_ = dead.Close()
+	conn, ep, err := h.dial()
+
+	h.Lock()
+	defer h.Unlock()
+	if l.conn == dead {
+		l.conn = nil
+	}
+	if err != nil || h.closed {
+		if conn != nil {
+			_ = conn.Close()
+		}
+		l.startReconnect()
+		if err == nil {
+			err = ErrClosed
+		}
+		return nil, nil, err
+	}
+	l.setConn(conn, ep)
+	return conn, ep, nil
+}
+
+// Oversized returns how many messages were dropped because they didn't fit into a datagram in UDP mode
+// or into MaxEventBytes.
+func (h *Hook) Oversized() uint64 {
+	return h.oversized.Load()
+}
+
+func (h *Hook) isDatagram() bool {
+	return strings.HasPrefix(h.protocol, "udp")
+}
+
+func (h *Hook) maxDatagramSize() int {
+	if h.MaxDatagramSize > 0 {
+		return h.MaxDatagramSize
+	}
+	return defaultMaxDatagramSize
+}
+
+// splitAddress extracts protocol from URL-style address like udp://host:port or unix:///run/logdoc.sock.
+func splitAddress(protocol, address string) (string, string) {
+	if scheme, rest, ok := strings.Cut(address, "://"); ok {
+		return scheme, rest
+	}
+	return protocol, address
+}
+
+// sourceIP returns value of ip field: SourceIP if set, local IP of connection to LogDoc server
+// or IP of this host.
+func (h *Hook) sourceIP() string {
+	if h.SourceIP != "" {
+		return h.SourceIP
+	}
+	h.RLock()
+	defer h.RUnlock()
+	for _, l := range h.links {
+		if l.conn != nil && l.localIP != "" {
+			return l.localIP
+		}
+	}
+	// HTTP, unix socket or no connection yet.
+	return hostIP()
+}
+
+var (
+	hostIPOnce sync.Once
+	hostIPAddr string
+)
+
+// hostIP returns the first global unicast address of this host, IPv4 preferred, or loopback if there is none.
+func hostIP() string {
+	hostIPOnce.Do(func() {
+		hostIPAddr = "127.0.0.1"
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return
+		}
+		var v6 string
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+			if ipNet.IP.To4() != nil {
+				hostIPAddr = ipNet.IP.String()
+				return
+			}
+			if v6 == "" {
+				v6 = ipNet.IP.String()
+			}
+		}
+		if v6 != "" {
+			hostIPAddr = v6
+		}
+	})
+	return hostIPAddr
+}
@@ -0,0 +1,887 @@
+package logrusld
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testServer is a LogDoc server stub collecting received lines.
+type testServer struct {
+	ln    net.Listener
+	lines chan string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func startTestServer(t testing.TB, address string) *testServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveTestServer(ln)
+}
+
+func serveTestServer(ln net.Listener) *testServer {
+	s := &testServer{ln: ln, lines: make(chan string, 1024)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					s.lines <- strings.TrimSuffix(line, "\n")
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+// stop closes listener and all accepted connections, like a restarting server.
+func (s *testServer) stop() {
+	_ = s.ln.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *testServer) waitLine(t *testing.T, want string, timeout time.Duration) bool {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line := <-s.lines:
+			if line == want {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func TestWriteResumesAfterServerRestart(t *testing.T) {
+	srv := startTestServer(t, "127.0.0.1:0")
+	address := srv.ln.Addr().String()
+
+	var callbacks, succeeded atomic.Int32
+	hook := NewLazyHook("tcp", address)
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	hook.MaxReconnectDelay = 50 * time.Millisecond
+	hook.OnReconnect = func(attempt int, err error) {
+		callbacks.Add(1)
+		if err == nil {
+			succeeded.Add(1)
+		}
+		// Must not deadlock: callback is called without hook lock held.
+		hook.Connected()
+	}
+	defer hook.Close()
+
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.waitLine(t, "before", time.Second) {
+		t.Fatal("message before restart was not delivered")
+	}
+	if hook.Reconnects() != 0 {
+		t.Fatalf("initial dial counted as reconnect: %d", hook.Reconnects())
+	}
+
+	srv.stop()
+	// Write until hook notices the broken connection.
+	for i := 0; i < 100 && hook.Connected(); i++ {
+		_ = hook.write([]byte("lost\n"))
+		time.Sleep(5 * time.Millisecond)
+	}
+	if hook.Connected() {
+		t.Fatal("broken connection was not detected")
+	}
+
+	srv = startTestServer(t, address)
+	defer srv.stop()
+
+	delivered := false
+	for i := 0; i < 200 && !delivered; i++ {
+		start := time.Now()
+		_ = hook.write([]byte("after\n"))
+		if time.Since(start) > 100*time.Millisecond {
+			t.Fatal("write blocked while reconnecting")
+		}
+		delivered = srv.waitLine(t, "after", 10*time.Millisecond)
+	}
+	if !delivered {
+		t.Fatal("logging did not resume after server restart")
+	}
+	if hook.Reconnects() == 0 || callbacks.Load() == 0 || succeeded.Load() != 1 {
+		t.Fatalf("reconnects=%d callbacks=%d succeeded=%d", hook.Reconnects(), callbacks.Load(), succeeded.Load())
+	}
+}
+
+func TestBackoffSpansOutage(t *testing.T) {
+	hook := NewLazyHook("tcp", "127.0.0.1:0")
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	hook.MaxReconnectDelay = 40 * time.Millisecond
+
+	hook.Lock()
+	l := hook.ensureLinks()[0]
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		l.backoff()
+		delays = append(delays, l.reconnectDelay)
+	}
+	l.setConn(nil, nil)
+	reset := l.reconnectDelay
+	hook.Unlock()
+
+	want := []time.Duration{10, 20, 40, 40, 40}
+	for i := range want {
+		if delays[i] != want[i]*time.Millisecond {
+			t.Fatalf("delays = %v", delays)
+		}
+	}
+	if reset != 0 {
+		t.Fatalf("delay not reset after connect: %v", reset)
+	}
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	srv := startTestServer(t, "127.0.0.1:0")
+	defer srv.stop()
+
+	hook := NewLazyHook("tcp", srv.ln.Addr().String())
+	hook.PoolSize = 3
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	defer hook.Close()
+
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if err := hook.write([]byte("pooled\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 6; i++ {
+		if !srv.waitLine(t, "pooled", time.Second) {
+			t.Fatal("pooled message was not delivered")
+		}
+	}
+	stats := hook.PoolStats()
+	if len(stats) != 3 {
+		t.Fatalf("pool size = %d", len(stats))
+	}
+	for i, s := range stats {
+		if !s.Connected || s.Writes != 2 {
+			t.Fatalf("conn %d stats = %+v", i, s)
+		}
+	}
+
+	// Break one connection, the others keep working.
+	hook.Lock()
+	broken := hook.links[0]
+	_ = broken.conn.Close()
+	hook.Unlock()
+	for i := 0; i < 6; i++ {
+		_ = hook.write([]byte("pooled\n"))
+	}
+	if broken.errors.Load() == 0 {
+		t.Fatal("write to closed connection did not fail")
+	}
+	stats = hook.PoolStats()
+	if !stats[1].Connected || !stats[2].Connected {
+		t.Fatalf("healthy connections affected: %+v", stats)
+	}
+}
+
+func TestWriteTimeoutOnStalledServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var mu sync.Mutex
+	var accepted []net.Conn
+	go func() {
+		for {
+			// Accept, but never read.
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			accepted = append(accepted, conn)
+			mu.Unlock()
+		}
+	}()
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range accepted {
+			_ = conn.Close()
+		}
+	}()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.Timeout = 50 * time.Millisecond
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := make([]byte, 1<<20)
+	start := time.Now()
+	for {
+		err = hook.write(chunk)
+		if err != nil || time.Since(start) > 5*time.Second {
+			break
+		}
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("write error = %v, want timeout", err)
+	}
+
+	// Timed out connection is dropped and dialed again.
+	deadline := time.Now().Add(time.Second)
+	for hook.Reconnects() == 0 || !hook.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("hook did not reconnect after write timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCustomDialerAndSocketOptions(t *testing.T) {
+	srv := startTestServer(t, "127.0.0.1:0")
+	defer srv.stop()
+
+	var controlled atomic.Int32
+	hook := NewLazyHook("tcp", srv.ln.Addr().String())
+	hook.Dialer = &net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		controlled.Add(1)
+		return nil
+	}}
+	hook.KeepAlive = time.Second
+	hook.DisableNoDelay = true
+	hook.WriteBufferSize = 64 << 10
+	hook.ReconnectBaseDelay = 5 * time.Millisecond
+	defer hook.Close()
+
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if d := hook.dialer(); d.KeepAlive != time.Second || d.Timeout != defaultDialTimeout {
+		t.Fatalf("dialer = %+v", d)
+	}
+
+	// Connection killed while idle is re-established and the next messages are delivered.
+	srv.stop()
+	srv = startTestServer(t, srv.ln.Addr().String())
+	defer srv.stop()
+	delivered := false
+	for i := 0; i < 200 && !delivered; i++ {
+		_ = hook.write([]byte("after idle\n"))
+		delivered = srv.waitLine(t, "after idle", 10*time.Millisecond)
+	}
+	if !delivered {
+		t.Fatal("connection killed while idle was not re-established")
+	}
+	if controlled.Load() < 2 {
+		t.Fatalf("custom dialer used %d times", controlled.Load())
+	}
+}
+
+func TestPing(t *testing.T) {
+	srv := startTestServer(t, "127.0.0.1:0")
+	address := srv.ln.Addr().String()
+
+	hook := NewLazyHook("tcp", address)
+	hook.ReconnectBaseDelay = time.Hour
+	defer hook.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := hook.Ping(ctx); err != ErrNotConnected {
+		t.Fatalf("ping before connect = %v", err)
+	}
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Ping(ctx); err != nil {
+		t.Fatalf("ping = %v", err)
+	}
+
+	srv.stop()
+	if err := hook.Ping(ctx); err == nil {
+		t.Fatal("ping succeeded with server down")
+	}
+
+	expired, cancelExpired := context.WithCancel(context.Background())
+	cancelExpired()
+	srv = startTestServer(t, address)
+	defer srv.stop()
+	if err := hook.Ping(expired); err == nil {
+		t.Fatal("ping ignored expired context")
+	}
+
+	_ = hook.Close()
+	if err := hook.Ping(ctx); err != ErrClosed {
+		t.Fatalf("ping after close = %v", err)
+	}
+}
+
+func TestIdleConnectionRedialed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// Server drops connections idle for 30ms, like NAT does.
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					_ = conn.SetReadDeadline(time.Now().Add(30 * time.Millisecond))
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					lines <- strings.TrimSuffix(line, "\n")
+				}
+			}()
+		}
+	}()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.MaxIdle = 10 * time.Millisecond
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []string{"first", "after idle"} {
+		if err := hook.write([]byte(msg + "\n")); err != nil {
+			t.Fatalf("%s: %v", msg, err)
+		}
+		select {
+		case line := <-lines:
+			if line != msg {
+				t.Fatalf("received %q, want %q", line, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q was lost", msg)
+		}
+		time.Sleep(60 * time.Millisecond)
+	}
+}
+
+func TestCloseDrainsQueueAndRejectsLateMessages(t *testing.T) {
+	srv := startTestServer(t, "127.0.0.1:0")
+	defer srv.stop()
+
+	hook := NewLazyHook("tcp", srv.ln.Addr().String())
+	hook.MakeAsync()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := hook.Fire(testEntry("queued")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := hook.pending.Load(); n != 0 {
+		t.Fatalf("%d queued messages were not sent before close", n)
+	}
+	if hook.Connected() {
+		t.Fatal("connection is still open after close")
+	}
+	if err := hook.Fire(testEntry("late")); err != ErrClosed {
+		t.Fatalf("fire after close = %v", err)
+	}
+	if err := hook.Close(); err != nil {
+		t.Fatalf("second close = %v", err)
+	}
+}
+
+func TestDialFunc(t *testing.T) {
+	var dials atomic.Int32
+	servers := make(chan net.Conn, 10)
+	hook := NewLazyHook("tcp", "tunnel:5656")
+	hook.DialTimeout = time.Second
+	hook.ReconnectBaseDelay = 5 * time.Millisecond
+	hook.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("dial context has no deadline")
+		}
+		if dials.Add(1) == 2 {
+			return nil, errors.New("tunnel is down")
+		}
+		client, server := net.Pipe()
+		servers <- server
+		return client, nil
+	}
+	var failed atomic.Int32
+	hook.OnReconnect = func(attempt int, err error) {
+		if err != nil {
+			failed.Add(1)
+		}
+	}
+	defer hook.Close()
+
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	server := <-servers
+	go func() { _ = hook.write([]byte("piped\n")) }()
+	line, err := bufio.NewReader(server).ReadString('\n')
+	if err != nil || line != "piped\n" {
+		t.Fatalf("read %q, %v", line, err)
+	}
+
+	// Broken tunnel is dialed again through DialFunc, dial errors are backed off.
+	_ = server.Close()
+	_ = hook.write([]byte("lost\n"))
+	select {
+	case server = <-servers:
+		_ = server.Close()
+	case <-time.After(time.Second):
+		t.Fatal("DialFunc was not called on reconnect")
+	}
+	if failed.Load() != 1 || dials.Load() != 3 {
+		t.Fatalf("failed=%d dials=%d", failed.Load(), dials.Load())
+	}
+}
+
+func TestIPv6AndLocalAddr(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 loopback is not available:", err)
+	}
+	defer ln.Close()
+	frames := make(chan map[string]string, 10)
+	errs := make(chan error, 1)
+	remotes := make(chan net.Addr, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		remotes <- conn.RemoteAddr()
+		readFrames(conn, frames, errs)
+	}()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.LocalAddr = "::1"
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if addr := (<-remotes).(*net.TCPAddr); !addr.IP.Equal(net.IPv6loopback) {
+		t.Fatalf("connection came from %s", addr)
+	}
+	if err := hook.Fire(testEntry("over IPv6")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case f := <-frames:
+		if f["ip"] != "::1" {
+			t.Fatalf("ip = %q", f["ip"])
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+
+	bad := NewLazyHook("tcp", ln.Addr().String())
+	bad.LocalAddr = "not an ip"
+	defer bad.Close()
+	if err := bad.Connect(); err == nil {
+		t.Fatal("invalid local address accepted")
+	}
+}
+
+func TestAsyncBurstKeepsGoroutinesFlat(t *testing.T) {
+	srv := startTestServer(t, "127.0.0.1:0")
+	defer srv.stop()
+
+	hook := NewLazyHook("tcp", srv.ln.Addr().String())
+	hook.AsyncBufferSize = 100
+	hook.MakeAsync()
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	before := runtime.NumGoroutine()
+	peak := before
+	for i := 0; i < 100000; i++ {
+		_ = hook.Fire(testEntry("burst"))
+		if i%1000 == 0 {
+			if n := runtime.NumGoroutine(); n > peak {
+				peak = n
+			}
+		}
+	}
+	if peak > before+2 {
+		t.Fatalf("goroutines grew from %d to %d", before, peak)
+	}
+	if cap(hook.fireChannel) != 100 {
+		t.Fatalf("buffer size = %d", cap(hook.fireChannel))
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy OverflowPolicy
+		want   []string // Delivered after stall, the first message is being written.
+	}{
+		{"drop newest", OverflowDropNewest, []string{"1", "2", "3"}},
+		{"drop oldest", OverflowDropOldest, []string{"1", "3", "4"}},
+		{"block", OverflowBlock, []string{"1", "2", "3"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			servers := make(chan net.Conn, 1)
+			hook := NewLazyHook("tcp", "stalled:5656")
+			hook.Timeout = -1
+			hook.CloseTimeout = 10 * time.Millisecond
+			hook.AsyncBufferSize = 2
+			hook.OverflowPolicy = tc.policy
+			hook.BlockTimeout = 20 * time.Millisecond
+			hook.DialFunc = func(ctx context.Context) (net.Conn, error) {
+				client, server := net.Pipe()
+				servers <- server
+				return client, nil
+			}
+			hook.MakeAsync()
+			defer hook.Close()
+			if err := hook.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			server := <-servers
+			defer server.Close()
+
+			// Nobody reads the server side, so the worker is stuck writing the first message.
+			_ = hook.Fire(testEntry("1"))
+			for len(hook.fireChannel) != 0 {
+				time.Sleep(time.Millisecond)
+			}
+			_ = hook.Fire(testEntry("2"))
+			_ = hook.Fire(testEntry("3"))
+			start := time.Now()
+			_ = hook.Fire(testEntry("4"))
+			if elapsed := time.Since(start); (tc.policy == OverflowBlock) != (elapsed >= hook.BlockTimeout) {
+				t.Fatalf("fire took %v", elapsed)
+			}
+			if hook.Dropped() != 1 {
+				t.Fatalf("dropped = %d", hook.Dropped())
+			}
+
+			frames := make(chan map[string]string, 10)
+			go readFrames(server, frames, make(chan error, 1))
+			for _, want := range tc.want {
+				select {
+				case f := <-frames:
+					if f["msg"] != want {
+						t.Fatalf("received %q, want %q", f["msg"], want)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("%q was not delivered", want)
+				}
+			}
+		})
+	}
+}
+
+// flakyConn fails every other write.
+type flakyConn struct {
+	net.Conn
+	writes *atomic.Int32
+}
+
+func (c flakyConn) Write(b []byte) (int, error) {
+	if c.writes.Add(1)%2 == 1 {
+		return 0, errors.New("flaky network")
+	}
+	return c.Conn.Write(b)
+}
+
+func TestRetryFlakyConnection(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+
+	var writes atomic.Int32
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.MaxSendRetries = 10
+	hook.ReconnectBaseDelay = time.Millisecond
+	hook.MaxReconnectDelay = 5 * time.Millisecond
+	hook.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", ln.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return flakyConn{Conn: conn, writes: &writes}, nil
+	}
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		msg := fmt.Sprint("flaky ", i)
+		want[msg] = true
+		if err := hook.Fire(testEntry(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every failed write drops connection, frames of different connections may be read in any order.
+	for len(want) > 0 {
+		select {
+		case f := <-frames:
+			delete(want, f["msg"])
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%d messages were not delivered", len(want))
+		}
+	}
+	if hook.Retries() < 100 || hook.Dropped() != 0 {
+		t.Fatalf("retries=%d dropped=%d", hook.Retries(), hook.Dropped())
+	}
+}
+
+func TestFlush(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.MakeAsync()
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var want []string
+	for i := 0; i < 100; i++ {
+		want = append(want, fmt.Sprint("flushed ", i))
+		_ = hook.Fire(testEntry(want[i]))
+	}
+	// Flush is safe concurrently with logging.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = hook.Fire(testEntry("concurrent"))
+		}
+	}()
+	if err := hook.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if err := hook.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := hook.pending.Load(); n != 0 {
+		t.Fatalf("%d messages are still queued after flush", n)
+	}
+	waitMessages(t, frames, want)
+
+	expired, cancelExpired := context.WithCancel(context.Background())
+	cancelExpired()
+	hook.pending.Add(1) // Message stuck in buffer.
+	defer hook.pending.Add(-1)
+	if err := hook.Flush(expired); err != context.Canceled {
+		t.Fatalf("flush with expired context = %v", err)
+	}
+}
+
+func TestSyncModeReturnsErrors(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.Sync = true
+	hook.ReconnectBaseDelay = time.Hour
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	breakConn := func() {
+		hook.Lock()
+		_ = hook.links[0].conn.Close()
+		hook.Unlock()
+	}
+
+	if err := hook.Fire(testEntry("first")); err != nil {
+		t.Fatal(err)
+	}
+	waitMessages(t, frames, []string{"first"})
+	// Broken connection is re-dialed inline.
+	breakConn()
+	if err := hook.Fire(testEntry("redialed")); err != nil {
+		t.Fatal(err)
+	}
+	waitMessages(t, frames, []string{"redialed"})
+
+	_ = ln.Close()
+	breakConn()
+	if err := hook.Fire(testEntry("lost")); err == nil {
+		t.Fatal("send error was not returned")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.MakeAsync()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := 0; i < 50; i++ {
+		want = append(want, fmt.Sprint("final ", i))
+		_ = hook.Fire(testEntry(want[i]))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := hook.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitMessages(t, frames, want)
+
+	// Stalled server: queued messages are abandoned at deadline.
+	servers := make(chan net.Conn, 1)
+	stalled := NewLazyHook("tcp", "stalled:5656")
+	stalled.Timeout = -1
+	stalled.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		servers <- server
+		return client, nil
+	}
+	stalled.MakeAsync()
+	if err := stalled.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer (<-servers).Close()
+	for i := 0; i < 10; i++ {
+		_ = stalled.Fire(testEntry("stuck"))
+	}
+	done := make(chan struct{})
+	go func() {
+		// Logging goes on during shutdown.
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			_ = stalled.Fire(testEntry("late"))
+		}
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := stalled.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "abandoned") {
+		t.Fatalf("shutdown = %v", err)
+	}
+	<-done
+	if err := stalled.Fire(testEntry("after")); err != ErrClosed {
+		t.Fatalf("fire after shutdown = %v", err)
+	}
+}
+
+func TestQueueFullReported(t *testing.T) {
+	servers := make(chan net.Conn, 1)
+	hook := NewLazyHook("tcp", "stalled:5656")
+	hook.Timeout = -1
+	hook.CloseTimeout = 10 * time.Millisecond
+	hook.AsyncBufferSize = 1
+	hook.ReturnErrors = true
+	var dropped atomic.Int32
+	hook.OnDropped = func(count int) { dropped.Add(int32(count)) }
+	hook.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		servers <- server
+		return client, nil
+	}
+	hook.MakeAsync()
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer (<-servers).Close()
+
+	_ = hook.Fire(testEntry("stuck"))
+	for len(hook.fireChannel) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := hook.Fire(testEntry("queued")); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Fire(testEntry("overflow")); err != ErrQueueFull {
+		t.Fatalf("fire = %v, want ErrQueueFull", err)
+	}
+	if hook.Overflowed() != 1 || hook.Dropped() != 1 || dropped.Load() != 1 {
+		t.Fatalf("overflowed=%d dropped=%d callback=%d", hook.Overflowed(), hook.Dropped(), dropped.Load())
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.LocalAddr = "127.0.0.2"
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	_ = hook.Fire(testEntry("client address"))
+	if f := <-frames; f["ip"] != "127.0.0.2" {
+		t.Fatalf("ip = %q, want client address", f["ip"])
+	}
+
+	hook.SourceIP = "203.0.113.7"
+	_ = hook.Fire(testEntry("behind NAT"))
+	if f := <-frames; f["ip"] != "203.0.113.7" {
+		t.Fatalf("ip = %q, want SourceIP", f["ip"])
+	}
+}
@@ -0,0 +1,43 @@
+package logrusld
+
+import (
+	"context"
+	"fmt"
+)
+
+// ExtractValue returns ContextFields function sending value of ctxKey in entry context as fieldKey field,
+// e.g. request ID set by middleware. Field is not sent if context has no value; other values than strings
+// are formatted with fmt.Sprint.
+func ExtractValue(ctxKey interface{}, fieldKey string) func(ctx context.Context) map[string]interface{} {
+	return func(ctx context.Context) map[string]interface{} {
+		value := ctx.Value(ctxKey)
+		if value == nil {
+			return nil
+		}
+		s, ok := value.(string)
+		if !ok {
+			s = fmt.Sprint(value)
+		}
+		return map[string]interface{}{fieldKey: s}
+	}
+}
+
+// ChainContextFields returns ContextFields function merging fields of extractors, e.g.
+//
+//	hook.ContextFields = logrusld.ChainContextFields(otelld.ExtractTraceContext, child.RequestID)
+//
+// If extractors return the same key, the later one wins.
+func ChainContextFields(extractors ...func(ctx context.Context) map[string]interface{}) func(ctx context.Context) map[string]interface{} {
+	return func(ctx context.Context) map[string]interface{} {
+		var fields map[string]interface{}
+		for _, extract := range extractors {
+			for k, v := range extract(ctx) {
+				if fields == nil {
+					fields = map[string]interface{}{}
+				}
+				fields[k] = v
+			}
+		}
+		return fields
+	}
+}
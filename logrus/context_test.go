@@ -0,0 +1,47 @@
+package logrusld
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type requestIDKey struct{}
+
+func TestExtractValue(t *testing.T) {
+	extract := ExtractValue(requestIDKey{}, "request_id")
+	if fields := extract(context.Background()); fields != nil {
+		t.Fatalf("missing key = %v", fields)
+	}
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-42")
+	if fields := extract(ctx); fields["request_id"] != "req-42" {
+		t.Fatalf("string = %v", fields)
+	}
+	ctx = context.WithValue(context.Background(), requestIDKey{}, 42)
+	if fields := extract(ctx); fields["request_id"] != "42" {
+		t.Fatalf("int = %v", fields)
+	}
+}
+
+func TestChainContextFields(t *testing.T) {
+	type tenantKey struct{}
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.ContextFields = ChainContextFields(
+		ExtractValue(requestIDKey{}, "request_id"),
+		ExtractValue(tenantKey{}, "tenant"),
+		ExtractValue(tenantKey{}, "request_id"),
+	)
+	entry := testEntry("chained")
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-42")
+	entry.Context = ctx
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "msg=chained\nrequest_id=req-42\napp=app\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+	entry.Context = context.WithValue(ctx, tenantKey{}, "acme")
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "msg=chained\nrequest_id=acme\ntenant=acme\napp=app\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+	if fields := ChainContextFields()(ctx); fields != nil {
+		t.Fatalf("empty chain = %v", fields)
+	}
+}
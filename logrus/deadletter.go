@@ -0,0 +1,117 @@
+package logrusld
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const deadLetterBufferSize = 1024
+
+// deadLetter is a message hook gave up on.
+type deadLetter struct {
+	msg       queued
+	fields    []byte // Encoded fields, nil if message was not encoded yet.
+	err       error
+	recovered int64 // If not 0, it is notice for Fallback that LogDoc accepts messages again.
+}
+
+// deadLetter passes undelivered message to OnDeadLetter and Fallback. They are called from a separate
+// goroutine in order of failures, so slow callback doesn't block logging; if it falls behind by more than
+// deadLetterBufferSize messages, the rest are not reported.
+func (h *Hook) deadLetter(msg queued, fields []byte, err error) {
+	if h.OnDeadLetter == nil && h.Fallback == nil {
+		return
+	}
+	if msg.entry != nil {
+		// Callbacks run after Fire returns.
+		msg.entry = snapshot(msg.entry)
+		if h.Fallback != nil {
+			h.diverted.Add(1)
+		}
+	}
+	h.reportDeadLetter(deadLetter{msg: msg, fields: fields, err: err})
+}
+
+// fallbackRecovered tells Fallback that messages are delivered to LogDoc again.
+func (h *Hook) fallbackRecovered() {
+	if n := h.diverted.Swap(0); n > 0 {
+		h.reportDeadLetter(deadLetter{recovered: n})
+	}
+}
+
+func (h *Hook) reportDeadLetter(d deadLetter) {
+	h.deadLettersOnce.Do(func() {
+		h.deadLetters = make(chan deadLetter, deadLetterBufferSize)
+		go h.sendDeadLetters()
+	})
+	select {
+	case h.deadLetters <- d:
+	default:
+	}
+}
+
+func (h *Hook) sendDeadLetters() {
+	for {
+		select {
+		case d := <-h.deadLetters:
+			if d.recovered > 0 {
+				h.fallbackNotice(d.recovered)
+				continue
+			}
+			if h.Fallback != nil && d.msg.entry != nil {
+				h.fallback(d.msg.entry)
+			}
+			if h.OnDeadLetter != nil {
+				if d.fields == nil {
+					d.fields = h.encoded(d.msg)
+				}
+				h.callDeadLetter(h.frame(d.fields), d.msg.entry, d.err)
+			}
+		case <-h.done:
+			return
+		}
+	}
+}
+
+func (h *Hook) callDeadLetter(payload []byte, entry *logrus.Entry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("Паника в OnDeadLetter, %v", r)
+		}
+	}()
+	h.OnDeadLetter(payload, entry, err)
+}
+
+// fallback fires entry to Fallback hook, if hook accepts entry level.
+func (h *Hook) fallback(entry *logrus.Entry) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("Паника в Fallback, %v", r)
+		}
+	}()
+	if entry.Logger != nil {
+		h.fallbackLogger.Store(entry.Logger)
+	}
+	for _, level := range h.Fallback.Levels() {
+		if level == entry.Level {
+			if err := h.Fallback.Fire(entry); err != nil {
+				logrus.Errorf("Ошибка записи в Fallback, %s", err.Error())
+			}
+			return
+		}
+	}
+}
+
+func (h *Hook) fallbackNotice(diverted int64) {
+	logger := h.fallbackLogger.Load()
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	entry := logrus.NewEntry(logger)
+	entry.Time = time.Now()
+	entry.Level = logrus.WarnLevel
+	entry.Message = fmt.Sprintf("LogDoc connection recovered, %d messages were sent to fallback", diverted)
+	h.fallback(entry)
+}
@@ -0,0 +1,169 @@
+package logrusld
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDeadLetter(t *testing.T) {
+	type letter struct {
+		payload []byte
+		entry   *logrus.Entry
+		err     error
+	}
+	letters := make(chan letter, 10)
+	onDeadLetter := func(payload []byte, entry *logrus.Entry, err error) {
+		letters <- letter{payload, entry, err}
+		panic("callback panics are recovered")
+	}
+
+	udp := NewLazyHook("udp", "127.0.0.1:1")
+	udp.MaxDatagramSize = 10
+	udp.OnDeadLetter = onDeadLetter
+	defer udp.Close()
+	if err := udp.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	down := NewLazyHook("tcp", "127.0.0.1:1")
+	down.ReconnectBaseDelay = time.Hour
+	down.OnDeadLetter = onDeadLetter
+	defer down.Close()
+
+	for _, tc := range []struct {
+		hook *Hook
+		want error
+	}{{udp, ErrOversized}, {udp, ErrOversized}, {down, ErrNotConnected}} {
+		entry := testEntry("undelivered")
+		_ = tc.hook.Fire(entry)
+		select {
+		case l := <-letters:
+			if l.err != tc.want || l.entry == nil || l.entry.Message != entry.Message || !bytes.Contains(l.payload, []byte("msg=undelivered\n")) {
+				t.Fatalf("dead letter = %v %v %q", l.err, l.entry, l.payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("dead letter for %v was not reported", tc.want)
+		}
+	}
+}
+
+// recordingHook collects fired entries.
+type recordingHook struct {
+	entries chan *logrus.Entry
+}
+
+func (r recordingHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (r recordingHook) Fire(entry *logrus.Entry) error {
+	r.entries <- entry
+	return nil
+}
+
+func TestFallback(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	address := ln.Addr().String()
+	_ = ln.Close()
+
+	fallback := recordingHook{entries: make(chan *logrus.Entry, 10)}
+	hook := NewLazyHook("tcp", address)
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	hook.MaxReconnectDelay = 20 * time.Millisecond
+	hook.Fallback = fallback
+	defer hook.Close()
+
+	for _, msg := range []string{"first", "second"} {
+		_ = hook.Fire(testEntry(msg))
+		select {
+		case e := <-fallback.entries:
+			if e.Message != msg {
+				t.Fatalf("fallback received %q, want %q", e.Message, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q was not sent to fallback", msg)
+		}
+	}
+
+	ln, frames = serveFrames(t, address)
+	defer ln.Close()
+	for !hook.Connected() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = hook.Fire(testEntry("recovered"))
+	waitMessages(t, frames, []string{"recovered"})
+	select {
+	case e := <-fallback.entries:
+		if e.Level != logrus.WarnLevel || e.Message != "LogDoc connection recovered, 2 messages were sent to fallback" {
+			t.Fatalf("fallback notice = %s %q", e.Level, e.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fallback was not told about recovery")
+	}
+}
+
+// fieldsHook reads entry fields, like a formatter would.
+type fieldsHook struct {
+	entries chan string
+}
+
+func (f fieldsHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (f fieldsHook) Fire(entry *logrus.Entry) error {
+	f.entries <- fmt.Sprint(entry.Message, " ", entry.Data["mutated"])
+	return nil
+}
+
+// mutatingHook changes entry after LogDoc hook returned.
+type mutatingHook struct{}
+
+func (mutatingHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (mutatingHook) Fire(entry *logrus.Entry) error {
+	entry.Data["mutated"] = true
+	entry.Message += " mutated"
+	return nil
+}
+
+func TestEntryIsCopiedAfterFire(t *testing.T) {
+	const goroutines, messages = 10, 20
+	fallback := fieldsHook{entries: make(chan string, goroutines*messages)}
+	hook := NewLazyHook("tcp", "127.0.0.1:1")
+	hook.ReconnectBaseDelay = time.Millisecond
+	hook.MaxReconnectDelay = time.Millisecond
+	hook.MaxReconnectRetries = 1
+	hook.Fallback = fallback
+	hook.MakeAsync()
+	defer hook.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(hook)
+	logger.AddHook(mutatingHook{})
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				logger.WithFields(logrus.Fields{"goroutine": g, "i": i}).Info("copied")
+			}
+		}(g)
+	}
+	wg.Wait()
+	for i := 0; i < goroutines*messages; i++ {
+		select {
+		case e := <-fallback.entries:
+			if e != "copied <nil>" {
+				t.Fatalf("fallback received entry changed after Fire: %s", e)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%d entries reached fallback", i)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package logrusld
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dedup remembers the last message to suppress its repeats, guarded by its own lock.
+type dedup struct {
+	sync.Mutex
+	key     string
+	entry   *logrus.Entry
+	app     string
+	since   time.Time
+	repeats int
+	once    sync.Once
+}
+
+// duplicate reports whether entry repeats the previous message within DedupWindow.
+// Repeats are suppressed and reported by one message with repeat_count field when window closes
+// or different message comes.
+func (h *Hook) duplicate(entry *logrus.Entry, app string) bool {
+	d := &h.dedup
+	d.once.Do(func() { go h.dedupSummary() })
+
+	key := app + "\x00" + entry.Level.String() + "\x00" + entry.Message
+	if h.DedupFields {
+		key += "\x00" + fmt.Sprint(entry.Data)
+	}
+	now := time.Now()
+
+	d.Lock()
+	if key == d.key && now.Sub(d.since) < h.DedupWindow {
+		d.repeats++
+		d.Unlock()
+		return true
+	}
+	prev, prevApp, repeats := d.entry, d.app, d.repeats
+	d.key, d.entry, d.app, d.since, d.repeats = key, snapshot(entry), app, now, 0
+	d.Unlock()
+
+	if repeats > 0 {
+		_ = h.submit(repeated(prev, repeats), prevApp)
+	}
+	return false
+}
+
+// dedupSummary reports repeats of the last message when its window closes.
+func (h *Hook) dedupSummary() {
+	d := &h.dedup
+	ticker := time.NewTicker(h.DedupWindow / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+		}
+
+		d.Lock()
+		if d.repeats == 0 || time.Since(d.since) < h.DedupWindow {
+			d.Unlock()
+			continue
+		}
+		prev, prevApp, repeats := d.entry, d.app, d.repeats
+		d.key, d.entry, d.repeats = "", nil, 0
+		d.Unlock()
+		if !h.closing.Load() {
+			_ = h.submit(repeated(prev, repeats), prevApp)
+		}
+	}
+}
+
+// repeated returns copy of entry with repeat_count custom field.
+func repeated(entry *logrus.Entry, repeats int) *logrus.Entry {
+	summary := *entry
+	sep := "@@"
+	if strings.Contains(entry.Message, "@@") {
+		sep = "@"
+	}
+	summary.Message = fmt.Sprintf("%s%srepeat_count=%d", entry.Message, sep, repeats)
+	summary.Time = time.Now()
+	return &summary
+}
@@ -0,0 +1,50 @@
+package logrusld
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDedup(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.DedupWindow = 100 * time.Millisecond
+	hook.DedupFields = true
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		_ = hook.Fire(testEntry("retrying"))
+	}
+	_ = hook.Fire(testEntry("done"))
+	waitMessages(t, frames, []string{"retrying"})
+	summary := <-frames
+	if summary["msg"] != "retrying" || summary["repeat_count"] != "4" {
+		t.Fatalf("summary = %v", summary)
+	}
+	waitMessages(t, frames, []string{"done"})
+
+	// Different fields are not duplicates.
+	entry := testEntry("done")
+	entry.Data = logrus.Fields{"id": 1}
+	_ = hook.Fire(entry)
+	waitMessages(t, frames, []string{"done"})
+
+	// Summary is sent when window closes.
+	_ = hook.Fire(entry)
+	_ = hook.Fire(entry)
+	select {
+	case summary = <-frames:
+		if summary["msg"] != "done" || summary["repeat_count"] != "2" {
+			t.Fatalf("summary = %v", summary)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("summary was not sent")
+	}
+}
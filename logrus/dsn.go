@@ -0,0 +1,129 @@
+package logrusld
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ParseDSN creates hook from a single configuration string, e.g.
+//
+//	logdoc://host:5656?app=myapp&level=info&tls=true&timeout=5s&queue=10000
+//
+// Supported parameters:
+//
+//	app           application name
+//	level         most verbose level sent to LogDoc (trace, debug, info, warn, error, fatal, panic)
+//	transport     tcp (default), udp, unix, http or https; for unix the DSN path is the socket file
+//	tls           connect over TLS (tcp only)
+//	timeout       write timeout
+//	dial_timeout  dial timeout
+//	queue         async buffer size, switches hook to async mode
+//	block         wait for free space in async buffer instead of dropping messages
+//	pool          number of connections
+//	failover      comma separated list of secondary addresses
+//
+// Unknown parameters and settings rejected by Validate are reported as error. Hook doesn't connect until the
+// first message or Connect.
+func ParseDSN(dsn string) (*Hook, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LogDoc DSN: %w", err)
+	}
+	if u.Scheme != "logdoc" {
+		return nil, fmt.Errorf("invalid LogDoc DSN scheme %q, expected logdoc", u.Scheme)
+	}
+
+	query := u.Query()
+	transport := query.Get("transport")
+	if transport == "" {
+		transport = "tcp"
+	}
+	var address string
+	switch transport {
+	case "tcp", "udp":
+		address = u.Host
+	case "unix":
+		address = u.Path
+	case "http", "https":
+		address = transport + "://" + u.Host + u.Path
+	default:
+		return nil, fmt.Errorf("invalid LogDoc DSN transport %q", transport)
+	}
+	if address == "" {
+		return nil, fmt.Errorf("LogDoc DSN has no address: %s", dsn)
+	}
+
+	hook := NewLazyHook(transport, address)
+	var queue int
+	for _, key := range sortedKeys(query) {
+		value := query.Get(key)
+		switch key {
+		case "transport":
+		case "app":
+			hook.App = value
+		case "level":
+			if hook.Level, err = logrus.ParseLevel(value); err != nil {
+				return nil, fmt.Errorf("invalid LogDoc DSN level: %w", err)
+			}
+			hook.levelSet = true
+		case "tls":
+			var on bool
+			if on, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid LogDoc DSN tls: %w", err)
+			}
+			if on {
+				hook.TLSConfig = &tls.Config{}
+			}
+		case "timeout":
+			if hook.Timeout, err = time.ParseDuration(value); err != nil {
+				return nil, fmt.Errorf("invalid LogDoc DSN timeout: %w", err)
+			}
+		case "dial_timeout":
+			if hook.DialTimeout, err = time.ParseDuration(value); err != nil {
+				return nil, fmt.Errorf("invalid LogDoc DSN dial_timeout: %w", err)
+			}
+		case "queue":
+			if queue, err = strconv.Atoi(value); err != nil || queue <= 0 {
+				return nil, fmt.Errorf("invalid LogDoc DSN queue %q", value)
+			}
+		case "block":
+			if hook.WaitUntilBufferFrees, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid LogDoc DSN block: %w", err)
+			}
+		case "pool":
+			if hook.PoolSize, err = strconv.Atoi(value); err != nil || hook.PoolSize <= 0 {
+				return nil, fmt.Errorf("invalid LogDoc DSN pool %q", value)
+			}
+		case "failover":
+			hook.FailoverAddresses = strings.Split(value, ",")
+		default:
+			return nil, fmt.Errorf("unknown LogDoc DSN parameter %q", key)
+		}
+	}
+
+	if err = hook.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid LogDoc DSN: %w", err)
+	}
+	if queue > 0 {
+		hook.AsyncBufferSize = queue
+		hook.MakeAsync()
+	}
+	return hook, nil
+}
+
+// sortedKeys makes error for several bad parameters deterministic.
+func sortedKeys(query url.Values) []string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,85 @@
+package logrusld
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseDSN(t *testing.T) {
+	hook, err := ParseDSN("logdoc://logdoc.local:5656?app=myapp&level=info&tls=true&timeout=5s&dial_timeout=1s&queue=100&pool=2&failover=b:1,c:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+
+	if hook.protocol != "tcp" || hook.address != "logdoc.local:5656" {
+		t.Fatalf("protocol=%s address=%s", hook.protocol, hook.address)
+	}
+	if hook.App != "myapp" || hook.Level != logrus.InfoLevel || hook.TLSConfig == nil {
+		t.Fatalf("app=%s level=%s tls=%v", hook.App, hook.Level, hook.TLSConfig)
+	}
+	if hook.Timeout != 5*time.Second || hook.DialTimeout != time.Second {
+		t.Fatalf("timeout=%s dial_timeout=%s", hook.Timeout, hook.DialTimeout)
+	}
+	if hook.fireChannel == nil || cap(hook.fireChannel) != 100 || hook.PoolSize != 2 || len(hook.FailoverAddresses) != 2 {
+		t.Fatalf("queue=%d pool=%d failover=%v", cap(hook.fireChannel), hook.PoolSize, hook.FailoverAddresses)
+	}
+	if levels := hook.Levels(); levels[len(levels)-1] != logrus.InfoLevel {
+		t.Fatalf("levels = %v", levels)
+	}
+}
+
+func TestParseDSNPanicLevel(t *testing.T) {
+	hook, err := ParseDSN("logdoc://host:1?level=panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+	if levels := hook.Levels(); len(levels) != 1 || levels[0] != logrus.PanicLevel {
+		t.Fatalf("levels = %v", levels)
+	}
+
+	// Level not set at all is DebugLevel.
+	if levels := NewLazyHook("tcp", "host:1").Levels(); levels[len(levels)-1] != logrus.DebugLevel {
+		t.Fatalf("default levels = %v", levels)
+	}
+}
+
+func TestParseDSNTransports(t *testing.T) {
+	tests := []struct {
+		dsn, protocol, address string
+	}{
+		{"logdoc://host:1?transport=udp", "udp", "host:1"},
+		{"logdoc:///run/logdoc.sock?transport=unix", "unix", "/run/logdoc.sock"},
+		{"logdoc://host/intake?transport=https", "https", "host/intake"},
+	}
+	for _, tt := range tests {
+		hook, err := ParseDSN(tt.dsn)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.dsn, err)
+		}
+		if hook.protocol != tt.protocol || hook.address != tt.address {
+			t.Fatalf("%s: protocol=%s address=%s", tt.dsn, hook.protocol, hook.address)
+		}
+	}
+}
+
+func TestParseDSNErrors(t *testing.T) {
+	tests := map[string]string{
+		"http://host:1":                          "scheme",
+		"logdoc://host:1?colour=red":             `unknown LogDoc DSN parameter "colour"`,
+		"logdoc://host:1?level=loud":             "level",
+		"logdoc://host:1?transport=udp&tls=true": "TLS is not supported over UDP",
+		"logdoc://host:1?queue=-1":               "queue",
+		"logdoc://host:1?transport=smtp":         "transport",
+		"logdoc://?app=x":                        "no address",
+	}
+	for dsn, want := range tests {
+		if _, err := ParseDSN(dsn); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: error = %v, want %q", dsn, err, want)
+		}
+	}
+}
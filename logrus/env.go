@@ -0,0 +1,118 @@
+package logrusld
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultEnvAddress = "localhost:5656"
+
+// FromEnv creates hook configured by environment variables, then by opts, which override them:
+//
+//	LOGDOC_ADDRESS       host:port of LogDoc server or URL like udp://host:port, localhost:5656 by default
+//	LOGDOC_PROTO         tcp (default), udp, unix, http or https, if address has no scheme
+//	LOGDOC_APP           application name
+//	LOGDOC_LEVEL         most verbose level sent to LogDoc (trace, debug, info, warn, error, fatal, panic)
+//	LOGDOC_TLS           connect over TLS
+//	LOGDOC_QUEUE_SIZE    async buffer size, switches hook to async mode
+//	LOGDOC_BLOCK         wait for free space in async buffer instead of dropping messages
+//	LOGDOC_TIMEOUT       write timeout
+//	LOGDOC_DIAL_TIMEOUT  dial timeout
+//	LOGDOC_POOL          number of connections
+//	LOGDOC_FAILOVER      comma separated list of secondary addresses
+//	LOGDOC_SPOOL_DIR     directory of disk spool
+//
+// Error lists every invalid variable. Like New, hook doesn't connect until the first message or Connect.
+func FromEnv(opts ...Option) (*Hook, error) {
+	address := os.Getenv("LOGDOC_ADDRESS")
+	if address == "" {
+		address = defaultEnvAddress
+	}
+	if proto := os.Getenv("LOGDOC_PROTO"); proto != "" && !strings.Contains(address, "://") {
+		address = proto + "://" + address
+	}
+
+	var env []Option
+	var errs []error
+	invalid := func(name, value string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q: %w", name, value, err))
+		} else {
+			errs = append(errs, fmt.Errorf("invalid %s %q", name, value))
+		}
+	}
+	lookup := func(name string, parse func(value string) (Option, error)) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+		opt, err := parse(value)
+		if err != nil || opt == nil {
+			invalid(name, value, err)
+			return
+		}
+		env = append(env, opt)
+	}
+
+	lookup("LOGDOC_APP", func(value string) (Option, error) { return WithApp(value), nil })
+	lookup("LOGDOC_LEVEL", func(value string) (Option, error) {
+		level, err := logrus.ParseLevel(value)
+		return WithLevel(level), err
+	})
+	lookup("LOGDOC_TLS", func(value string) (Option, error) {
+		on, err := strconv.ParseBool(value)
+		if err != nil || !on {
+			return func(*Hook) {}, err
+		}
+		return WithTLS(nil), nil
+	})
+	lookup("LOGDOC_QUEUE_SIZE", positive(WithQueue))
+	lookup("LOGDOC_BLOCK", func(value string) (Option, error) {
+		on, err := strconv.ParseBool(value)
+		return func(h *Hook) { h.WaitUntilBufferFrees = on }, err
+	})
+	lookup("LOGDOC_TIMEOUT", duration(WithTimeout))
+	lookup("LOGDOC_DIAL_TIMEOUT", duration(WithDialTimeout))
+	lookup("LOGDOC_POOL", positive(WithPool))
+	lookup("LOGDOC_FAILOVER", func(value string) (Option, error) { return WithFailover(strings.Split(value, ",")...), nil })
+	lookup("LOGDOC_SPOOL_DIR", func(value string) (Option, error) { return WithSpool(value), nil })
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("LogDoc environment: %w", errors.Join(errs...))
+	}
+	return New(address, append(env, opts...)...)
+}
+
+// SetupFromEnv creates hook by FromEnv, adds it to logrus standard logger and connects, so that service needs
+// a single line in main, besides closing the hook on exit. Like Init, it returns hook with error of the initial
+// dial, and hook keeps reconnecting in background; on configuration error hook is nil.
+func SetupFromEnv(opts ...Option) (*Hook, error) {
+	hook, err := FromEnv(opts...)
+	if err != nil {
+		return nil, err
+	}
+	logrus.AddHook(hook)
+	return hook, hook.Connect()
+}
+
+func positive(with func(int) Option) func(string) (Option, error) {
+	return func(value string) (Option, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, err
+		}
+		return with(n), nil
+	}
+}
+
+func duration(with func(time.Duration) Option) func(string) (Option, error) {
+	return func(value string) (Option, error) {
+		d, err := time.ParseDuration(value)
+		return with(d), err
+	}
+}
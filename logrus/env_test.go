@@ -0,0 +1,91 @@
+package logrusld
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("LOGDOC_ADDRESS", "logdoc.local:5656")
+	t.Setenv("LOGDOC_PROTO", "udp")
+	t.Setenv("LOGDOC_APP", "billing")
+	t.Setenv("LOGDOC_LEVEL", "warn")
+	t.Setenv("LOGDOC_QUEUE_SIZE", "100")
+	t.Setenv("LOGDOC_TIMEOUT", "2s")
+	t.Setenv("LOGDOC_FAILOVER", "b:1,c:2")
+
+	hook, err := FromEnv(WithApp("override"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+	if hook.protocol != "udp" || hook.address != "logdoc.local:5656" {
+		t.Fatalf("protocol=%s address=%s", hook.protocol, hook.address)
+	}
+	if hook.App != "override" || hook.Level != logrus.WarnLevel || hook.Timeout != 2*time.Second {
+		t.Fatalf("app=%s level=%s timeout=%s", hook.App, hook.Level, hook.Timeout)
+	}
+	if cap(hook.fireChannel) != 100 || len(hook.FailoverAddresses) != 2 || hook.TLSConfig != nil {
+		t.Fatalf("queue=%d failover=%v tls=%v", cap(hook.fireChannel), hook.FailoverAddresses, hook.TLSConfig)
+	}
+}
+
+func TestFromEnvDefaults(t *testing.T) {
+	for _, name := range []string{"LOGDOC_ADDRESS", "LOGDOC_PROTO", "LOGDOC_TLS", "LOGDOC_QUEUE_SIZE"} {
+		t.Setenv(name, "")
+	}
+	hook, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+	if hook.protocol != "tcp" || hook.address != defaultEnvAddress || hook.fireChannel != nil {
+		t.Fatalf("protocol=%s address=%s async=%v", hook.protocol, hook.address, hook.fireChannel != nil)
+	}
+}
+
+func TestFromEnvErrors(t *testing.T) {
+	t.Setenv("LOGDOC_LEVEL", "loud")
+	t.Setenv("LOGDOC_QUEUE_SIZE", "-5")
+	t.Setenv("LOGDOC_TLS", "maybe")
+	t.Setenv("LOGDOC_TIMEOUT", "5s")
+	_, err := FromEnv()
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{`LOGDOC_LEVEL "loud"`, `LOGDOC_QUEUE_SIZE "-5"`, `LOGDOC_TLS "maybe"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "LOGDOC_TIMEOUT") {
+		t.Errorf("valid variable reported: %v", err)
+	}
+}
+
+func TestSetupFromEnv(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	t.Setenv("LOGDOC_ADDRESS", ln.Addr().String())
+	t.Setenv("LOGDOC_APP", "env")
+
+	saved := logrus.StandardLogger().ReplaceHooks(logrus.LevelHooks{})
+	defer logrus.StandardLogger().ReplaceHooks(saved)
+	hook, err := SetupFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+	logrus.Error("from env")
+	select {
+	case f := <-frames:
+		if f["app"] != "env" || f["msg"] != "from env" {
+			t.Fatalf("frame = %v", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+}
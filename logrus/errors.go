@@ -0,0 +1,59 @@
+package logrusld
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/sirupsen/logrus"
+)
+
+const errorsBufferSize = 64
+
+// PanicError is reported to OnError and Errors when callback such as ReplaceField or Marshaler panics.
+// Message is still sent, as if the callback was not set.
+type PanicError struct {
+	Callback string
+	Value    interface{}
+	Stack    []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v", e.Callback, e.Value)
+}
+
+// protect calls fn and reports its panic as PanicError, which is returned.
+func (h *Hook) protect(callback string, fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			err = &PanicError{Callback: callback, Value: r, Stack: stack}
+			h.reportError(err)
+			if h.OnError == nil {
+				logrus.Errorf("Паника в %s, %v\n%s", callback, r, stack)
+			}
+		}
+	}()
+	fn()
+	return nil
+}
+
+// reportError passes delivery error not returned by Fire to OnError and Errors channel.
+func (h *Hook) reportError(err error) {
+	if h.OnError != nil {
+		h.OnError(err)
+	}
+	select {
+	case h.Errors() <- err:
+	default:
+		// Nobody reads errors or reader is behind.
+	}
+}
+
+// Errors returns channel of delivery errors not returned by Fire, e.g. of async mode. Errors are not
+// reported if channel is full, so reading it is optional.
+func (h *Hook) Errors() chan error {
+	h.errorsOnce.Do(func() {
+		h.errors = make(chan error, errorsBufferSize)
+	})
+	return h.errors
+}
@@ -0,0 +1,108 @@
+package logrusld
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestOnError(t *testing.T) {
+	var down atomic.Bool
+	var attempts atomic.Int32
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.MakeAsync()
+	hook.ReconnectBaseDelay = time.Millisecond
+	hook.MaxReconnectDelay = time.Millisecond
+	hook.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		client, _ := net.Pipe()
+		return fakeConn{Conn: client, down: &down, attempts: &attempts}, nil
+	}
+	reported := make(chan error, 1)
+	hook.OnError = func(err error) {
+		select {
+		case reported <- err:
+		default:
+		}
+	}
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	down.Store(true)
+	if err := hook.Fire(testEntry("lost")); err != nil {
+		t.Fatalf("async Fire returned %v", err)
+	}
+	select {
+	case err := <-reported:
+		if err == nil || errors.Is(err, ErrClosed) {
+			t.Fatalf("OnError got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError was not called")
+	}
+	select {
+	case err := <-hook.Errors():
+		if err == nil {
+			t.Fatal("Errors got nil")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no error in Errors channel")
+	}
+}
+
+func TestCallbackPanics(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.ReplaceField = func(key string, value interface{}) (string, interface{}) {
+		if key == "user" {
+			panic("replace")
+		}
+		return key, value
+	}
+	hook.Marshaler = func(v interface{}) ([]byte, error) { panic("marshal") }
+	hook.LevelMapper = func(level logrus.Level) string { panic("level") }
+	var mu sync.Mutex
+	var callbacks []string
+	hook.OnError = func(err error) {
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) || len(panicErr.Stack) == 0 {
+			t.Errorf("OnError got %v", err)
+			return
+		}
+		mu.Lock()
+		callbacks = append(callbacks, panicErr.Callback)
+		mu.Unlock()
+	}
+	hook.MakeAsync()
+	defer hook.Close()
+
+	entry := testEntry("survived")
+	entry.Data = logrus.Fields{"user": "bob", "ids": []int{1, 2}}
+	if err := hook.Fire(entry); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case f := <-frames:
+		if f["msg"] != "survived" || f["user"] != "bob" || f["ids"] != "[1 2]" || f["lvl"] != "info" {
+			t.Fatalf("frame = %v", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(callbacks)
+	if strings.Join(callbacks, ",") != "LevelMapper,Marshaler,ReplaceField" {
+		t.Fatalf("panics reported for %v", callbacks)
+	}
+}
@@ -0,0 +1,125 @@
+package logrusld
+
+import (
+	"strings"
+	"time"
+
+	"github.com/LogDoc-org/logdoc-go-appender/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Event is entry passed to Filter and Hooks before it is queued and encoded. Hooks may change it, e.g. add tenant
+// field looked up by another one; changes are sent, entry itself is not changed.
+type Event struct {
+	Level   logrus.Level
+	Message string // With custom fields after "@@", if any.
+	Time    time.Time
+	// Fields are fields of entry and, if ContextFields is set, of its context; fields of entry win.
+	Fields map[string]interface{}
+}
+
+// newEvent returns event of entry for Filter and Hooks.
+func (h *Hook) newEvent(entry *logrus.Entry) *Event {
+	event := &Event{Level: entry.Level, Message: entry.Message, Time: entry.Time}
+	event.Fields = make(map[string]interface{}, len(entry.Data))
+	if h.ContextFields != nil && entry.Context != nil {
+		var fields map[string]interface{}
+		if h.protect("ContextFields", func() { fields = h.ContextFields(entry.Context) }) == nil {
+			for k, v := range fields {
+				event.Fields[k] = v
+			}
+		}
+	}
+	for k, v := range entry.Data {
+		event.Fields[k] = v
+	}
+	return event
+}
+
+// filter reports whether event passes Filter, it passes if Filter panics.
+func (h *Hook) filter(event *Event) bool {
+	keep := true
+	if h.protect("Filter", func() { keep = h.Filter(event) }) == nil && !keep {
+		h.filtered.Add(1)
+		return false
+	}
+	return true
+}
+
+// runHooks passes event of entry, new one if nil, to Hooks in order and returns entry with changes made by them,
+// or nil if one of them dropped it. Panicking hook is skipped.
+func (h *Hook) runHooks(entry *logrus.Entry, event *Event) *logrus.Entry {
+	if event == nil {
+		event = h.newEvent(entry)
+	}
+	for _, hook := range h.Hooks {
+		keep := true
+		if h.protect("Hooks", func() { keep = hook(event) }) == nil && !keep {
+			h.vetoed.Add(1)
+			return nil
+		}
+	}
+	c := *entry
+	c.Level, c.Message, c.Time, c.Data = event.Level, event.Message, event.Time, event.Fields
+	if h.ContextFields != nil {
+		// Context fields are in Data already.
+		c.Context = nil
+	}
+	c.Buffer = nil
+	return &c
+}
+
+// Vetoed returns how many entries were dropped by Hooks.
+func (h *Hook) Vetoed() uint64 {
+	return h.vetoed.Load()
+}
+
+// Filtered returns how many entries were dropped by Filter.
+func (h *Hook) Filtered() uint64 {
+	return h.filtered.Load()
+}
+
+// FilterByMessageSubstring returns Filter reporting whether message contains substr, e.g.
+// Not(FilterByMessageSubstring("GET /healthz")) drops health checks.
+func FilterByMessageSubstring(substr string) func(e *Event) bool {
+	return func(e *Event) bool { return strings.Contains(e.Message, substr) }
+}
+
+// FilterByAttr returns Filter reporting whether event has field key with value, compared as they are sent,
+// so that 200 matches "200" too.
+func FilterByAttr(key string, value interface{}) func(e *Event) bool {
+	want := common.FormatValue(value)
+	return func(e *Event) bool {
+		v, ok := e.Fields[key]
+		return ok && common.FormatValue(v) == want
+	}
+}
+
+// Not returns Filter negating f.
+func Not(f func(e *Event) bool) func(e *Event) bool {
+	return func(e *Event) bool { return !f(e) }
+}
+
+// And returns Filter reporting whether all filters pass, evaluated in order until one fails.
+func And(filters ...func(e *Event) bool) func(e *Event) bool {
+	return func(e *Event) bool {
+		for _, f := range filters {
+			if !f(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or returns Filter reporting whether any of filters passes, evaluated in order until one passes.
+func Or(filters ...func(e *Event) bool) func(e *Event) bool {
+	return func(e *Event) bool {
+		for _, f := range filters {
+			if f(e) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,173 @@
+package logrusld
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type tenantKey struct{}
+
+// tenantHook adds tenant of user_id field and drops health checks.
+func tenantHook(e *Event) bool {
+	if strings.HasPrefix(e.Message, "GET /healthz") {
+		return false
+	}
+	if user, ok := e.Fields["user_id"].(string); ok {
+		e.Fields["tenant"] = strings.SplitN(user, ":", 2)[0]
+	}
+	return true
+}
+
+func TestHooks(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.ContextFields = func(ctx context.Context) map[string]interface{} {
+		return map[string]interface{}{"user_id": ctx.Value(tenantKey{})}
+	}
+	hook.Hooks = []func(e *Event) bool{
+		tenantHook,
+		func(e *Event) bool {
+			if e.Message == "panic" {
+				panic("hook")
+			}
+			return true
+		},
+		func(e *Event) bool {
+			if e.Message == "escalated" {
+				e.Level = logrus.ErrorLevel
+			}
+			return true
+		},
+	}
+	hook.OnError = func(error) {}
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := testEntry("handled")
+	entry.Context = context.WithValue(context.Background(), tenantKey{}, "acme:42")
+	for _, msg := range []string{"GET /healthz 200", "handled", "panic", "escalated"} {
+		entry.Message = msg
+		if err := hook.Fire(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []map[string]string{
+		{"msg": "handled", "tenant": "acme", "user_id": "acme:42", "lvl": "info"},
+		{"msg": "panic", "tenant": "acme", "lvl": "info"},
+		{"msg": "escalated", "tenant": "acme", "lvl": "error"},
+	} {
+		var f map[string]string
+		select {
+		case f = <-frames:
+		case <-time.After(time.Second):
+			t.Fatalf("%s was not delivered", want["msg"])
+		}
+		for k, v := range want {
+			if f[k] != v {
+				t.Fatalf("frame = %v, want %s=%s", f, k, v)
+			}
+		}
+	}
+	if n := hook.Vetoed(); n != 1 {
+		t.Fatalf("Vetoed() = %d", n)
+	}
+	if entry.Data != nil || entry.Level != logrus.InfoLevel {
+		t.Fatalf("entry changed to %v", entry)
+	}
+}
+
+func TestFilterHelpers(t *testing.T) {
+	event := &Event{Message: "GET /healthz 200", Fields: map[string]interface{}{"status": 200, "lib": "grpc"}}
+	for name, tc := range map[string]struct {
+		filter func(e *Event) bool
+		want   bool
+	}{
+		"substring":     {FilterByMessageSubstring("/healthz"), true},
+		"no substring":  {FilterByMessageSubstring("/metrics"), false},
+		"attr":          {FilterByAttr("status", "200"), true},
+		"attr value":    {FilterByAttr("lib", "http"), false},
+		"no attr":       {FilterByAttr("path", ""), false},
+		"not":           {Not(FilterByAttr("lib", "grpc")), false},
+		"and":           {And(FilterByAttr("lib", "grpc"), FilterByMessageSubstring("GET")), true},
+		"and fails":     {And(FilterByAttr("lib", "grpc"), FilterByMessageSubstring("POST")), false},
+		"empty and":     {And(), true},
+		"or":            {Or(FilterByAttr("lib", "http"), FilterByAttr("status", 200)), true},
+		"or fails":      {Or(FilterByAttr("lib", "http"), FilterByAttr("status", 500)), false},
+		"empty or":      {Or(), false},
+		"drop combined": {Not(Or(FilterByMessageSubstring("/healthz"), FilterByAttr("lib", "http"))), false},
+	} {
+		if got := tc.filter(event); got != tc.want {
+			t.Errorf("%s = %v", name, got)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.ContextFields = func(ctx context.Context) map[string]interface{} {
+		return map[string]interface{}{"user_id": ctx.Value(tenantKey{})}
+	}
+	hook.Filter = And(
+		Not(FilterByMessageSubstring("GET /healthz")),
+		Not(FilterByAttr("user_id", "noisy:1")),
+		func(e *Event) bool {
+			if e.Message == "panic" {
+				panic("filter")
+			}
+			return true
+		},
+	)
+	hook.OnError = func(error) {}
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ msg, user string }{
+		{"GET /healthz 200", "acme:42"},
+		{"spam", "noisy:1"},
+		{"handled", "acme:42"},
+		{"panic", "acme:42"},
+	} {
+		entry := testEntry(tc.msg)
+		entry.Context = context.WithValue(context.Background(), tenantKey{}, tc.user)
+		if err := hook.Fire(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitMessages(t, frames, []string{"handled", "panic"})
+	if n := hook.Filtered(); n != 2 {
+		t.Fatalf("Filtered() = %d", n)
+	}
+}
+
+func BenchmarkHooks(b *testing.B) {
+	for _, hooks := range []int{0, 1, 4} {
+		b.Run(fmt.Sprint("hooks=", hooks), func(b *testing.B) {
+			hook := NewLazyHook("tcp", "logdoc:5656")
+			for i := 0; i < hooks; i++ {
+				hook.Hooks = append(hook.Hooks, tenantHook)
+			}
+			entry := testEntry("request handled")
+			entry.Data = logrus.Fields{"status": 200, "path": "/api/v1/users", "user_id": "acme:42"}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				e := entry
+				if len(hook.Hooks) > 0 {
+					e = hook.runHooks(entry, nil)
+				}
+				hook.encodeFields(e, "app", "")
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package logrusld
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultFailoverThreshold = 3
+	defaultFailoverCooldown  = 30 * time.Second
+)
+
+// endpoint is one of LogDoc server addresses hook can fail over to.
+type endpoint struct {
+	address  string
+	failures atomic.Int32 // Consecutive dial and write failures.
+}
+
+// ActiveAddress returns address of LogDoc endpoint hook currently sends messages to.
+func (h *Hook) ActiveAddress() string {
+	h.Lock()
+	defer h.Unlock()
+	return h.ensureEndpoints()[h.active].address
+}
+
+// ensureEndpoints builds endpoint list from address and FailoverAddresses on first use.
+// Must be called with h locked.
+func (h *Hook) ensureEndpoints() []*endpoint {
+	if h.endpoints == nil {
+		h.endpoints = append(h.endpoints, &endpoint{address: h.address})
+		for _, address := range h.FailoverAddresses {
+			_, address = splitAddress(h.protocol, address)
+			h.endpoints = append(h.endpoints, &endpoint{address: address})
+		}
+	}
+	return h.endpoints
+}
+
+// dial connects the active endpoint. When hook has failed over and FailoverCooldown elapsed,
+// the primary endpoint is tried first, so hook gets back to it once it recovers.
+func (h *Hook) dial() (net.Conn, *endpoint, error) {
+	h.Lock()
+	endpoints := h.ensureEndpoints()
+	active := endpoints[h.active]
+	var primary *endpoint
+	if h.active != 0 && time.Since(h.failedOverAt) >= h.failoverCooldown() {
+		primary = endpoints[0]
+	}
+	h.Unlock()
+
+	if primary != nil {
+		if conn, err := h.dialAddress(context.Background(), primary.address); err == nil {
+			h.notifyFailover(h.endpointConnected(primary))
+			return conn, primary, nil
+		}
+		// Primary is still down, wait another cooldown before the next try.
+		h.Lock()
+		h.failedOverAt = time.Now()
+		h.Unlock()
+	}
+
+	conn, err := h.dialAddress(context.Background(), active.address)
+	if err != nil {
+		h.notifyFailover(h.endpointFailed(active))
+		return nil, nil, err
+	}
+	return conn, active, nil
+}
+
+// endpointConnected handles successful dial, returning address hook switched to, if any.
+func (h *Hook) endpointConnected(ep *endpoint) string {
+	ep.failures.Store(0)
+	h.Lock()
+	defer h.Unlock()
+	if ep == h.endpoints[h.active] {
+		return ""
+	}
+	for i, e := range h.endpoints {
+		if e == ep {
+			h.active = i
+		}
+	}
+	return ep.address
+}
+
+// endpointFailed counts failure of endpoint and moves to the next one after FailoverThreshold
+// consecutive failures. Returns address hook switched to, if any.
+func (h *Hook) endpointFailed(ep *endpoint) string {
+	if ep == nil {
+		return ""
+	}
+	failures := ep.failures.Add(1)
+
+	h.Lock()
+	defer h.Unlock()
+	if len(h.endpoints) < 2 || ep != h.endpoints[h.active] || int(failures) < h.failoverThreshold() {
+		return ""
+	}
+	h.active = (h.active + 1) % len(h.endpoints)
+	h.failedOverAt = time.Now()
+	next := h.endpoints[h.active]
+	next.failures.Store(0)
+	return next.address
+}
+
+func (h *Hook) notifyFailover(address string) {
+	if address != "" && h.OnFailover != nil {
+		h.OnFailover(address)
+	}
+}
+
+func (h *Hook) failoverThreshold() int {
+	if h.FailoverThreshold > 0 {
+		return h.FailoverThreshold
+	}
+	return defaultFailoverThreshold
+}
+
+func (h *Hook) failoverCooldown() time.Duration {
+	if h.FailoverCooldown > 0 {
+		return h.FailoverCooldown
+	}
+	return defaultFailoverCooldown
+}
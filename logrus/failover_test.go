@@ -0,0 +1,72 @@
+package logrusld
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFailover(t *testing.T) {
+	// Reserve address for the primary endpoint, which is down at start.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := ln.Addr().String()
+	_ = ln.Close()
+
+	secondary := startTestServer(t, "127.0.0.1:0")
+	defer secondary.stop()
+
+	var mu sync.Mutex
+	var switched []string
+	hook := NewLazyHook("tcp", primary)
+	hook.FailoverAddresses = []string{secondary.ln.Addr().String()}
+	hook.FailoverThreshold = 2
+	hook.FailoverCooldown = 50 * time.Millisecond
+	hook.ReconnectBaseDelay = 5 * time.Millisecond
+	hook.MaxReconnectDelay = 10 * time.Millisecond
+	hook.OnFailover = func(address string) {
+		mu.Lock()
+		switched = append(switched, address)
+		mu.Unlock()
+	}
+	defer hook.Close()
+
+	_ = hook.Connect()
+	delivered := false
+	for i := 0; i < 200 && !delivered; i++ {
+		_ = hook.write([]byte("failover\n"))
+		delivered = secondary.waitLine(t, "failover", 10*time.Millisecond)
+	}
+	if !delivered {
+		t.Fatal("message was not delivered to secondary endpoint")
+	}
+	if hook.ActiveAddress() != secondary.ln.Addr().String() {
+		t.Fatalf("active = %s", hook.ActiveAddress())
+	}
+
+	// Primary recovers: after cooldown the next reconnect goes back to it.
+	recovered := startTestServer(t, primary)
+	defer recovered.stop()
+	time.Sleep(60 * time.Millisecond)
+	hook.Lock()
+	_ = hook.links[0].conn.Close()
+	hook.Unlock()
+
+	delivered = false
+	for i := 0; i < 200 && !delivered; i++ {
+		_ = hook.write([]byte("primary\n"))
+		delivered = recovered.waitLine(t, "primary", 10*time.Millisecond)
+	}
+	if !delivered {
+		t.Fatal("hook did not return to primary endpoint")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(switched) != 2 || switched[0] != secondary.ln.Addr().String() || switched[1] != primary {
+		t.Fatalf("failover notifications = %v", switched)
+	}
+}
@@ -0,0 +1,52 @@
+package logrusld
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultHeartbeatMessage = "heartbeat"
+
+// heartbeat writes heartbeat message to every connection idle for HeartbeatInterval, so that
+// load balancer doesn't drop it and dead server is noticed before the next real message.
+// Heartbeats are not counted in PoolStats.
+func (h *Hook) heartbeat() {
+	ticker := time.NewTicker(h.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+		}
+
+		h.RLock()
+		links := h.links
+		h.RUnlock()
+		for _, l := range links {
+			if time.Since(time.Unix(0, l.lastWrite.Load())) < h.HeartbeatInterval || !l.connected() {
+				continue
+			}
+			_ = l.writeData(h.frame(h.encodeFields(h.heartbeatEntry(), h.App, h.eventID())))
+		}
+	}
+}
+
+func (h *Hook) heartbeatEntry() *logrus.Entry {
+	msg := h.HeartbeatMessage
+	if msg == "" {
+		msg = defaultHeartbeatMessage
+	}
+	level := h.HeartbeatLevel
+	if level == logrus.PanicLevel {
+		level = logrus.DebugLevel
+	}
+	return &logrus.Entry{
+		Message: msg,
+		Level:   level,
+		Time:    time.Now(),
+		Caller:  &runtime.Frame{Function: "logrusld.heartbeat"},
+	}
+}
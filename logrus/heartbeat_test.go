@@ -0,0 +1,45 @@
+package logrusld
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHeartbeat(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.HeartbeatInterval = 20 * time.Millisecond
+	hook.HeartbeatLevel = logrus.InfoLevel
+	hook.HeartbeatMessage = "ping"
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case f := <-frames:
+			if f["msg"] != "ping" || f["lvl"] != "info" {
+				t.Fatalf("heartbeat = %v", f)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("heartbeat was not sent")
+		}
+	}
+	if writes := hook.PoolStats()[0].Writes; writes != 0 {
+		t.Fatalf("heartbeats counted as %d writes", writes)
+	}
+
+	_ = hook.Close()
+	time.Sleep(50 * time.Millisecond)
+	for len(frames) > 0 {
+		<-frames
+	}
+	select {
+	case f := <-frames:
+		t.Fatalf("heartbeat after close: %v", f)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,88 @@
+package logrusld
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func (h *Hook) isHTTP() bool {
+	return h.protocol == "http" || h.protocol == "https"
+}
+
+// post sends encoded fields to LogDoc HTTP intake endpoint.
+// Failed requests and non-2xx responses are retried up to MaxSendRetries times with backoff,
+// which blocks the caller, so HTTP transport is best used in async mode.
+func (h *Hook) post(fields []byte) error {
+	body := fields
+	if h.HTTPGzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(fields)
+		if err := zw.Close(); err != nil {
+			return &encodeError{err}
+		}
+		body = buf.Bytes()
+	}
+
+	return h.retry(func() error { return h.postOnce(body) })
+}
+
+func (h *Hook) postOnce(body []byte) error {
+	req, err := h.newRequest(context.Background(), http.MethodPost, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if h.HTTPGzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return h.do(req, func(status int) bool { return status >= 200 && status <= 299 })
+}
+
+// pingHTTP checks that LogDoc intake endpoint responds. Any response except server error is fine,
+// because endpoint is not required to support HEAD.
+func (h *Hook) pingHTTP(ctx context.Context) error {
+	req, err := h.newRequest(ctx, http.MethodHead, nil)
+	if err != nil {
+		return err
+	}
+	return h.do(req, func(status int) bool { return status < 500 })
+}
+
+func (h *Hook) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, h.protocol+"://"+h.address, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case h.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+h.BearerToken)
+	case h.BasicAuth != nil:
+		password, _ := h.BasicAuth.Password()
+		req.SetBasicAuth(h.BasicAuth.Username(), password)
+	}
+	return req, nil
+}
+
+func (h *Hook) do(req *http.Request, ok func(status int) bool) error {
+	client := h.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain body, so that connection is reused.
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if !ok(resp.StatusCode) {
+		return fmt.Errorf("LogDoc server responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
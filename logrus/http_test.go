@@ -0,0 +1,85 @@
+package logrusld
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPTransport(t *testing.T) {
+	var requests atomic.Int32
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			// First attempt fails, hook should retry.
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			http.Error(w, "not compressed", http.StatusBadRequest)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(zr)
+		bodies <- string(body)
+	}))
+	defer srv.Close()
+
+	hook := NewLazyHook("tcp", srv.URL+"/intake")
+	hook.HTTPClient = srv.Client()
+	hook.HTTPGzip = true
+	hook.BasicAuth = url.UserPassword("user", "secret")
+	hook.MaxSendRetries = 2
+	hook.ReconnectBaseDelay = time.Millisecond
+	defer hook.Close()
+
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	entry := testEntry("over http")
+	if err := hook.post(hook.encodeFields(entry, "", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case body := <-bodies:
+		if !strings.HasPrefix(body, "msg=over http\n") {
+			t.Fatalf("body = %q", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request was not received")
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("requests = %d", requests.Load())
+	}
+}
+
+func TestHTTPTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rejected", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	hook := NewLazyHook("tcp", srv.URL)
+	hook.BearerToken = "token"
+	defer hook.Close()
+
+	err := hook.post([]byte("msg=x\n"))
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("error = %v", err)
+	}
+}
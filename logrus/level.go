@@ -0,0 +1,98 @@
+package logrusld
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LevelVar is level of hook which can be changed at runtime, safe for concurrent use. Zero value is InfoLevel.
+// It is also http.Handler: GET returns {"level":"info"}, PUT with the same JSON body or level form value
+// sets it, like zap.AtomicLevel.
+type LevelVar struct {
+	level atomic.Uint32 // InfoLevel − level, so that zero value is InfoLevel.
+}
+
+// NewLevelVar returns LevelVar set to level.
+func NewLevelVar(level logrus.Level) *LevelVar {
+	v := &LevelVar{}
+	v.Set(level)
+	return v
+}
+
+// Level returns the most verbose level sent to LogDoc.
+func (v *LevelVar) Level() logrus.Level {
+	return logrus.InfoLevel - logrus.Level(v.level.Load())
+}
+
+// Set changes level, messages fired after it returns are filtered by the new one.
+func (v *LevelVar) Set(level logrus.Level) {
+	v.level.Store(uint32(logrus.InfoLevel - level))
+}
+
+func (v *LevelVar) String() string {
+	return v.Level().String()
+}
+
+type levelPayload struct {
+	Level *string `json:"level"`
+	Error string  `json:"error,omitempty"`
+}
+
+func (v *LevelVar) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		level, err := requestedLevel(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(levelPayload{Error: err.Error()})
+			return
+		}
+		v.Set(level)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_ = json.NewEncoder(w).Encode(levelPayload{Error: "only GET and PUT are supported"})
+		return
+	}
+	name := v.String()
+	_ = json.NewEncoder(w).Encode(levelPayload{Level: &name})
+}
+
+// requestedLevel parses level of PUT request: level form value or JSON body.
+func requestedLevel(r *http.Request) (logrus.Level, error) {
+	if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		return logrus.ParseLevel(r.FormValue("level"))
+	}
+	var payload levelPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return 0, err
+	}
+	if payload.Level == nil {
+		return 0, errors.New("level is not set")
+	}
+	return logrus.ParseLevel(*payload.Level)
+}
+
+// ErrStaticLevel is returned by SetLevel of hook without LevelVar.
+var ErrStaticLevel = errors.New("LogDoc hook level can't be changed without LevelVar")
+
+// SetLevel changes level of hook created with LevelVar, see WithLevelVar. Level of other hooks is fixed,
+// because logger reads Levels once, when hook is added, and then ErrStaticLevel is returned.
+func (h *Hook) SetLevel(level logrus.Level) error {
+	if h.LevelVar == nil {
+		return ErrStaticLevel
+	}
+	h.LevelVar.Set(level)
+	return nil
+}
+
+// enabled reports whether entry level passes LevelVar.
+func (h *Hook) enabled(level logrus.Level) bool {
+	return h.LevelVar == nil || level <= h.LevelVar.Level()
+}
@@ -0,0 +1,121 @@
+package logrusld
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLevelVar(t *testing.T) {
+	var v LevelVar
+	if v.Level() != logrus.InfoLevel {
+		t.Fatalf("zero value = %s", v.Level())
+	}
+	for _, level := range logrus.AllLevels {
+		v.Set(level)
+		if v.Level() != level {
+			t.Fatalf("Set(%s) = %s", level, v.Level())
+		}
+	}
+	if NewLevelVar(logrus.WarnLevel).String() != "warning" {
+		t.Fatal("NewLevelVar level is lost")
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	if err := NewLazyHook("tcp", "logdoc:5656").SetLevel(logrus.DebugLevel); err != ErrStaticLevel {
+		t.Fatalf("SetLevel without LevelVar = %v", err)
+	}
+
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	hook, err := New(ln.Addr().String(), WithLevelVar(NewLevelVar(logrus.InfoLevel)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	l.SetLevel(logrus.TraceLevel)
+	l.AddHook(hook)
+
+	l.Debug("dropped")
+	if err := hook.SetLevel(logrus.DebugLevel); err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("sent")
+	select {
+	case f := <-frames:
+		if f["msg"] != "sent" {
+			t.Fatalf("frame = %v", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("debug message was not sent after SetLevel")
+	}
+
+	// Level changes race with logging.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i == 0 {
+					hook.LevelVar.Set(logrus.AllLevels[j%len(logrus.AllLevels)])
+				} else {
+					l.Trace("trace")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestLevelVarHTTP(t *testing.T) {
+	v := NewLevelVar(logrus.InfoLevel)
+	srv := httptest.NewServer(v)
+	defer srv.Close()
+
+	do := func(method, contentType, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	if code, body := do(http.MethodGet, "", ""); code != http.StatusOK || body != `{"level":"info"}` {
+		t.Fatalf("GET = %d %s", code, body)
+	}
+	if code, body := do(http.MethodPut, "application/json", `{"level":"debug"}`); code != http.StatusOK || body != `{"level":"debug"}` {
+		t.Fatalf("PUT = %d %s", code, body)
+	}
+	if code, _ := do(http.MethodPut, "application/x-www-form-urlencoded", "level=error"); code != http.StatusOK || v.Level() != logrus.ErrorLevel {
+		t.Fatalf("PUT form = %d, level %s", code, v.Level())
+	}
+	if code, body := do(http.MethodPut, "application/json", `{"level":"loud"}`); code != http.StatusBadRequest || !strings.Contains(body, "error") {
+		t.Fatalf("PUT bad level = %d %s", code, body)
+	}
+	if code, _ := do(http.MethodPost, "", ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST = %d", code)
+	}
+	if v.Level() != logrus.ErrorLevel {
+		t.Fatalf("level = %s", v.Level())
+	}
+}
@@ -1,16 +1,20 @@
 package logrusld
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/LogDoc-org/logdoc-go-appender/common"
 	"github.com/sirupsen/logrus"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"runtime"
-	"strconv"
-	"strings"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,96 +28,980 @@ func GetLogger() *logrus.Logger {
 	return lgr
 }
 
+// OverflowPolicy declares what happens to message when async buffer is full.
+type OverflowPolicy int
+
+const (
+	OverflowDropNewest OverflowPolicy = iota // Drop the new message, default.
+	OverflowBlock                            // Wait until buffer frees, at most BlockTimeout if set.
+	OverflowDropOldest                       // Drop the oldest queued message to keep recent ones.
+)
+
 type Hook struct {
 	sync.RWMutex
-	conn                     net.Conn
+	links                    []*link
 	protocol                 string
 	address                  string
-	appName                  string
+	App                      string // Value of app field, see Init and AppSource.
 	alwaysSentFields         logrus.Fields
 	hookOnlyPrefix           string
-	TimeFormat               string
-	fireChannel              chan *logrus.Entry
+	TimeFormat               string // Format of tsrc field, common.DefaultTimeFormat if not set.
+	fireChannel              chan queued
 	AsyncBufferSize          int
 	WaitUntilBufferFrees     bool
-	Timeout                  time.Duration // Timeout for sending message.
-	MaxSendRetries           int           // Declares how many times we will try to resend message.
+	AsyncWorkers             int           // Goroutines sending async buffer, 1 by default to keep messages order.
+	Sync                     bool          // Like ReturnErrors, and failed write is re-dialed and retried once.
+	ReturnErrors             bool          // Fire returns send error (unless MakeAsync is called) or ErrQueueFull instead of nil.
+	Level                    logrus.Level  // Most verbose level sent to LogDoc, DebugLevel if not set (PanicLevel needs WithLevel).
+	LevelVar                 *LevelVar     // Level changed at runtime, overrides Level; set before hook is added.
+	Timeout                  time.Duration // Timeout for sending message, 5s by default, negative – no timeout.
+	MaxSendRetries           int           // Declares how many times we will try to resend message, with backoff.
+	RetryBudget              int           // Max messages resent per minute, the rest fail at once; no limit if 0.
 	ReconnectBaseDelay       time.Duration // First reconnect delay.
 	ReconnectDelayMultiplier float64       // Base multiplier for delay before reconnect.
-	MaxReconnectRetries      int           // Declares how many times we will try to reconnect.
+	MaxReconnectRetries      int           // Declares how many times we will try to reconnect, 0 – until connected.
+	MaxReconnectDelay        time.Duration // Upper bound for delay before reconnect.
+	DialTimeout              time.Duration // Timeout for connecting LogDoc server.
+	CloseTimeout             time.Duration // How long Close waits for queued and in-flight messages, 1s by default.
+	MaxDatagramSize          int           // Max message size in UDP mode, bigger messages are dropped.
+	PoolSize                 int           // Number of connections to LogDoc server, messages are written round-robin.
+	MaxIdle                  time.Duration // Connection idle for longer is checked and re-dialed before writing.
+
+	// What to do when async buffer is full, WaitUntilBufferFrees means OverflowBlock.
+	OverflowPolicy OverflowPolicy
+	BlockTimeout   time.Duration // Max wait of OverflowBlock, no limit if 0.
+
+	// In-memory replay buffer. Messages that can't be sent are kept there (at most ReplayBuffer messages
+	// and ReplayBufferBytes bytes, if set) and sent in order after reconnect; the oldest are dropped on overflow.
+	ReplayBuffer      int
+	ReplayBufferBytes int
+
+	// Startup buffer. Until the first connect up to StartupBuffer messages (and StartupBufferBytes bytes, if set)
+	// are kept in memory and sent in order with their original time once connected. If hook doesn't connect
+	// within StartupTimeout (30s by default) after the first kept message, they go to OnDeadLetter and Fallback.
+	StartupBuffer      int
+	StartupBufferBytes int
+	StartupTimeout     time.Duration
+
+	// Multi-line messages, e.g. stack traces and SQL statements, are sent with line breaks in msg. With
+	// SplitMultiline msg is the first line and the rest goes to body field, at most MaxBodyLines lines
+	// (no limit if 0) followed by "... N more lines".
+	SplitMultiline bool
+	MaxBodyLines   int
+
+	// StackTrace adds stacktrace field with stack of the logging goroutine, formatted like panic output, to messages
+	// of StackTraceLevel (ErrorLevel if not set) and more severe, at most StackTraceDepth frames (32 if not set).
+	// If entry field has StackTrace method, e.g. error of github.com/pkg/errors, its stack is sent instead.
+	// Less severe messages skip stack capture entirely.
+	StackTrace      bool
+	StackTraceLevel logrus.Level
+	StackTraceDepth int
+
+	// IncludeGoroutineID adds gid field with ID of the logging goroutine, read by Fire, in async mode too.
+	// Reading it costs microseconds per message, see BenchmarkGoroutineID.
+	IncludeGoroutineID bool
+
+	// TimeLocation is time zone of tsrc field, UTC if nil. Default TimeFormat has no zone, so local time
+	// is ambiguous when clocks go back.
+	TimeLocation *time.Location
+
+	// Marshaler encodes structs, maps and slices in fields, json.Marshal if nil.
+	// If it fails, value is sent formatted with %+v and a warning is logged.
+	Marshaler common.Marshaler
+
+	// Field keys and values are sanitized unless DisableSanitize is set: invalid UTF-8 and control characters
+	// are replaced, values are cut to MaxValueSize bytes (common.DefaultMaxValueSize if 0, negative – no limit).
+	DisableSanitize bool
+	MaxValueSize    int
+
+	// ValueFormat is how durations, times and floats of custom, entry and context fields are sent, e.g.
+	// common.ValueFormat{Duration: common.DurationMillis} for dashboards of milliseconds.
+	ValueFormat common.ValueFormat
+
+	// MaxFields limits number of custom and entry fields of message, e.g. when a big map is attached to every
+	// entry: the rest, in order of keys, are dropped and fields_truncated field with their number is added.
+	// No limit if 0. Dropped fields and cut values are counted in FieldsDropped and ValuesCut.
+	MaxFields int
+
+	// AllowKeys and DenyKeys filter custom, entry and context fields by keys, e.g. DenyKeys internal.* keeps
+	// diagnostics meant for local logs out of LogDoc, see common.KeyFilter. With AllowKeys only fields matching them
+	// are sent, DenyKeys win. Fields are filtered after ReplaceField, before MaxFields, and counted in FieldsFiltered.
+	AllowKeys []string
+	DenyKeys  []string
+
+	// Protocol is framing of messages, common.DefaultProtocol if not set, e.g. for newer LogDoc server revision.
+	Protocol common.Protocol
+
+	// Format is payload of frame: key=value fields (common.FormatKV) by default, or JSON object made by
+	// Marshaler (common.FormatJSON), where map and struct values stay nested objects. JSON is not truncated
+	// by MaxEventBytes, too big messages fail with ErrOversized.
+	Format common.Format
+
+	// Encoding is encoding of fields in frame, common.TextEncoding if not set. common.BinaryEncoding sends
+	// any bytes without escaping, with DisableSanitize, for server which accepts it.
+	Encoding common.FieldEncoder
+
+	// Checksum adds checksum field computed over the other fields of message, after truncation, so that LogDoc
+	// side detects messages changed or cut, see common.VerifyChecksum. Only key=value format is supported.
+	Checksum common.Checksum
+
+	// MaxEventBytes limits size of message frame: the longest values of msg and user fields are cut, and
+	// truncated=true and original_size fields are added. Messages which are still too big fail with ErrOversized.
+	MaxEventBytes int
+
+	// FieldCollision is what is done with custom and entry fields named as fields written by hook
+	// (common.ReservedFields): by default they are sent with "attr_" prefix, so hook fields always win.
+	FieldCollision common.Collision
+
+	// FieldNames renames fields written by hook, e.g. lvl to level, in both formats. User fields named as
+	// renamed fields get "attr_" prefix, see FieldCollision; ReplaceField gets renamed keys.
+	FieldNames common.FieldNames
+
+	// StaticFields are added to every message after entry fields, e.g. env, region and version. They are
+	// encoded once, with the first message, so they should be set before it; names of fields written by hook
+	// are not allowed, see Validate. StaticFieldsFunc, if set, is called for every message for fields which
+	// change rarely, e.g. feature flag cohort; its keys set in StaticFields are ignored.
+	StaticFields     map[string]string
+	StaticFieldsFunc func() map[string]string
+
+	// IncludeBuildInfo adds build information to static fields: vcs.revision, vcs.time, go.version and
+	// module.version, read once and renamed by BuildInfoKeys, see common.BuildFields. Fields missing in build
+	// information are not sent; StaticFields with the same keys win.
+	IncludeBuildInfo bool
+	BuildInfoKeys    common.BuildInfoKeys
+
+	// IncludeKubernetes adds metadata of Kubernetes pod to static fields: k8s.pod, k8s.namespace, k8s.node and
+	// k8s.container_id, resolved once by common.KubernetesFields; missing ones are not sent. For non-standard
+	// setups fields of common.KubernetesFields with overrides may be set in StaticFields instead.
+	IncludeKubernetes bool
+
+	// ContextFields, if set, returns fields of entry context (see logrus.WithContext), e.g. trace_id and span_id
+	// of OpenTelemetry span by otelld.ExtractTraceContext. They are added after entry fields, like them.
+	ContextFields func(ctx context.Context) map[string]interface{}
+
+	// SourceFormat is format of src field, pkg.Func:line by default. Caller is reported only if logger
+	// has SetReportCaller(true). The first of TrimPrefixes, e.g. build directory or module path, which function
+	// or file starts with is stripped from it.
+	SourceFormat common.SourceFormat
+	TrimPrefixes []string
+
+	// CallerSkip reports in src caller of the frame logrus reports, through CallerSkip more frames, e.g. of
+	// logging facade wrapping logrus, like zap.AddCallerSkip. Frames of CallerSkipPackages, e.g.
+	// "github.com/acme/log", are skipped too. Frame reported by logrus is kept if the stack is shorter.
+	CallerSkip         int
+	CallerSkipPackages []string
+
+	// EventID, if set, returns ID of message sent in event_id field, e.g. common.NewEventID for UUIDv7. ID is
+	// given when message is queued and kept on retries, replay and spool, and is in OnDeadLetter payload, so
+	// receivers can drop duplicates.
+	EventID func() string
+
+	// Sequence adds seq field numbering messages of hook from 1, so that gaps show messages lost on the way.
+	// Number is given when message is encoded and kept on retry, replay and spool; with pid it identifies
+	// message of process.
+	Sequence bool
+
+	// ReplaceField, if set, is called for msg, lvl, src, custom and entry fields before encoding,
+	// e.g. to rename or redact them; empty key drops the field.
+	ReplaceField common.ReplaceField
+
+	// RedactKeys are keys of fields never sent as is, e.g. password, authorization, token and cookie: their values
+	// are replaced with common.Redacted or by Redact, e.g. common.KeepLast(4). Keys are case-insensitive and match
+	// the whole key or its last part after ".", custom, entry and context fields are redacted after ReplaceField.
+	RedactKeys []string
+	Redact     func(key, value string) string
+
+	// LevelMapper, if set, returns LogDoc level name of message instead of LogDocLevel.
+	LevelMapper func(level logrus.Level) string
+
+	// MessageFormatter, if set, returns msg of entry, e.g. with request ID, from msg being text of message without
+	// custom fields after "@@". MessagePrefix, e.g. "[billing-worker] ", is then added in front of msg. Entry
+	// itself is not changed, so that Fallback, retries and replay of kept messages get msg formatted once.
+	MessageFormatter func(entry *logrus.Entry, msg string) string
+	MessagePrefix    string
+
+	// KubernetesApp sends name of Deployment or StatefulSet of pod in HOSTNAME in app field if neither App
+	// nor LOGDOC_APP is set, see common.DefaultApp.
+	KubernetesApp bool
+
+	// Filter, if set, is called with every entry passing level, before sampling and encoding; entry is dropped if it
+	// returns false and counted in Filtered. See FilterByMessageSubstring, FilterByAttr, Not, And and Or. Filter
+	// should not change Event, entry passes it if Filter panics.
+	Filter func(e *Event) bool
+	// Hooks are called in order with every entry passing level, sampling, deduplication and rate limit, in the
+	// logging goroutine before the entry is queued. They may change Event; hook returning false drops the entry,
+	// such entries are counted in Vetoed. Panicking hook is skipped. They should be fast and safe for concurrent use.
+	Hooks []func(e *Event) (keep bool)
+
+	// Sampling keeps the given share (0..1) of messages of level, levels not in map are sent all.
+	// Entries with SampleKey field are kept or dropped together, by hash of its value.
+	Sampling map[logrus.Level]float64
+
+	// Duplicate suppression. If DedupWindow is set, message equal to the previous one (by level and text,
+	// and fields if DedupFields is set) within the window is not sent; instead one message with
+	// repeat_count field is sent when window closes or different message comes.
+	DedupWindow time.Duration
+	DedupFields bool
+
+	// Rate limit settings. If RateLimit is set, at most RateLimit messages per second are sent,
+	// with bursts of RateBurst messages; the rest are suppressed and counted in RateLimited.
+	RateLimit         float64
+	RateBurst         int
+	ErrorRateLimit    float64       // Separate limit for error and more severe messages, negative – not limited.
+	RateLimitSummary  bool          // Send "N messages suppressed" warning for every RateLimitInterval with suppressed messages.
+	RateLimitInterval time.Duration // 1s by default.
+
+	// Heartbeat settings. If HeartbeatInterval is set, connection idle for that long gets heartbeat message
+	// (HeartbeatMessage with HeartbeatLevel, "heartbeat" and DebugLevel if not set), so that it isn't dropped
+	// by load balancer and dead server is detected before the next message.
+	HeartbeatInterval time.Duration
+	HeartbeatLevel    logrus.Level
+	HeartbeatMessage  string
+
+	// Batching of async messages for TCP and unix socket: up to BatchSize messages are written at once,
+	// at least every BatchInterval (50ms by default). FlushOnLevel (ErrorLevel if not set) and more severe
+	// messages are written immediately with the batch; with FlushOnLevelSync they are written by the logging
+	// goroutine itself, bypassing async buffer, so they are delivered even if process crashes right after.
+	BatchSize        int
+	BatchInterval    time.Duration
+	FlushOnLevel     logrus.Level
+	FlushOnLevelSync bool
+
+	// Routes send messages of some levels with other hooks instead of this one, see Route. Levels of routes
+	// are added to Levels. By default all messages are sent by this hook.
+	Routes []Route
+
+	// Compression compresses batches of at least CompressionThreshold bytes (1KB by default) into blocks
+	// started with Protocol.CompressedHeader, for server which accepts them.
+	Compression          common.Compression
+	CompressionThreshold int
+
+	// Disk spool settings. If SpoolDir is set, messages that can't be sent or don't fit async buffer
+	// are written to files there and sent in order once connection is back, even after restart.
+	SpoolDir      string
+	SpoolMaxBytes int64 // Total size of spool files, 64MB by default, the oldest file is deleted when full.
+
+	// Socket settings.
+	Dialer          *net.Dialer   // Base dialer, e.g. with LocalAddr or Control set.
+	KeepAlive       time.Duration // TCP keepalive period, 30s by default, negative – disabled.
+	DisableNoDelay  bool          // Enable Nagle's algorithm (TCP_NODELAY is set by default).
+	WriteBufferSize int           // Socket send buffer size, OS default if 0.
+	LocalAddr       string        // Source IP of connections, e.g. 10.0.0.5 or ::1.
+	SourceIP        string        // Value of ip field, e.g. for NAT, local address of connection by default.
+	Hostname        string        // Value of host field, e.g. for proxy, os.Hostname() by default.
+
+	// DialFunc, if set, is used instead of dialing address, e.g. to write through own SSH tunnel.
+	// It is called for the first connection and every reconnect, context is limited by DialTimeout.
+	DialFunc func(ctx context.Context) (net.Conn, error)
+
+	// ReadResponses starts goroutine per TCP or unix socket connection reading status lines LogDoc server
+	// writes back, see ParseResponse: rejected message is reported as RejectedError to OnError and Errors,
+	// and to OnDeadLetter if its event_id (seq if EventID is not set) is among the last 1024 messages.
+	// Connection closed by server is re-dialed at once instead of on the next write, so MaxIdle check is
+	// not needed. Other data is read and ignored, so it is safe with servers which never respond.
+	ReadResponses bool
+	ParseResponse func(line string) (rejected bool, ref, reason string) // ParseResponse by default.
+
+	// Proxy settings: socks5://[user:password@]host:port or http://host:port (CONNECT).
+	ProxyURL             *url.URL
+	ProxyFromEnvironment bool // Use ALL_PROXY or HTTPS_PROXY if ProxyURL is not set.
+
+	// DNS settings. Host name is resolved again on every reconnect.
+	Resolver    func(ctx context.Context, host string) ([]string, error) // Custom lookup, net.DefaultResolver if nil.
+	DNSCacheTTL time.Duration                                            // Reuse resolved addresses for that long.
+
+	// TLS settings. If any of them is set, connection to LogDoc server is established over TLS.
+	TLSConfig         *tls.Config      // Base TLS config.
+	ClientCertificate *tls.Certificate // Client certificate for mutual TLS.
+	ClientCertFile    string           // Client certificate file for mutual TLS, used with ClientKeyFile.
+	ClientKeyFile     string           // Client key file for mutual TLS.
+	ReloadClientCert  bool             // Reload ClientCertFile and ClientKeyFile from disk on every reconnect.
+
+	// HTTP settings, used when address is http:// or https:// URL.
+	HTTPClient  *http.Client // Client for posting messages, http.DefaultClient if nil.
+	HTTPGzip    bool         // Compress request bodies with gzip.
+	BasicAuth   *url.Userinfo
+	BearerToken string
+
+	// Failover settings. Hook connects the primary address first and switches to the next one
+	// after FailoverThreshold consecutive failures, retrying the primary after FailoverCooldown.
+	FailoverAddresses []string
+	FailoverThreshold int
+	FailoverCooldown  time.Duration
+
+	// Circuit breaker. After BreakerThreshold consecutive failed sends messages are not written for
+	// BreakerCooldown (5s by default) and go straight to spool, replay buffer or dead letters,
+	// then the next message probes connection and closes the breaker on success.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// OnReconnect is called from the reconnect goroutine after every reconnect attempt.
+	OnReconnect func(attempt int, err error)
+	// OnFailover is called when hook switches to another LogDoc endpoint.
+	OnFailover func(address string)
+	// OnBreakerState is called from the sending goroutine when circuit breaker changes state.
+	OnBreakerState func(state BreakerState)
+	// OnError is called from the sending goroutine with delivery errors not returned by Fire, e.g. in async mode.
+	// If it is set, these errors are not logged. See also Errors.
+	OnError func(err error)
+	// OnDropped is called with number of dropped messages, from the logging goroutine too, so it should be fast.
+	OnDropped func(count int)
+	// OnDeadLetter is called for every message hook gave up on: dropped without connection or on overflow,
+	// failed after retries or oversized. Payload is encoded LogDoc frame, entry is nil for messages
+	// dropped from replay buffer or spool. It is called from a separate goroutine, panics are recovered.
+	OnDeadLetter func(payload []byte, entry *logrus.Entry, err error)
+	// Fallback receives entries hook could not queue or deliver, e.g. hook writing to stderr,
+	// and a warning with number of such entries once LogDoc accepts messages again.
+	Fallback logrus.Hook
+
+	certMu     sync.Mutex
+	clientCert *tls.Certificate
+	cond       *sync.Cond
+	done       chan struct{}
+	closed     bool
+	levelSet   bool   // Level is set by WithLevel, DSN or environment, so PanicLevel is not the default.
+	hostname   string // Resolved by NewLazyHook, host field is not sent if it failed.
+	static     staticFields
+	next       atomic.Uint64
+	seq        atomic.Uint64
+	reconnects atomic.Uint64
+	dropped    atomic.Uint64
+	oversized  atomic.Uint64
+	retries    atomic.Uint64
+	overflowed atomic.Uint64
+	rejected   atomic.Uint64
+	recent     recentEvents
+
+	dns          dnsCache
+	endpoints    []*endpoint
+	active       int
+	failedOverAt time.Time
+
+	closing  atomic.Bool
+	inflight atomic.Int64 // Fire calls in progress.
+	pending  atomic.Int64 // Messages queued in async mode and not sent yet.
+	ring     replayRing
+
+	flushBatch chan struct{} // Asks async workers to write collected batch now.
+
+	limiter      *tokenBucket
+	errorLimiter *tokenBucket
+	limiterOnce  sync.Once
+	rateLimited  atomic.Uint64
+	suppressed   atomic.Int64 // Suppressed since the last summary.
+	sampledOut   atomic.Uint64
+	dedup        dedup
+	breaker      breaker
+	startup      startupBuffer
+	startupDone  atomic.Bool
+	budget       retryBudget
+	givenUp      atomic.Uint64
+
+	errors          chan error
+	errorsOnce      sync.Once
+	deadLetters     chan deadLetter
+	deadLettersOnce sync.Once
+	diverted        atomic.Int64 // Entries sent to Fallback since LogDoc failed.
+	fallbackLogger  atomic.Pointer[logrus.Logger]
+
+	fieldsDropped  atomic.Uint64
+	fieldsFiltered atomic.Uint64
+	filtered       atomic.Uint64
+	vetoed         atomic.Uint64
+	valuesCut      atomic.Uint64
+
+	redactor     *common.Redactor
+	redactorOnce sync.Once
+
+	defaultApp       string
+	defaultAppSource common.AppSource
+	defaultAppOnce   sync.Once
+
+	spool spool
 }
 
 func (h *Hook) Levels() []logrus.Level {
-	return []logrus.Level{
+	if h.LevelVar != nil {
+		// Filtered by fire.
+		return logrus.AllLevels
+	}
+	if h.Level != logrus.PanicLevel || h.levelSet {
+		return h.routedLevels(logrus.AllLevels[:h.Level+1])
+	}
+	return h.routedLevels([]logrus.Level{
 		logrus.PanicLevel,
 		logrus.FatalLevel,
 		logrus.ErrorLevel,
 		logrus.WarnLevel,
 		logrus.InfoLevel,
 		logrus.DebugLevel,
-	}
+	})
 }
 
 // / Fire send message to logdoc.
 // In async mode log message will be dropped if message buffer is full.
-// If you want wait until message buffer frees – set WaitUntilBufferFrees to true.
+// If you want wait until message buffer frees or drop the oldest message instead – set OverflowPolicy.
+// After Close messages are rejected with error.
 func (h *Hook) Fire(entry *logrus.Entry) error {
+	return h.fire(entry, h.App)
+}
+
+// queued is a message waiting in async buffer.
+type queued struct {
+	entry  *logrus.Entry
+	app    string
+	id     string // Event ID, empty if EventID is not set.
+	fields []byte // Encoded fields, nil if message is encoded when it is sent.
+}
+
+// encoded returns encoded fields of message, encoding it if it is not yet.
+func (h *Hook) encoded(msg queued) []byte {
+	if msg.fields != nil {
+		return msg.fields
+	}
+	return h.encodeFields(msg.entry, msg.app, msg.id)
+}
+
+// LogDocLevel returns LogDoc name of logrus level: warning is sent as warn,
+// custom levels more verbose than trace as trace.
+func LogDocLevel(level logrus.Level) string {
+	switch level {
+	case logrus.PanicLevel:
+		return "panic"
+	case logrus.FatalLevel:
+		return "fatal"
+	case logrus.ErrorLevel:
+		return "error"
+	case logrus.WarnLevel:
+		return "warn"
+	case logrus.InfoLevel:
+		return "info"
+	case logrus.DebugLevel:
+		return "debug"
+	default:
+		return "trace"
+	}
+}
+
+// snapshot copies entry kept after Fire returns, so that hooks fired after this one
+// or caller reusing fields map don't race with sending goroutines.
+func snapshot(entry *logrus.Entry) *logrus.Entry {
+	c := *entry
+	c.Data = make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		c.Data[k] = v
+	}
+	c.Buffer = nil
+	return &c
+}
+
+func (h *Hook) fire(entry *logrus.Entry, app string) error {
+	h.inflight.Add(1)
+	defer h.inflight.Add(-1)
+	if h.closing.Load() {
+		return ErrClosed
+	}
+	if !h.enabled(entry.Level) {
+		return nil
+	}
+	if route := h.route(entry.Level); route != nil {
+		return h.fireRoute(route, entry, app)
+	}
+	var event *Event
+	if h.Filter != nil {
+		if event = h.newEvent(entry); !h.filter(event) {
+			return nil
+		}
+	}
+	if h.Sampling != nil && !h.sampled(entry) {
+		return nil
+	}
+	if h.DedupWindow > 0 && h.duplicate(entry, app) {
+		return nil
+	}
+	if h.RateLimit > 0 && !h.allow(entry.Level) {
+		return nil
+	}
+	if entry.Caller != nil && (h.CallerSkip > 0 || len(h.CallerSkipPackages) > 0) {
+		entry = h.withCaller(entry)
+	}
+	if len(h.Hooks) > 0 {
+		if entry = h.runHooks(entry, event); entry == nil {
+			return nil
+		}
+	}
+	if h.StackTrace && entry.Level <= h.stackTraceLevel() {
+		entry = h.withStackTrace(entry)
+	}
+	if h.IncludeGoroutineID {
+		entry = h.withGoroutineID(entry)
+	}
+	return h.submit(entry, app)
+}
+
+// submit queues message in async mode or sends it.
+func (h *Hook) submit(entry *logrus.Entry, app string) error {
+	if h.FlushOnLevelSync && h.fireChannel != nil && entry.Level <= h.flushLevel() {
+		// Written right away, connection write lock keeps it from interleaving with batches.
+		// Workers are asked to write the batch collected so far too.
+		h.requestFlush()
+		return h.sendMessage(queued{entry: entry, app: app, id: h.eventID()})
+	}
+	msg := queued{entry: entry, app: app, id: h.eventID()}
+	if h.StartupBuffer > 0 && !h.startupDone.Load() {
+		var held bool
+		if held, msg.fields = h.startupHold(msg); held {
+			return nil
+		}
+	}
 	if h.fireChannel != nil { // Async mode.
-		select {
-		case h.fireChannel <- entry:
-		default:
-			if h.WaitUntilBufferFrees {
-				h.fireChannel <- entry // Blocks the goroutine because buffer is full.
-				return nil
+		h.pending.Add(1)
+		msg.entry = snapshot(entry)
+		if !h.enqueue(msg) {
+			h.pending.Add(-1)
+			h.overflowed.Add(1)
+			if h.keeping() {
+				return h.keep(h.encoded(msg))
+			}
+			h.drop(1)
+			h.deadLetter(msg, nil, ErrQueueFull)
+			if h.ReturnErrors || h.Sync {
+				return ErrQueueFull
 			}
-			// Drop message by default.
 		}
 		return nil
 	}
-	return h.sendMessage(entry)
+	return h.sendMessage(msg)
+}
+
+// enqueue puts message into async buffer according to OverflowPolicy, false if message is dropped.
+func (h *Hook) enqueue(msg queued) bool {
+	select {
+	case h.fireChannel <- msg:
+		return true
+	default:
+	}
+
+	policy := h.OverflowPolicy
+	if h.WaitUntilBufferFrees {
+		policy = OverflowBlock
+	}
+	switch policy {
+	case OverflowBlock:
+		var timeout <-chan time.Time
+		if h.BlockTimeout > 0 {
+			timer := time.NewTimer(h.BlockTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case h.fireChannel <- msg: // Blocks the goroutine because buffer is full.
+			return true
+		case <-timeout:
+		case <-h.done:
+		}
+		return false
+	case OverflowDropOldest:
+		for {
+			select {
+			case h.fireChannel <- msg:
+				return true
+			default:
+			}
+			// Separate select, otherwise another message may be dropped when there is room already.
+			select {
+			case old := <-h.fireChannel:
+				h.pending.Add(-1)
+				h.overflowed.Add(1)
+				h.drop(1)
+				h.deadLetter(old, nil, ErrQueueFull)
+			default:
+			}
+		}
+	default:
+		return false
+	}
+}
+
+func (h *Hook) sendMessage(msg queued) error {
+	fields := h.encoded(msg)
+	if h.keeping() && h.keptPending() && !h.oversizedEvent(fields) {
+		// Keep order: new messages go after the kept ones, oversized message fails below instead.
+		return h.keep(fields)
+	}
+
+	err := h.sendFields(fields)
+	if err != nil && !permanent(err) && err != ErrCircuitOpen && h.Sync && !h.isHTTP() && !h.closing.Load() {
+		// Caller waits anyway, so dial again right now and retry once.
+		if err = h.Connect(); err == nil {
+			err = h.sendFields(fields)
+		}
+	}
+	if err != nil && !permanent(err) && h.keeping() {
+		return h.keep(fields)
+	}
+	if err != nil {
+		h.deadLetter(msg, fields, err)
+	}
+	if (h.Sync || h.ReturnErrors) && h.fireChannel == nil {
+		return err
+	}
+	if err != nil {
+		h.reportError(err)
+	}
+	if err == ErrNotConnected || err == ErrCircuitOpen {
+		// Reconnect is in progress or circuit breaker is open, message is dropped.
+		h.drop(1)
+	} else if err != nil && h.OnError == nil {
+		logrus.Errorf("Ошибка записи в соединение, %s", err.Error())
+	}
+	return nil
 }
 
-func (h *Hook) sendMessage(entry *logrus.Entry) error {
-	header := []byte{6, 3}
-	app := application
-	var lvl string
-	if strings.Compare(entry.Level.String(), "warning") == 0 {
-		lvl = "warn"
+// sendFields sends encoded message with configured transport, retrying up to MaxSendRetries times.
+func (h *Hook) sendFields(fields []byte) error {
+	if h.oversizedEvent(fields) {
+		h.oversized.Add(1)
+		return ErrOversized
+	}
+	var err error
+	if h.isHTTP() {
+		err = h.post(fields)
 	} else {
-		lvl = entry.Level.String()
+		data := h.frame(fields)
+		err = h.retry(func() error { return h.write(data) })
+	}
+	if err == nil && h.Fallback != nil {
+		h.fallbackRecovered()
+	}
+	return err
+}
+
+// encodeFields encodes message fields, the same for every transport. Event ID is sent if it is not empty.
+func (h *Hook) encodeFields(entry *logrus.Entry, app, id string) []byte {
+	app = h.appName(app)
+	lvl := LogDocLevel(entry.Level)
+	if h.LevelMapper != nil {
+		mapped := lvl
+		if h.protect("LevelMapper", func() { mapped = h.LevelMapper(entry.Level) }) == nil {
+			lvl = mapped
+		}
+	}
+	ip := h.sourceIP()
+	host := h.Hostname
+	if host == "" {
+		host = h.hostname
 	}
-	ip := h.conn.RemoteAddr().String()
 	pid := fmt.Sprintf("%d", os.Getpid())
-	src := entry.Caller.Function + ":" + strconv.Itoa(entry.Caller.Line)
+	var src string
+	if entry.Caller != nil {
+		// Caller is set only if logger reports it, see logrus.SetReportCaller.
+		src = common.SourceTrimmed(entry.Caller.Function, entry.Caller.File, entry.Caller.Line, h.SourceFormat, h.TrimPrefixes)
+	}
 
-	t := time.Now()
-	tsrc := t.Format("060201150405.000") + "\n"
+	tsrc := common.TimestampIn(entry.Time, h.TimeFormat, h.TimeLocation)
 
-	// Пишем заголовок
-	result := header
-	// Записываем само сообщение
-	common.WritePair("msg", entry.Message, &result)
-	// Обрабатываем кастомные поля
-	common.ProcessCustomFields(entry.Message, &result)
+	names := h.FieldNames
+	var fields []common.Field
+	var userAt int
+	// Сообщение и кастомные поля из него
+	for i, f := range common.MessageFields(entry.Message) {
+		if i == 0 {
+			text, body := h.formatMessage(entry, f.Value.(string)), ""
+			if h.SplitMultiline {
+				text, body = common.SplitLines(text, h.MaxBodyLines)
+			}
+			fields = h.appendField(fields, names.Name(f.Key), text)
+			if body != "" {
+				fields = h.appendField(fields, names.Name("body"), body)
+			}
+			userAt = len(fields)
+		} else {
+			fields = h.appendUserField(fields, f.Key, f.Value)
+		}
+	}
+	// Поля записи, добавленные через WithField и WithFields, по порядку ключей
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = h.appendUserField(fields, k, entry.Data[k])
+	}
+	if h.ContextFields != nil && entry.Context != nil {
+		fields = h.appendContextFields(fields, entry.Context)
+	}
+	if h.MaxFields > 0 {
+		user, dropped := common.LimitFields(fields[userAt:], h.MaxFields)
+		fields = append(fields[:userAt], user...)
+		h.fieldsDropped.Add(uint64(dropped))
+	}
+	// Статические поля, в формате KV записываются заранее закодированными
+	static := h.staticFields()
+	staticAt := len(fields)
+	if h.Format == common.FormatJSON {
+		fields = append(fields, static.fields...)
+	}
+	fields = h.appendDynamicFields(fields)
 	// Служебные поля
-	common.WritePair("app", app, &result)
-	common.WritePair("tsrc", tsrc, &result)
-	common.WritePair("lvl", lvl, &result)
-	common.WritePair("ip", ip, &result)
-	common.WritePair("pid", pid, &result)
-	common.WritePair("src", src, &result)
+	fields = append(fields, common.Field{Key: names.Name("app"), Value: common.PairValue(app)})
+	fields = append(fields, common.Field{Key: names.Name("tsrc"), Value: tsrc})
+	fields = h.appendField(fields, names.Name("lvl"), lvl)
+	fields = append(fields, common.Field{Key: names.Name("ip"), Value: ip})
+	if host != "" {
+		fields = append(fields, common.Field{Key: names.Name("host"), Value: host})
+	}
+	fields = append(fields, common.Field{Key: names.Name("pid"), Value: pid})
+	ref := id
+	if h.Sequence {
+		seq := fmt.Sprintf("%d", h.seq.Add(1))
+		fields = append(fields, common.Field{Key: names.Name("seq"), Value: seq})
+		if ref == "" {
+			ref = seq
+		}
+	}
+	if id != "" {
+		fields = append(fields, common.Field{Key: names.Name("event_id"), Value: id})
+	}
+	if src != "" {
+		fields = h.appendField(fields, names.Name("src"), src)
+	}
 
-	// Финальный байт, завершаем
-	result = append(result, []byte("\n")...)
+	if h.Format == common.FormatJSON {
+		return h.remember(ref, h.encodeJSON(fields))
+	}
+	var result []byte
+	for i, f := range fields {
+		if i == staticAt {
+			result = append(result, static.encoded...)
+		}
+		h.writeValue(f.Key, f.Value, &result)
+	}
+	if h.MaxEventBytes > 0 {
+		// Frame that still doesn't fit fails in sendFields.
+		limit := h.MaxEventBytes - h.Protocol.Overhead() - common.ChecksumSize(h.Encoding, h.Checksum)
+		result, _ = names.TruncateWith(h.Encoding, result, limit)
+	}
+	if h.Checksum != common.ChecksumNone {
+		// Fields were just encoded, so they are read back.
+		result, _ = common.AppendChecksum(h.Encoding, result, h.Checksum)
+	}
+	return h.remember(ref, result)
+}
 
-	_, err := h.conn.Write(result)
+// encodeJSON encodes fields as JSON object with Marshaler.
+func (h *Hook) encodeJSON(fields []common.Field) []byte {
+	var result []byte
+	var err error
+	if h.protect("Marshaler", func() { result, err = common.EncodeJSON(fields, h.Marshaler) }) != nil {
+		return common.EncodeJSONStrings(fields)
+	}
 	if err != nil {
-		logrus.Errorf("Ошибка записи в соединение, %s", err.Error())
+		logrus.Warnf("Ошибка кодирования сообщения в JSON, %s", err.Error())
 	}
-	return nil
+	return result
+}
+
+// oversizedEvent reports whether message frame exceeds MaxEventBytes.
+func (h *Hook) oversizedEvent(fields []byte) bool {
+	return h.MaxEventBytes > 0 && len(fields)+h.Protocol.Overhead() > h.MaxEventBytes
+}
+
+// appendField appends field passed through ReplaceField.
+func (h *Hook) appendField(fields []common.Field, key string, value interface{}) []common.Field {
+	if h.ReplaceField != nil {
+		if key, value = h.replaceField(key, value); key == "" {
+			return fields
+		}
+	}
+	return append(fields, common.Field{Key: key, Value: value})
+}
+
+// appendUserField appends custom or entry field passed through ReplaceField and AllowKeys and DenyKeys, renamed
+// or dropped if its key is reserved, see FieldCollision, redacted by RedactKeys and formatted by ValueFormat.
+func (h *Hook) appendUserField(fields []common.Field, key string, value interface{}) []common.Field {
+	if h.ReplaceField != nil {
+		if key, value = h.replaceField(key, value); key == "" {
+			return fields
+		}
+	}
+	if filter := (common.KeyFilter{AllowKeys: h.AllowKeys, DenyKeys: h.DenyKeys}); !filter.Empty() {
+		var keep bool
+		var filtered int
+		value, keep, filtered = filter.Filter(key, value)
+		h.fieldsFiltered.Add(uint64(filtered))
+		if !keep {
+			return fields
+		}
+	}
+	name := h.userKey(key)
+	if name == "" {
+		logrus.Warnf("Поле %s зарезервировано LogDoc и не отправляется", key)
+		return fields
+	}
+	if len(h.RedactKeys) > 0 {
+		value, _ = h.redactorFor().Redact(key, value)
+	}
+	return append(fields, common.Field{Key: name, Value: h.ValueFormat.Apply(value)})
+}
+
+// redactorFor returns Redactor of RedactKeys, made with the first message. Value is Redacted entirely
+// if Redact panics.
+func (h *Hook) redactorFor() *common.Redactor {
+	h.redactorOnce.Do(func() {
+		var redact func(key, value string) string
+		if h.Redact != nil {
+			redact = func(key, value string) string {
+				result := common.Redacted
+				_ = h.protect("Redact", func() { result = h.Redact(key, value) })
+				return result
+			}
+		}
+		h.redactor = common.NewRedactor(h.RedactKeys, redact)
+	})
+	return h.redactor
+}
+
+// appendContextFields appends fields returned by ContextFields, by order of keys.
+func (h *Hook) appendContextFields(fields []common.Field, ctx context.Context) []common.Field {
+	var extra map[string]interface{}
+	if h.protect("ContextFields", func() { extra = h.ContextFields(ctx) }) != nil {
+		return fields
+	}
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = h.appendUserField(fields, k, extra[k])
+	}
+	return fields
+}
+
+// eventID returns new event ID, empty if EventID is not set or panics.
+func (h *Hook) eventID() string {
+	var id string
+	if h.EventID != nil {
+		_ = h.protect("EventID", func() { id = h.EventID() })
+	}
+	return id
 }
 
-func Init(proto string, address string, app string) (net.Conn, error) {
+// formatMessage returns msg passed through MessageFormatter, kept as is if it panics, with MessagePrefix.
+func (h *Hook) formatMessage(entry *logrus.Entry, msg string) string {
+	if h.MessageFormatter != nil {
+		formatted := msg
+		if h.protect("MessageFormatter", func() { formatted = h.MessageFormatter(entry, msg) }) == nil {
+			msg = formatted
+		}
+	}
+	return h.MessagePrefix + msg
+}
+
+// userKey returns key user field is written with, see FieldCollision. With SplitMultiline body is reserved too.
+func (h *Hook) userKey(key string) string {
+	if h.SplitMultiline && key == h.FieldNames.Name("body") {
+		if h.FieldCollision == common.CollisionDrop {
+			return ""
+		}
+		return common.ReservedPrefix + key
+	}
+	return h.FieldNames.UserKey(key, h.FieldCollision)
+}
+
+// replaceField calls ReplaceField, field is kept as is if it panics.
+func (h *Hook) replaceField(key string, value interface{}) (string, interface{}) {
+	newKey, newValue := key, value
+	if h.protect("ReplaceField", func() { newKey, newValue = h.ReplaceField(key, value) }) != nil {
+		return key, value
+	}
+	return newKey, newValue
+}
+
+func (h *Hook) writeValue(key string, value interface{}, result *[]byte) {
+	var s string
+	var err error
+	if h.protect("Marshaler", func() { s, err = h.ValueFormat.Format(value, h.Marshaler) }) != nil {
+		s, err = fmt.Sprintf("%+v", value), nil
+	}
+	if err != nil {
+		logrus.Warnf("Ошибка кодирования поля %s, %s", key, err.Error())
+	}
+	if !h.DisableSanitize {
+		var cut bool
+		key = common.Sanitize(key, 0)
+		if s, cut = common.SanitizeValue(s, h.maxValueSize()); cut {
+			h.valuesCut.Add(1)
+		}
+	}
+	common.Encoding(h.Encoding).WriteField(key, s, result)
+}
+
+func (h *Hook) maxValueSize() int {
+	if h.MaxValueSize == 0 {
+		return common.DefaultMaxValueSize
+	}
+	return h.MaxValueSize
+}
+
+// FieldsDropped returns how many fields were dropped by MaxFields.
+func (h *Hook) FieldsDropped() uint64 {
+	return h.fieldsDropped.Load()
+}
+
+// FieldsFiltered returns how many fields were dropped by AllowKeys and DenyKeys.
+func (h *Hook) FieldsFiltered() uint64 {
+	return h.fieldsFiltered.Load()
+}
+
+// ValuesCut returns how many field values were cut to MaxValueSize.
+func (h *Hook) ValuesCut() uint64 {
+	return h.valuesCut.Load()
+}
+
+// appName returns value of app field: app, application name given to Init or default one, see AppSource.
+func (h *Hook) appName(app string) string {
+	if app == "" {
+		app = application
+	}
+	if app == "" {
+		app, _ = h.defaultAppName()
+		return app
+	}
+	return common.SanitizeApp(app)
+}
+
+// defaultAppName returns application name of hook without App, resolved once.
+func (h *Hook) defaultAppName() (string, common.AppSource) {
+	h.defaultAppOnce.Do(func() { h.defaultApp, h.defaultAppSource = common.DefaultApp(h.KubernetesApp) })
+	return h.defaultApp, h.defaultAppSource
+}
+
+// AppSource returns application name sent in app field and where it comes from: App or application name given to
+// Init, sanitized by common.SanitizeApp, or default one – LOGDOC_APP, Kubernetes workload with KubernetesApp
+// or base name of executable, see common.DefaultApp. It can be logged at startup.
+func (h *Hook) AppSource() (string, common.AppSource) {
+	app := h.App
+	if app == "" {
+		app = application
+	}
+	if app == "" {
+		return h.defaultAppName()
+	}
+	return common.SanitizeApp(app), common.AppExplicit
+}
+
+// frame wraps encoded fields into LogDoc Native Protocol frame.
+func (h *Hook) frame(fields []byte) []byte {
+	return h.Protocol.Frame(fields)
+}
+
+// Init creates logger with LogDoc hook. Application starts even if LogDoc server is unreachable:
+// error of the initial dial is returned, and hook keeps reconnecting in background.
+// Returned hook owns the connection and should be closed on application shutdown.
+func Init(proto string, address string, app string) (*Hook, error) {
 	l := logrus.New()
 	l.SetReportCaller(true)
 	l.Formatter = &logrus.JSONFormatter{
@@ -128,42 +1016,92 @@ func Init(proto string, address string, app string) (net.Conn, error) {
 	application = app
 	lgr = l
 
-	hook, conn, err := NewHook(proto, address)
-	if err != nil {
-		l = logrus.StandardLogger()
-		l.SetLevel(logrus.DebugLevel)
-		lgr = l
-		lgr.Error(err)
-		return nil, err
-	}
-
+	hook := NewLazyHook(proto, address)
 	l.AddHook(hook)
-	return conn, nil
+
+	if err := hook.Connect(); err != nil {
+		l.Error("Error connecting LogDoc server, ", address, "; error:", err)
+		return hook, err
+	}
+	return hook, nil
 }
 
-func NewHook(protocol, address string) (*Hook, net.Conn, error) {
-	conn, err := net.Dial(protocol, address)
-	if err != nil {
+// NewHook creates hook and connects LogDoc server, failing if address is invalid or server is unreachable.
+func NewHook(protocol, address string) (*Hook, error) {
+	hook := NewLazyHook(protocol, address)
+	if err := hook.Validate(); err != nil {
+		return nil, err
+	}
+	if err := hook.Connect(); err != nil {
+		_ = hook.Close()
 		logrus.Error("Error connecting LogDoc server, ", address, "; error:", err)
-		return nil, nil, err
+		return nil, err
 	}
+	return hook, nil
+}
 
-	hook := &Hook{conn: conn, protocol: protocol, address: address}
-
-	return hook, conn, nil
+// NewLazyHook creates hook without connecting LogDoc server.
+// Address may be given in URL form, e.g. udp://host:port or unix:///path/to.sock, then its scheme overrides protocol.
+// In UDP mode every message is sent as a single datagram and no reconnect is done.
+// Connection is established on the first message (or by Connect) and re-established
+// automatically in background, so application can start even if LogDoc server is unreachable.
+// Messages produced while there is no connection are dropped, see Dropped.
+// In async mode (see MakeAsync) they are queued in the buffer until connection is back.
+func NewLazyHook(protocol, address string) *Hook {
+	protocol, address = splitAddress(protocol, address)
+	hook := &Hook{protocol: protocol, address: address, done: make(chan struct{})}
+	hook.hostname, _ = os.Hostname()
+	hook.cond = sync.NewCond(&hook.RWMutex)
+	return hook
 }
 
-func (h *Hook) makeAsync() {
-	if h.AsyncBufferSize == 0 {
+// MakeAsync switches hook to async mode. AsyncBufferSize and AsyncWorkers should be set before the call.
+// Messages are queued in bounded buffer and sent by AsyncWorkers long-lived goroutines,
+// Fire never starts a goroutine per message.
+func (h *Hook) MakeAsync() {
+	if h.fireChannel != nil {
+		return
+	}
+	if h.AsyncBufferSize <= 0 {
 		h.AsyncBufferSize = defaultAsyncBufferSize
 	}
-	h.fireChannel = make(chan *logrus.Entry, h.AsyncBufferSize)
+	h.fireChannel = make(chan queued, h.AsyncBufferSize)
+	h.flushBatch = make(chan struct{}, 1)
 
-	go func() {
-		for entry := range h.fireChannel {
-			if err := h.sendMessage(entry); err != nil {
-				fmt.Println("Error during sending message to logdoc:", err)
+	workers := h.AsyncWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go h.sendQueued()
+	}
+}
+
+// sendQueued sends messages from async buffer until hook is closed.
+func (h *Hook) sendQueued() {
+	if h.batching() {
+		h.sendBatches()
+		return
+	}
+	for {
+		select {
+		case msg := <-h.fireChannel:
+			if err := h.protect("sendMessage", func() { h.sendQueuedMessage(msg) }); err != nil {
+				h.deadLetter(msg, nil, err)
 			}
+			h.pending.Add(-1)
+		case <-h.done:
+			return
 		}
-	}()
+	}
+}
+
+func (h *Hook) sendQueuedMessage(msg queued) {
+	if !h.keeping() && !h.waitConnected() {
+		h.drop(1)
+		h.deadLetter(msg, nil, ErrNotConnected)
+		h.reportError(ErrNotConnected)
+	} else if err := h.sendMessage(msg); err != nil {
+		fmt.Println("Error during sending message to logdoc:", err)
+	}
 }
@@ -0,0 +1,916 @@
+package logrusld
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LogDoc-org/logdoc-go-appender/common"
+	"github.com/sirupsen/logrus"
+)
+
+func testEntry(msg string) *logrus.Entry {
+	return &logrus.Entry{
+		Message: msg,
+		Level:   logrus.InfoLevel,
+		Time:    time.Date(2023, 4, 5, 6, 7, 8, 9e6, time.UTC),
+		Caller:  &runtime.Frame{Function: "main.main", File: "/app/main.go", Line: 42},
+	}
+}
+
+func TestEncodeData(t *testing.T) {
+	hook := NewLazyHook("tcp", "127.0.0.1:1")
+	entry := testEntry("handled")
+	entry.Data = logrus.Fields{"request_id": "42", "status": 200, logrus.ErrorKey: errors.New("timeout")}
+	fields := string(hook.encodeFields(entry, "app", ""))
+	if !strings.Contains(fields, "error=timeout\nrequest_id=42\nstatus=200\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+}
+
+func TestLogDocLevel(t *testing.T) {
+	for _, tc := range []struct {
+		level logrus.Level
+		want  string
+	}{
+		{logrus.PanicLevel, "panic"},
+		{logrus.FatalLevel, "fatal"},
+		{logrus.ErrorLevel, "error"},
+		{logrus.WarnLevel, "warn"},
+		{logrus.InfoLevel, "info"},
+		{logrus.DebugLevel, "debug"},
+		{logrus.TraceLevel, "trace"},
+		{logrus.TraceLevel + 3, "trace"},
+	} {
+		if got := LogDocLevel(tc.level); got != tc.want {
+			t.Errorf("LogDocLevel(%d) = %q, want %q", tc.level, got, tc.want)
+		}
+	}
+
+	hook := NewLazyHook("tcp", "127.0.0.1:1")
+	hook.LevelMapper = func(level logrus.Level) string { return "custom-" + LogDocLevel(level) }
+	if fields := string(hook.encodeFields(testEntry("mapped"), "app", "")); !strings.Contains(fields, "lvl=custom-info\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+}
+
+func TestEncodeTime(t *testing.T) {
+	hook := NewLazyHook("tcp", "127.0.0.1:1")
+	if fields := string(hook.encodeFields(testEntry("timed"), "app", "")); !strings.Contains(fields, "\ntsrc=230504060708.009\nlvl=") {
+		t.Fatalf("fields = %q", fields)
+	}
+	hook.TimeFormat = "060201150405.000000"
+	if fields := string(hook.encodeFields(testEntry("timed"), "app", "")); !strings.Contains(fields, "\ntsrc=230504060708.009000\nlvl=") {
+		t.Fatalf("fields = %q", fields)
+	}
+
+	// Entry time in local zone is sent in UTC by default.
+	hook.TimeFormat = ""
+	entry := testEntry("timed")
+	entry.Time = entry.Time.In(time.FixedZone("UTC+3", 3*3600))
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.Contains(fields, "\ntsrc=230504060708.009\nlvl=") {
+		t.Fatalf("fields = %q", fields)
+	}
+	hook.TimeLocation = time.FixedZone("UTC-5", -5*3600)
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.Contains(fields, "\ntsrc=230504010708.009\nlvl=") {
+		t.Fatalf("fields = %q", fields)
+	}
+}
+
+func TestMarshaler(t *testing.T) {
+	type credentials struct {
+		User     string
+		Password string
+	}
+	hook := NewLazyHook("tcp", "127.0.0.1:1")
+	hook.Marshaler = func(v interface{}) ([]byte, error) {
+		if c, ok := v.(credentials); ok {
+			return []byte(c.User + ":***"), nil
+		}
+		return json.Marshal(v)
+	}
+	entry := testEntry("login")
+	entry.Data = logrus.Fields{"auth": credentials{"bob", "secret"}}
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.Contains(fields, "auth=bob:***\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+}
+
+func TestReplaceField(t *testing.T) {
+	hook := NewLazyHook("tcp", "127.0.0.1:1")
+	hook.ReplaceField = func(key string, value interface{}) (string, interface{}) {
+		switch key {
+		case "password":
+			return "", nil
+		case "src":
+			return "source", value
+		case "token":
+			return key, "***"
+		}
+		return key, value
+	}
+	entry := testEntry("login@@token=abc")
+	entry.Data = logrus.Fields{"user": "bob", "password": "secret"}
+	fields := string(hook.encodeFields(entry, "app", ""))
+	for _, want := range []string{"msg=login\n", "token=***\n", "user=bob\n", "source=main.main:42\n"} {
+		if !strings.Contains(fields, want) {
+			t.Errorf("%q is not in %q", want, fields)
+		}
+	}
+	if strings.Contains(fields, "password") || strings.Contains(fields, "\nsrc=") {
+		t.Errorf("fields = %q", fields)
+	}
+}
+
+func TestEncodeWithoutCaller(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.MakeAsync()
+	defer hook.Close()
+
+	// Logger doesn't report caller, so entry has none.
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(hook)
+	logger.Info("no caller")
+	select {
+	case f := <-frames:
+		if _, ok := f["src"]; ok || f["msg"] != "no caller" {
+			t.Fatalf("frame = %v", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+}
+
+func TestEncodeSourceLine(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.SourceFormat = common.SourceFuncFile
+	hook.MakeAsync()
+	defer hook.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.SetReportCaller(true)
+	logger.AddHook(hook)
+	_, file, line, _ := runtime.Caller(0)
+	logger.Info("caller")
+	want := fmt.Sprintf("/logrus.TestEncodeSourceLine %s:%d", file, line+1)
+	select {
+	case f := <-frames:
+		if !strings.HasSuffix(f["src"], want) {
+			t.Fatalf("src = %q, want %q", f["src"], want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+}
+
+func TestEncodeSourceFormats(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	entry := testEntry("caller")
+	entry.Caller = &runtime.Frame{Function: "github.com/acme/billing/handlers.(*User).Get", File: "/build/billing/handlers/user.go", Line: 42}
+	tests := []struct {
+		format       common.SourceFormat
+		trimPrefixes []string
+		want         string
+	}{
+		{common.SourceShortFunc, nil, "handlers.(*User).Get:42"},
+		{common.SourceRelFile, nil, "handlers/user.go:42"},
+		{common.SourceFuncFile, []string{"github.com/acme/billing/", "/build/billing/"}, "handlers.(*User).Get handlers/user.go:42"},
+	}
+	for _, tc := range tests {
+		hook.SourceFormat, hook.TrimPrefixes = tc.format, tc.trimPrefixes
+		if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasSuffix(fields, "\nsrc="+tc.want+"\n") {
+			t.Errorf("fields = %q, want src=%s", fields, tc.want)
+		}
+	}
+}
+
+func TestApp(t *testing.T) {
+	saved := application
+	defer func() { application = saved }()
+	application = ""
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	if err := hook.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if fields := string(hook.encodeFields(testEntry("default"), hook.App, "")); !strings.Contains(fields, "\napp="+filepath.Base(os.Args[0])+"\n") {
+		t.Fatalf("fields = %q, want executable name", fields)
+	}
+
+	application = "initialized"
+	if fields := string(hook.encodeFields(testEntry("init"), hook.App, "")); !strings.Contains(fields, "\napp=initialized\n") {
+		t.Fatalf("fields = %q, want app of Init", fields)
+	}
+	hook.App = "billing"
+	entry := testEntry("option")
+	entry.Data = logrus.Fields{"app": "other"}
+	fields := string(hook.encodeFields(entry, hook.App, ""))
+	if !strings.Contains(fields, "\napp=billing\n") || !strings.Contains(fields, "\nattr_app=other\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+}
+
+func TestHostname(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	if name, err := os.Hostname(); err == nil {
+		if fields := string(hook.encodeFields(testEntry("host"), "app", "")); !strings.Contains(fields, "\nhost="+name+"\n") {
+			t.Errorf("fields = %q, want host %q", fields, name)
+		}
+	}
+
+	hook.Hostname = "edge-proxy"
+	if fields := string(hook.encodeFields(testEntry("host"), "app", "")); !strings.Contains(fields, "\nhost=edge-proxy\n") {
+		t.Errorf("fields = %q, want Hostname", fields)
+	}
+
+	// Hostname could not be resolved.
+	hook.Hostname, hook.hostname = "", ""
+	if fields := string(hook.encodeFields(testEntry("host"), "app", "")); strings.Contains(fields, "host=") {
+		t.Errorf("fields = %q, want no host", fields)
+	}
+}
+
+func TestReservedFields(t *testing.T) {
+	for _, key := range common.ReservedFields {
+		hook := NewLazyHook("tcp", "logdoc:5656")
+		hook.Sequence = true
+		hook.Checksum = common.ChecksumCRC32
+		entry := testEntry("reserved@@" + key + "=custom")
+		entry.Data = logrus.Fields{key: "other"}
+		fields := "\n" + string(hook.encodeFields(entry, "app", "0190163d-8694-739b-aea5-966c26f8ad91"))
+		if n := strings.Count(fields, "\n"+key+"="); n != 1 {
+			t.Errorf("%s: %d pairs in %q", key, n, fields)
+		}
+		if !strings.Contains(fields, "\nattr_"+key+"=other\n") || !strings.Contains(fields, "\nattr_"+key+"=custom\n") {
+			t.Errorf("%s: no renamed fields in %q", key, fields)
+		}
+
+		hook.FieldCollision = common.CollisionDrop
+		fields = "\n" + string(hook.encodeFields(entry, "app", "0190163d-8694-739b-aea5-966c26f8ad91"))
+		if n := strings.Count(fields, "\n"+key+"="); n != 1 || strings.Contains(fields, "attr_") {
+			t.Errorf("%s: fields = %q", key, fields)
+		}
+	}
+}
+
+func TestFieldNames(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.FieldNames = common.FieldNames{Message: "message", Level: "level", Source: "source", Time: "time"}
+	entry := testEntry("renamed")
+	entry.Data = logrus.Fields{"level": "custom"}
+	fields, err := common.ReadFields(hook.encodeFields(entry, "app", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	for _, f := range fields {
+		got[f.Key] = f.Value
+	}
+	for _, key := range []string{"msg", "lvl", "src", "tsrc"} {
+		if _, ok := got[key]; ok {
+			t.Errorf("old key %s in %v", key, got)
+		}
+	}
+	if got["message"] != "renamed" || got["level"] != "info" || got["source"] != "main.main:42" || got["attr_level"] != "custom" || got["time"] == nil {
+		t.Errorf("fields = %v", got)
+	}
+
+	hook.MaxEventBytes = 200
+	entry.Message = strings.Repeat("x", 200)
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "message=xxx") || !strings.Contains(fields, "truncated=true") {
+		t.Errorf("fields = %q, want message truncated", fields)
+	}
+
+	hook.MaxEventBytes = 0
+	hook.Format = common.FormatJSON
+	var object map[string]interface{}
+	if err := json.Unmarshal(hook.encodeFields(testEntry("renamed"), "app", ""), &object); err != nil || object["message"] != "renamed" || object["level"] != "info" || object["msg"] != nil {
+		t.Errorf("JSON = %v, %v", object, err)
+	}
+
+	hook.FieldNames = common.FieldNames{Level: "msg"}
+	if err := hook.Validate(); err == nil || !strings.Contains(err.Error(), "FieldNames") {
+		t.Errorf("Validate = %v, want FieldNames error", err)
+	}
+}
+
+func TestMultilineMessage(t *testing.T) {
+	stack := "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:42 +0x1d\n"
+	decode := func(hook *Hook, entry *logrus.Entry) map[string]interface{} {
+		t.Helper()
+		fields, err := common.ReadFields(hook.encodeFields(entry, "app", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := map[string]interface{}{}
+		for _, f := range fields {
+			values[f.Key] = f.Value
+		}
+		return values
+	}
+
+	// Line breaks are kept in msg and error values.
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	entry := testEntry("request failed\nSELECT *\nFROM users")
+	entry.Data = logrus.Fields{logrus.ErrorKey: errors.New(stack), "body": "payload"}
+	if got := decode(hook, entry); got["msg"] != "request failed\nSELECT *\nFROM users" || got["error"] != stack || got["body"] != "payload" {
+		t.Fatalf("fields = %q", got)
+	}
+
+	hook.SplitMultiline = true
+	hook.MaxBodyLines = 1
+	if got := decode(hook, entry); got["msg"] != "request failed" || got["body"] != "SELECT *\n... 1 more lines" || got["attr_body"] != "payload" || got["error"] != stack {
+		t.Fatalf("fields = %q", got)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	frames, errs := make(chan map[string]string, 10), make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readFrames(conn, frames, errs)
+	}()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.Checksum = common.ChecksumSHA256
+	hook.MaxEventBytes = 300
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	entry := testEntry(strings.Repeat("long ", 100))
+	entry.Data = logrus.Fields{"status": 200, "stack": "line 1\nline 2"}
+	if err := hook.Fire(entry); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case f := <-frames:
+		if f["truncated"] != "true" || !strings.HasPrefix(f["checksum"], "sha256:") {
+			t.Fatalf("frame = %q", f)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+	if hook.Oversized() != 0 {
+		t.Fatalf("oversized = %d", hook.Oversized())
+	}
+
+	hook.Format = common.FormatJSON
+	if err := hook.Validate(); err == nil || !strings.Contains(err.Error(), "Checksum") {
+		t.Fatalf("Validate = %v, want Checksum error", err)
+	}
+}
+
+func BenchmarkEncodeChecksum(b *testing.B) {
+	entry := testEntry("request handled")
+	entry.Data = logrus.Fields{"status": 200, "path": "/api/v1/users", "duration": time.Millisecond}
+	checksums := []struct {
+		name     string
+		checksum common.Checksum
+	}{{"none", common.ChecksumNone}, {"crc32", common.ChecksumCRC32}, {"sha256", common.ChecksumSHA256}}
+	for _, c := range checksums {
+		hook := NewLazyHook("tcp", "logdoc:5656")
+		hook.Checksum = c.checksum
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				hook.encodeFields(entry, "app", "")
+			}
+		})
+	}
+}
+
+func TestStaticFields(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.StaticFields = map[string]string{"region": "eu-1", "env": "prod"}
+	cohort := "a"
+	hook.StaticFieldsFunc = func() map[string]string { return map[string]string{"cohort": cohort, "env": "dev", "app": "x"} }
+	entry := testEntry("static")
+	entry.Data = logrus.Fields{"status": 200}
+	fields := string(hook.encodeFields(entry, "app", ""))
+	if !strings.HasPrefix(fields, "msg=static\nstatus=200\nenv=prod\nregion=eu-1\nattr_app=x\ncohort=a\napp=app\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+
+	cohort = "b"
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.Contains(fields, "\ncohort=b\n") || strings.Count(fields, "env=") != 1 {
+		t.Fatalf("fields = %q", fields)
+	}
+
+	hook.Format = common.FormatJSON
+	var object map[string]interface{}
+	if err := json.Unmarshal(hook.encodeFields(entry, "app", ""), &object); err != nil || object["env"] != "prod" || object["cohort"] != "b" {
+		t.Fatalf("JSON = %v, %v", object, err)
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	defer func(read func() (*debug.BuildInfo, bool)) { readBuildInfo = read }(readBuildInfo)
+	readBuildInfo = func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{
+			GoVersion: "go1.20.4",
+			Main:      debug.Module{Path: "example.com/billing", Version: "v1.4.2"},
+			Settings:  []debug.BuildSetting{{Key: "vcs.revision", Value: "4b825dc642cb6eb9a060e54bf8d69288fbee4904"}},
+		}, true
+	}
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.IncludeBuildInfo = true
+	hook.BuildInfoKeys.Version = "version"
+	hook.StaticFields = map[string]string{"go.version": "custom"}
+	if fields := string(hook.encodeFields(testEntry("build"), "app", "")); !strings.HasPrefix(fields, "msg=build\ngo.version=custom\nvcs.revision=4b825dc642cb\nversion=v1.4.2\napp=app\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+
+	readBuildInfo = func() (*debug.BuildInfo, bool) { return nil, false }
+	hook = NewLazyHook("tcp", "logdoc:5656")
+	hook.IncludeBuildInfo = true
+	if fields := string(hook.encodeFields(testEntry("build"), "app", "")); !strings.HasPrefix(fields, "msg=build\napp=app\n") {
+		t.Fatalf("fields without build info = %q", fields)
+	}
+}
+
+func TestKubernetesFields(t *testing.T) {
+	t.Setenv("POD_NAME", "billing-7d9f8b6c5-x2kqz")
+	t.Setenv("POD_NAMESPACE", "payments")
+	t.Setenv("NODE_NAME", "node-1")
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.IncludeKubernetes = true
+	hook.StaticFields = map[string]string{common.KubernetesNodeKey: "node-7"}
+	fields := string(hook.encodeFields(testEntry("pod"), "app", ""))
+	for _, want := range []string{"\nk8s.namespace=payments\n", "\nk8s.node=node-7\n", "\nk8s.pod=billing-7d9f8b6c5-x2kqz\n"} {
+		if !strings.Contains(fields, want) {
+			t.Errorf("%q is not in %q", want, fields)
+		}
+	}
+}
+
+func TestMaxFields(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.MaxFields = 3
+	hook.MaxValueSize = 20
+	entry := testEntry("many @@first=1")
+	entry.Data = logrus.Fields{}
+	for i := 0; i < 400; i++ {
+		entry.Data[fmt.Sprintf("key%03d", i)] = strings.Repeat("v", 30)
+	}
+	cut := strings.Repeat("v", 20) + "..."
+	fields := string(hook.encodeFields(entry, "app", ""))
+	if !strings.HasPrefix(fields, "msg=many \nfirst=1\nkey000="+cut+"\nkey001="+cut+"\nfields_truncated=398\napp=app\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+	if hook.FieldsDropped() != 398 || hook.ValuesCut() != 2 {
+		t.Fatalf("FieldsDropped() = %d, ValuesCut() = %d", hook.FieldsDropped(), hook.ValuesCut())
+	}
+
+	hook.MaxEventBytes = 200
+	fields = string(hook.encodeFields(entry, "app", ""))
+	if len(fields)+hook.Protocol.Overhead() > hook.MaxEventBytes || !strings.Contains(fields, "\nfields_truncated=398\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+}
+
+func TestKeyFilter(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.DenyKeys = []string{"internal.*", "debug"}
+	hook.MaxFields = 3
+	entry := testEntry("filtered@@internal.trace=1")
+	entry.Data = logrus.Fields{"internal.dump": "big", "debug": "x", "debug.heap": "y", "debugger": "gdb", "status": 200}
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "msg=filtered\ndebugger=gdb\nstatus=200\napp=app\n") {
+		t.Fatalf("deny fields = %q", fields)
+	}
+	if n := hook.FieldsFiltered(); n != 4 {
+		t.Fatalf("FieldsFiltered() = %d", n)
+	}
+
+	hook.AllowKeys = []string{"http", "user.*", "status"}
+	hook.RedactKeys = []string{"password"}
+	entry.Data = logrus.Fields{"http.method": "GET", "user.name": "bob", "user.password": "secret", "status": 200,
+		"internal.status": 1, "host": "a", "user": "bob"}
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "msg=filtered\nhttp.method=GET\nstatus=200\nuser.name=bob\nfields_truncated=1\napp=app\n") {
+		t.Fatalf("allow fields = %q", fields)
+	}
+	if n := hook.FieldsFiltered(); n != 8 {
+		t.Fatalf("FieldsFiltered() = %d", n)
+	}
+	hook.MaxFields = 0
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.Contains(fields, "\nuser.password=[REDACTED]\n") {
+		t.Fatalf("redacted fields = %q", fields)
+	}
+}
+
+func TestContextFields(t *testing.T) {
+	type key struct{}
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.ContextFields = func(ctx context.Context) map[string]interface{} {
+		if id, ok := ctx.Value(key{}).(string); ok {
+			return map[string]interface{}{"trace_id": id, "span_id": "b7ad6b7169203331", "app": "ctx"}
+		}
+		return nil
+	}
+	entry := testEntry("traced")
+	entry.Data = logrus.Fields{"status": 200}
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "msg=traced\nstatus=200\napp=app\n") {
+		t.Fatalf("fields without context = %q", fields)
+	}
+	entry.Context = context.WithValue(context.Background(), key{}, "0af7651916cd43dd8448eb211c80319c")
+	fields := string(hook.encodeFields(entry, "app", ""))
+	if !strings.HasPrefix(fields, "msg=traced\nstatus=200\nattr_app=ctx\nspan_id=b7ad6b7169203331\ntrace_id=0af7651916cd43dd8448eb211c80319c\napp=app\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+
+	hook.ContextFields = func(ctx context.Context) map[string]interface{} { panic("broken extractor") }
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "msg=traced\nstatus=200\napp=app\n") {
+		t.Fatalf("fields after panic = %q", fields)
+	}
+}
+
+func TestRedactKeys(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	written := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			written <- nil
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		written <- data
+	}()
+
+	type key struct{}
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.RedactKeys = []string{"password", "Authorization", "token", "cookie", "card"}
+	hook.ContextFields = func(ctx context.Context) map[string]interface{} {
+		return map[string]interface{}{"cookie": ctx.Value(key{})}
+	}
+	hook.Redact = func(key, value string) string {
+		if key == "card" {
+			return common.KeepLast(4)(key, value)
+		}
+		return common.Redacted
+	}
+	entry := testEntry("login@@token=raw-token-1@user=bob")
+	entry.Data = logrus.Fields{"PASSWORD": "raw-password-2", "authorization": []byte("raw-auth-3"), "card": "4242424242424242"}
+	entry.Context = context.WithValue(context.Background(), key{}, "raw-cookie-4")
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Fire(entry); err != nil {
+		t.Fatal(err)
+	}
+	hook.Format = common.FormatJSON
+	if err := hook.Fire(entry); err != nil {
+		t.Fatal(err)
+	}
+	hook.Close()
+
+	data := string(<-written)
+	for _, raw := range []string{"raw-token-1", "raw-password-2", "raw-auth-3", "raw-cookie-4", "42424242"} {
+		if strings.Contains(data, raw) {
+			t.Fatalf("%s is written: %q", raw, data)
+		}
+	}
+	for _, redacted := range []string{"token=[REDACTED]\n", "PASSWORD=[REDACTED]\n", "cookie=[REDACTED]\n", "card=************4242\n", "user=bob\n", `"authorization":"[REDACTED]"`} {
+		if !strings.Contains(data, redacted) {
+			t.Fatalf("%s is not written: %q", redacted, data)
+		}
+	}
+}
+
+func TestRedactPanic(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.RedactKeys = []string{"password"}
+	hook.Redact = func(key, value string) string { panic("broken redaction") }
+	entry := testEntry("login")
+	entry.Data = logrus.Fields{"password": "secret"}
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "msg=login\npassword=[REDACTED]\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+}
+
+func TestValueFormat(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.ValueFormat = common.ValueFormat{Duration: common.DurationSeconds, TimeLayout: time.Kitchen, FloatPrecision: 2}
+	entry := testEntry("formatted")
+	entry.Data = logrus.Fields{"latency": 3 * time.Millisecond, "at": entry.Time, "ratio": 2.0 / 3}
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "msg=formatted\nat=6:07AM\nlatency=0.00\nratio=0.67\napp=app\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+	hook.Format = common.FormatJSON
+	hook.ValueFormat = common.ValueFormat{Duration: common.DurationMillis, TimeLayout: time.RFC3339}
+	var object map[string]interface{}
+	if err := json.Unmarshal(hook.encodeFields(entry, "app", ""), &object); err != nil {
+		t.Fatal(err)
+	}
+	if object["latency"] != 3.0 || object["at"] != "2023-04-05T06:07:08Z" || object["ratio"] != 2.0/3 {
+		t.Fatalf("object = %v", object)
+	}
+}
+
+func TestEncodeNestedValues(t *testing.T) {
+	type item struct {
+		ID   int
+		Tags []string
+	}
+	type node struct {
+		Name string
+		Next *node
+	}
+	loop := &node{Name: "loop"}
+	loop.Next = loop
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	entry := testEntry("nested")
+	entry.Data = logrus.Fields{"items": []item{{1, []string{"a", "b"}}}, "loop": loop}
+	fields := string(hook.encodeFields(entry, "app", ""))
+	for _, want := range []string{`items=[{"ID":1,"Tags":["a","b"]}]` + "\n", "loop=[*logrusld.node]\n"} {
+		if !strings.Contains(fields, want) {
+			t.Errorf("%q is not in %q", want, fields)
+		}
+	}
+
+	hook.ValueFormat.MaxDepth = 2
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.Contains(fields, "items=[[]logrusld.item]\n") {
+		t.Errorf("fields = %q", fields)
+	}
+}
+
+func TestMessageFormatter(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.MessagePrefix = "[billing-worker] "
+	entry := testEntry("payment failed@@order=42")
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "msg=[billing-worker] payment failed\norder=42\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+	hook.MessageFormatter = func(entry *logrus.Entry, msg string) string {
+		return strings.ToUpper(entry.Level.String()) + ": " + msg
+	}
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "msg=[billing-worker] INFO: payment failed\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+	hook.MessageFormatter = func(*logrus.Entry, string) string { panic("formatter") }
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.HasPrefix(fields, "msg=[billing-worker] payment failed\n") {
+		t.Fatalf("fields after panic = %q", fields)
+	}
+	if entry.Message != "payment failed@@order=42" {
+		t.Fatalf("entry message changed to %q", entry.Message)
+	}
+}
+
+func TestAppSource(t *testing.T) {
+	t.Setenv("LOGDOC_APP", "")
+	t.Setenv("HOSTNAME", "billing-7d9f8b6c5-x2kqz")
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	if app, source := hook.AppSource(); app != filepath.Base(os.Args[0]) || source != common.AppBinary {
+		t.Fatalf("AppSource() = %q, %s", app, source)
+	}
+
+	hook = NewLazyHook("tcp", "logdoc:5656")
+	hook.KubernetesApp = true
+	if app, source := hook.AppSource(); app != "billing" || source != common.AppKubernetes {
+		t.Fatalf("Kubernetes AppSource() = %q, %s", app, source)
+	}
+	if fields := string(hook.encodeFields(testEntry("app"), "", "")); !strings.Contains(fields, "\napp=billing\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+
+	hook.App = "billing\nlvl=fatal"
+	if app, source := hook.AppSource(); app != "billing_lvl_fatal" || source != common.AppExplicit {
+		t.Fatalf("explicit AppSource() = %q, %s", app, source)
+	}
+	if fields := string(hook.encodeFields(testEntry("app"), hook.App, "")); !strings.Contains(fields, "\napp=billing_lvl_fatal\n") {
+		t.Fatalf("fields = %q", fields)
+	}
+}
+
+func TestEncodeSanitized(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.MaxValueSize = 12
+	entry := testEntry("bad \xff\x00 payload")
+	entry.Data = logrus.Fields{"body\x01": "0123456789abcdef"}
+	fields := string(hook.encodeFields(entry, "app", ""))
+	for _, want := range []string{"msg=bad �\\x00 ...\n", "body\\x01=0123456789ab...\n"} {
+		if !strings.Contains(fields, want) {
+			t.Errorf("%q is not in %q", want, fields)
+		}
+	}
+
+	hook.DisableSanitize = true
+	if fields := string(hook.encodeFields(entry, "app", "")); !strings.Contains(fields, "msg=bad \xff\x00 payload\n") {
+		t.Errorf("fields = %q", fields)
+	}
+}
+
+func TestMaxEventBytes(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.MaxEventBytes = 300
+	entry := testEntry(strings.Repeat("m", 1000))
+	entry.Data = logrus.Fields{"dump": strings.Repeat("d", 10000), "user": "bob"}
+	fields := hook.encodeFields(entry, "app", "")
+	if len(hook.frame(fields)) > 300 {
+		t.Fatalf("frame is %d bytes", len(hook.frame(fields)))
+	}
+	got, err := common.ReadFields(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]interface{}{}
+	for _, f := range got {
+		values[f.Key] = f.Value
+	}
+	if values["user"] != "bob" || values["truncated"] != "true" || values["original_size"] == nil || values["src"] != "main.main:42" {
+		t.Fatalf("values = %v", values)
+	}
+
+	// Keys alone don't fit.
+	hook.MaxEventBytes = 50
+	var dead atomic.Int32
+	hook.OnDeadLetter = func(payload []byte, entry *logrus.Entry, err error) {
+		if errors.Is(err, ErrOversized) {
+			dead.Add(1)
+		}
+	}
+	if err := hook.sendMessage(queued{entry: entry, app: "app"}); err != nil {
+		t.Fatal(err)
+	}
+	if hook.Oversized() != 1 {
+		t.Fatalf("Oversized = %d", hook.Oversized())
+	}
+	for i := 0; i < 100 && dead.Load() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dead.Load() != 1 {
+		t.Fatal("oversized message is not dead-lettered")
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if data, err := bufio.NewReader(conn).ReadBytes(0); err == nil {
+					received <- data
+				}
+			}()
+		}
+	}()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.Sync = true
+	hook.Protocol = common.Protocol{Header: []byte{7, 1}, Terminator: []byte{0}}
+	defer hook.Close()
+	if err := hook.Fire(testEntry("revision")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		if !bytes.HasPrefix(data, []byte{7, 1}) || !bytes.Contains(data, []byte("msg=revision\n")) || data[len(data)-1] != 0 {
+			t.Fatalf("frame = %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+}
+
+func TestBinaryEncoding(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []common.Field, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if fields, err := common.ReadFrame(bufio.NewReader(conn), common.Protocol{}, common.BinaryEncoding); err == nil {
+					received <- fields
+				}
+			}()
+		}
+	}()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.Sync = true
+	hook.Encoding = common.BinaryEncoding
+	hook.DisableSanitize = true
+	defer hook.Close()
+	entry := testEntry("binary")
+	entry.Data = logrus.Fields{"payload": "\x00\xff\n=\r"}
+	if err := hook.Fire(entry); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case fields := <-received:
+		values := map[string]interface{}{}
+		for _, f := range fields {
+			values[f.Key] = f.Value
+		}
+		if values["msg"] != "binary" || values["payload"] != "\x00\xff\n=\r" || values["app"] == nil {
+			t.Fatalf("fields = %q", fields)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.Format = common.FormatJSON
+	entry := testEntry("handled@@route=/items")
+	entry.Data = logrus.Fields{
+		"status":        404,
+		"cached":        false,
+		"took":          250 * time.Millisecond,
+		logrus.ErrorKey: errors.New("not found"),
+		"request":       map[string]interface{}{"method": "GET", "size": 12},
+		"app":           "user value",
+	}
+	fields := hook.encodeFields(entry, "json", "")
+	if bytes.ContainsAny(fields, "\n") {
+		t.Fatalf("JSON has line break: %s", fields)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(fields, &got); err != nil {
+		t.Fatalf("%s: %v", fields, err)
+	}
+	want := map[string]interface{}{
+		"msg":      "handled",
+		"route":    "/items",
+		"status":   404.0,
+		"cached":   false,
+		"took":     "250ms",
+		"error":    "not found",
+		"request":  map[string]interface{}{"method": "GET", "size": 12.0},
+		"attr_app": "user value",
+		"app":      "json",
+		"tsrc":     "230504060708.009",
+		"lvl":      "info",
+		"src":      "main.main:42",
+	}
+	for k, v := range want {
+		if g, _ := json.Marshal(got[k]); string(g) != mustMarshal(t, v) {
+			t.Errorf("%s = %s, want %s", k, g, mustMarshal(t, v))
+		}
+	}
+	if got["ip"] == nil || got["pid"] == nil {
+		t.Errorf("JSON = %s", fields)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
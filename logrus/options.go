@@ -0,0 +1,178 @@
+package logrusld
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Option configures hook created by New or Shared. Options are applied in order, so the later one wins.
+// Fields without With function are set by any func(*Hook), e.g. func(h *Hook) { h.Sequence = true }.
+type Option = func(*Hook)
+
+// New creates hook for address, e.g. "logdoc.host:5656" or "udp://logdoc.host:5656", configured by opts:
+//
+//	hook, err := logrusld.New("logdoc.host:5656", logrusld.WithApp("billing"), logrusld.WithLevel(logrus.InfoLevel),
+//		logrusld.WithTLS(cfg), logrusld.WithQueue(10000))
+//
+// Invalid settings and their combinations are reported by Validate. Like NewLazyHook, hook connects
+// on the first message or Connect, so application starts even if LogDoc server is unreachable.
+func New(address string, opts ...Option) (*Hook, error) {
+	return newHook("tcp", address, opts)
+}
+
+// newHook creates hook configured by opts and validated, async if opts set AsyncBufferSize.
+func newHook(protocol, address string, opts []Option) (*Hook, error) {
+	hook := NewLazyHook(protocol, address)
+	for _, opt := range opts {
+		opt(hook)
+	}
+	if err := hook.Validate(); err != nil {
+		return nil, err
+	}
+	if hook.AsyncBufferSize > 0 {
+		hook.MakeAsync()
+	}
+	return hook, nil
+}
+
+// WithApp sets name of application sent in app field.
+func WithApp(app string) Option {
+	return func(h *Hook) { h.App = app }
+}
+
+// WithLevel sets the most verbose level sent to LogDoc.
+func WithLevel(level logrus.Level) Option {
+	return func(h *Hook) { h.Level, h.levelSet = level, true }
+}
+
+// WithLevelVar makes level of hook changeable at runtime by v or SetLevel.
+func WithLevelVar(v *LevelVar) Option {
+	return func(h *Hook) { h.LevelVar = v }
+}
+
+// WithTLS connects LogDoc server over TLS with cfg, nil means default config.
+func WithTLS(cfg *tls.Config) Option {
+	return func(h *Hook) {
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		h.TLSConfig = cfg
+	}
+}
+
+// WithQueue switches hook to async mode with buffer of size messages.
+func WithQueue(size int) Option {
+	return func(h *Hook) { h.AsyncBufferSize = size }
+}
+
+// WithBlock makes logging wait for free space in async buffer instead of dropping messages.
+func WithBlock() Option {
+	return func(h *Hook) { h.WaitUntilBufferFrees = true }
+}
+
+// WithWorkers sets number of goroutines sending async messages.
+func WithWorkers(workers int) Option {
+	return func(h *Hook) { h.AsyncWorkers = workers }
+}
+
+// WithBatch writes async messages in batches of up to size messages, at least every interval.
+func WithBatch(size int, interval time.Duration) Option {
+	return func(h *Hook) { h.BatchSize, h.BatchInterval = size, interval }
+}
+
+// WithTimeout sets write timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(h *Hook) { h.Timeout = timeout }
+}
+
+// WithDialTimeout sets timeout of dial, including proxy and TLS handshakes.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(h *Hook) { h.DialTimeout = timeout }
+}
+
+// WithPool sets number of connections to LogDoc server.
+func WithPool(size int) Option {
+	return func(h *Hook) { h.PoolSize = size }
+}
+
+// WithFailover sets secondary addresses used when the primary one fails.
+func WithFailover(addresses ...string) Option {
+	return func(h *Hook) { h.FailoverAddresses = addresses }
+}
+
+// WithRetries sets number of resends of failed message.
+func WithRetries(retries int) Option {
+	return func(h *Hook) { h.MaxSendRetries = retries }
+}
+
+// WithSpool keeps messages that can't be sent in files of dir.
+func WithSpool(dir string) Option {
+	return func(h *Hook) { h.SpoolDir = dir }
+}
+
+// WithStaticFields adds fields to every message.
+func WithStaticFields(fields map[string]string) Option {
+	return func(h *Hook) { h.StaticFields = fields }
+}
+
+// WithOnError sets callback for delivery errors not returned by Fire.
+func WithOnError(fn func(err error)) Option {
+	return func(h *Hook) { h.OnError = fn }
+}
+
+// WithFallback sets hook receiving entries which could not be delivered to LogDoc.
+func WithFallback(fallback logrus.Hook) Option {
+	return func(h *Hook) { h.Fallback = fallback }
+}
+
+// WithRedactKeys sends values of fields with keys, e.g. password and token, as common.Redacted.
+func WithRedactKeys(keys ...string) Option {
+	return func(h *Hook) { h.RedactKeys = keys }
+}
+
+// WithAllowKeys sends only fields with keys matching patterns, see common.KeyFilter.
+func WithAllowKeys(patterns ...string) Option {
+	return func(h *Hook) { h.AllowKeys = patterns }
+}
+
+// WithDenyKeys drops fields with keys matching patterns, e.g. internal.*, see common.KeyFilter.
+func WithDenyKeys(patterns ...string) Option {
+	return func(h *Hook) { h.DenyKeys = patterns }
+}
+
+// WithStackTrace adds stacktrace field to messages of level and more severe.
+func WithStackTrace(level logrus.Level) Option {
+	return func(h *Hook) { h.StackTrace, h.StackTraceLevel = true, level }
+}
+
+// WithMessagePrefix adds prefix, e.g. "[billing-worker] ", in front of every msg.
+func WithMessagePrefix(prefix string) Option {
+	return func(h *Hook) { h.MessagePrefix = prefix }
+}
+
+// WithFilter drops entries filter returns false for, e.g. Not(FilterByMessageSubstring("GET /healthz")).
+func WithFilter(filter func(e *Event) bool) Option {
+	return func(h *Hook) { h.Filter = filter }
+}
+
+// WithKubernetesApp sends name of Deployment or StatefulSet of the pod in app field if app is not set otherwise.
+func WithKubernetesApp() Option {
+	return func(h *Hook) { h.KubernetesApp = true }
+}
+
+// WithBuildInfo adds VCS revision, Go version and module version of the binary to static fields.
+func WithBuildInfo() Option {
+	return func(h *Hook) { h.IncludeBuildInfo = true }
+}
+
+// WithKubernetes adds pod, namespace, node and container ID of Kubernetes pod to static fields.
+func WithKubernetes() Option {
+	return func(h *Hook) { h.IncludeKubernetes = true }
+}
+
+// WithGoroutineID adds gid field with ID of the logging goroutine.
+func WithGoroutineID() Option {
+	return func(h *Hook) { h.IncludeGoroutineID = true }
+}
@@ -0,0 +1,75 @@
+package logrusld
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNew(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+
+	hook, err := New(ln.Addr().String(),
+		WithApp("billing"),
+		WithLevel(logrus.InfoLevel),
+		WithQueue(100),
+		WithTimeout(time.Second),
+		func(h *Hook) { h.Sequence = true },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+	if hook.protocol != "tcp" || hook.fireChannel == nil || cap(hook.fireChannel) != 100 || hook.Timeout != time.Second {
+		t.Fatalf("protocol=%s queue=%d timeout=%s", hook.protocol, cap(hook.fireChannel), hook.Timeout)
+	}
+	if levels := hook.Levels(); levels[len(levels)-1] != logrus.InfoLevel {
+		t.Fatalf("levels = %v", levels)
+	}
+
+	l := logrus.New()
+	l.AddHook(hook)
+	l.Info("options")
+	select {
+	case f := <-frames:
+		if f["app"] != "billing" || f["seq"] != "1" {
+			t.Fatalf("frame = %v", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+}
+
+func TestNewOptionsOrder(t *testing.T) {
+	hook, err := New("udp://logdoc:5656", WithPool(4), WithPool(2), WithFailover("b:1", "c:2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+	if hook.protocol != "udp" || hook.PoolSize != 2 || len(hook.FailoverAddresses) != 2 || hook.fireChannel != nil {
+		t.Fatalf("protocol=%s pool=%d failover=%v", hook.protocol, hook.PoolSize, hook.FailoverAddresses)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := map[string][]Option{
+		"negative queue": {WithQueue(-1)},
+		"tls over udp":   {WithTLS(nil), func(h *Hook) { h.protocol = "udp" }},
+		"level":          {WithLevel(42)},
+	}
+	for name, opts := range tests {
+		if hook, err := New("logdoc:5656", opts...); err == nil || hook != nil {
+			t.Errorf("%s: hook=%v err=%v", name, hook, err)
+		}
+	}
+	if _, err := New("", WithApp("x")); err == nil || !strings.Contains(err.Error(), "address is empty") {
+		t.Errorf("empty address: %v", err)
+	}
+	if hook, err := New("logdoc:5656", WithTLS(&tls.Config{ServerName: "logdoc"})); err != nil || hook.TLSConfig.ServerName != "logdoc" {
+		t.Errorf("TLS: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package logrusld
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// proxyURL returns proxy to dial LogDoc server through, nil for direct connection.
+func (h *Hook) proxyURL() (*url.URL, error) {
+	if h.ProxyURL != nil {
+		return h.ProxyURL, nil
+	}
+	if !h.ProxyFromEnvironment {
+		return nil, nil
+	}
+	for _, name := range []string{"ALL_PROXY", "all_proxy", "HTTPS_PROXY", "https_proxy"} {
+		if value := os.Getenv(name); value != "" {
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+// dialRaw connects address directly or through configured proxy.
+func (h *Hook) dialRaw(ctx context.Context, dialer *net.Dialer, address string) (net.Conn, error) {
+	proxy, err := h.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil || !isStream(h.protocol) {
+		return h.dialResolved(ctx, dialer, address)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting proxy %s: %w", proxy.Host, err)
+	}
+	// Handshake with proxy is limited by dial timeout too.
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	switch proxy.Scheme {
+	case "socks5", "socks5h":
+		err = socks5Connect(conn, proxy.User, address)
+	case "http", "https":
+		err = httpConnect(conn, proxy.User, address)
+	default:
+		err = fmt.Errorf("unsupported proxy scheme %q", proxy.Scheme)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxy.Host, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// dialResolved resolves address and dials resolved addresses in order until one answers.
+func (h *Hook) dialResolved(ctx context.Context, dialer *net.Dialer, address string) (net.Conn, error) {
+	addrs, err := h.resolve(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, h.protocol, addr); err == nil {
+			return conn, nil
+		}
+	}
+	if err == nil {
+		err = &net.DNSError{Err: "no addresses", Name: address, IsNotFound: true}
+	}
+	// Pinned addresses don't answer, resolve again on the next dial.
+	h.forgetDNS()
+	return nil, err
+}
+
+func isStream(protocol string) bool {
+	return protocol == "tcp" || protocol == "tcp4" || protocol == "tcp6"
+}
+
+// socks5Connect performs SOCKS5 CONNECT handshake (RFC 1928), with username/password auth (RFC 1929) if user is set.
+func socks5Connect(conn net.Conn, user *url.Userinfo, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	method := byte(0x00) // No authentication.
+	if user != nil {
+		method = 0x02 // Username/password.
+	}
+	if _, err := conn.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != method {
+		return errors.New("SOCKS5 authentication method rejected")
+	}
+
+	if user != nil {
+		password, _ := user.Password()
+		req := []byte{1, byte(len(user.Username()))}
+		req = append(req, user.Username()...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(req, 1)
+		req = append(req, ip.To4()...)
+	} else if ip != nil {
+		req = append(req, 4)
+		req = append(req, ip.To16()...)
+	} else {
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("SOCKS5 connect failed with code %d", head[1])
+	}
+	// Skip bound address.
+	var skip int
+	switch head[3] {
+	case 1:
+		skip = net.IPv4len
+	case 4:
+		skip = net.IPv6len
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// httpConnect opens tunnel through HTTP proxy with CONNECT method.
+func httpConnect(conn net.Conn, user *url.Userinfo, address string) error {
+	req := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+	if user != nil {
+		password, _ := user.Password()
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)) + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP CONNECT failed: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package logrusld
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startSOCKS5 runs minimal SOCKS5 server supporting username/password auth and CONNECT.
+func startSOCKS5(t *testing.T, user, password string) (string, *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	var tunnels atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 262)
+				// Greeting: version, methods count, methods.
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte{5, 2})
+				// Username/password auth.
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+					return
+				}
+				u := make([]byte, buf[1])
+				_, _ = io.ReadFull(conn, u)
+				_, _ = io.ReadFull(conn, buf[:1])
+				p := make([]byte, buf[0])
+				_, _ = io.ReadFull(conn, p)
+				if string(u) != user || string(p) != password {
+					_, _ = conn.Write([]byte{1, 1})
+					return
+				}
+				_, _ = conn.Write([]byte{1, 0})
+				// Connect request with IPv4 address.
+				if _, err := io.ReadFull(conn, buf[:10]); err != nil || buf[3] != 1 {
+					return
+				}
+				target := net.JoinHostPort(net.IP(buf[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(buf[8:10]))))
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer upstream.Close()
+				tunnels.Add(1)
+				_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go func() { _, _ = io.Copy(upstream, conn) }()
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return ln.Addr().String(), &tunnels
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	srv := startTestServer(t, "127.0.0.1:0")
+	defer srv.stop()
+	proxyAddr, tunnels := startSOCKS5(t, "user", "secret")
+
+	hook := NewLazyHook("tcp", srv.ln.Addr().String())
+	hook.ProxyURL = &url.URL{Scheme: "socks5", Host: proxyAddr, User: url.UserPassword("user", "secret")}
+	hook.ReconnectBaseDelay = 5 * time.Millisecond
+	defer hook.Close()
+
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.write([]byte("via socks\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.waitLine(t, "via socks", time.Second) {
+		t.Fatal("message was not delivered through proxy")
+	}
+
+	// Reconnect goes through proxy too.
+	srv.stop()
+	srv = startTestServer(t, srv.ln.Addr().String())
+	defer srv.stop()
+	delivered := false
+	for i := 0; i < 200 && !delivered; i++ {
+		_ = hook.write([]byte("again\n"))
+		delivered = srv.waitLine(t, "again", 10*time.Millisecond)
+	}
+	if !delivered || tunnels.Load() < 2 {
+		t.Fatalf("delivered=%v tunnels=%d", delivered, tunnels.Load())
+	}
+
+	wrong := NewLazyHook("tcp", srv.ln.Addr().String())
+	wrong.ProxyURL = &url.URL{Scheme: "socks5", Host: proxyAddr, User: url.UserPassword("user", "wrong")}
+	defer wrong.Close()
+	if err := wrong.Connect(); err == nil {
+		t.Fatal("proxy accepted wrong credentials")
+	}
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	srv := startTestServer(t, "127.0.0.1:0")
+	defer srv.stop()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != http.MethodConnect {
+			return
+		}
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			return
+		}
+		defer upstream.Close()
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() { _, _ = io.Copy(upstream, conn) }()
+		_, _ = io.Copy(conn, upstream)
+	}()
+
+	t.Setenv("ALL_PROXY", "http://"+ln.Addr().String())
+	hook := NewLazyHook("tcp", srv.ln.Addr().String())
+	hook.ProxyFromEnvironment = true
+	defer hook.Close()
+
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.write([]byte("via connect\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.waitLine(t, "via connect", time.Second) {
+		t.Fatal("message was not delivered through HTTP proxy")
+	}
+}
@@ -0,0 +1,105 @@
+package logrusld
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultRateLimitInterval = time.Second
+
+// tokenBucket allows rate events per second with bursts of burst events, guarded by its own lock.
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := float64(burst)
+	if b < 1 {
+		b = 1
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b}
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// allow reports whether message of level fits rate limit.
+func (h *Hook) allow(level logrus.Level) bool {
+	h.limiterOnce.Do(func() {
+		h.limiter = newTokenBucket(h.RateLimit, h.RateBurst)
+		if h.ErrorRateLimit > 0 {
+			h.errorLimiter = newTokenBucket(h.ErrorRateLimit, h.RateBurst)
+		}
+		if h.RateLimitSummary {
+			go h.rateLimitSummary()
+		}
+	})
+
+	limiter := h.limiter
+	if level <= logrus.ErrorLevel {
+		if h.ErrorRateLimit < 0 {
+			return true
+		}
+		if h.errorLimiter != nil {
+			limiter = h.errorLimiter
+		}
+	}
+	if limiter.allow(time.Now()) {
+		return true
+	}
+	h.rateLimited.Add(1)
+	h.suppressed.Add(1)
+	return false
+}
+
+// RateLimited returns how many messages were suppressed by rate limit.
+func (h *Hook) RateLimited() uint64 {
+	return h.rateLimited.Load()
+}
+
+// rateLimitSummary sends warning with number of messages suppressed during the last interval.
+func (h *Hook) rateLimitSummary() {
+	interval := h.RateLimitInterval
+	if interval <= 0 {
+		interval = defaultRateLimitInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+		}
+		if n := h.suppressed.Swap(0); n > 0 && !h.closing.Load() {
+			_ = h.submit(&logrus.Entry{
+				Message: fmt.Sprintf("%d messages suppressed in the last %s", n, interval),
+				Level:   logrus.WarnLevel,
+				Time:    time.Now(),
+				Caller:  &runtime.Frame{Function: "logrusld.rateLimitSummary"},
+			}, h.App)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package logrusld
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestTokenBucketRate(t *testing.T) {
+	b := newTokenBucket(100, 10)
+	start := time.Now()
+	allowed := 0
+	// 20000 attempts over 2 seconds.
+	for i := 0; i < 20000; i++ {
+		if b.allow(start.Add(time.Duration(i) * 100 * time.Microsecond)) {
+			allowed++
+		}
+	}
+	if want := 10 + 200; allowed < want-2 || allowed > want+2 {
+		t.Fatalf("allowed %d, want about %d", allowed, want)
+	}
+}
+
+func TestRateLimitSummary(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.RateLimit = 1
+	hook.RateBurst = 2
+	hook.ErrorRateLimit = -1
+	hook.RateLimitSummary = true
+	hook.RateLimitInterval = 50 * time.Millisecond
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		_ = hook.Fire(testEntry("flood"))
+	}
+	entry := testEntry("error bypasses limit")
+	entry.Level = logrus.ErrorLevel
+	_ = hook.Fire(entry)
+	waitMessages(t, frames, []string{"flood", "flood", "error bypasses limit", "8 messages suppressed in the last 50ms"})
+	if hook.RateLimited() != 8 {
+		t.Fatalf("rate limited = %d", hook.RateLimited())
+	}
+}
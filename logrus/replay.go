@@ -0,0 +1,148 @@
+package logrusld
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// replayRing keeps encoded messages in memory while there is no connection, guarded by its own lock.
+type replayRing struct {
+	sync.Mutex
+	msgs      [][]byte // Oldest first.
+	first     uint64   // Sequence number of msgs[0].
+	size      int
+	replaying bool
+}
+
+// keeping reports whether undelivered messages are kept for later instead of being dropped.
+func (h *Hook) keeping() bool {
+	return h.SpoolDir != "" || h.ReplayBuffer > 0
+}
+
+// keep stores undelivered message in spool or replay buffer. Oversized message is never sent, so it is
+// passed to OnDeadLetter instead.
+func (h *Hook) keep(fields []byte) error {
+	if h.oversizedEvent(fields) {
+		h.oversized.Add(1)
+		h.deadLetter(queued{}, fields, ErrOversized)
+		h.reportError(ErrOversized)
+		return nil
+	}
+	if h.SpoolDir != "" {
+		return h.spoolMessage(fields)
+	}
+	h.ringAppend(fields)
+	return nil
+}
+
+func (h *Hook) keepBatch(batch []batched) {
+	for _, b := range batch {
+		_ = h.keep(b.fields)
+	}
+}
+
+// keptPending reports whether there are kept messages not sent yet, then new messages go after them.
+func (h *Hook) keptPending() bool {
+	if h.SpoolDir != "" {
+		return h.spoolPending()
+	}
+	if h.ReplayBuffer <= 0 {
+		return false
+	}
+	h.ring.Lock()
+	defer h.ring.Unlock()
+	return len(h.ring.msgs) > 0
+}
+
+// ringAppend adds message to replay buffer, dropping the oldest ones if it overflows.
+func (h *Hook) ringAppend(fields []byte) {
+	r := &h.ring
+	r.Lock()
+	defer r.Unlock()
+	r.msgs = append(r.msgs, fields)
+	r.size += len(fields)
+	for len(r.msgs) > h.ReplayBuffer || h.ReplayBufferBytes > 0 && r.size > h.ReplayBufferBytes {
+		oldest := r.msgs[0]
+		r.size -= len(oldest)
+		r.msgs[0] = nil
+		r.msgs = r.msgs[1:]
+		r.first++
+		h.drop(1)
+		h.deadLetter(queued{}, oldest, ErrQueueFull)
+	}
+	if !r.replaying && len(r.msgs) > 0 {
+		r.replaying = true
+		go h.replayRing()
+	}
+}
+
+// replayRing sends buffered messages in order, as soon as connection is back.
+func (h *Hook) replayRing() {
+	r := &h.ring
+	for {
+		if !h.waitConnected() {
+			r.Lock()
+			r.replaying = false
+			r.Unlock()
+			return
+		}
+
+		r.Lock()
+		if len(r.msgs) == 0 {
+			r.replaying = false
+			r.Unlock()
+			return
+		}
+		fields, seq := r.msgs[0], r.first
+		r.Unlock()
+
+		err := h.sendFields(fields)
+		if err != nil && !permanent(err) {
+			// Wait for reconnect before the next try.
+			select {
+			case <-time.After(h.spoolRetryDelay()):
+				continue
+			case <-h.done:
+				r.Lock()
+				r.replaying = false
+				r.Unlock()
+				return
+			}
+		}
+
+		if err != nil {
+			// Retry won't help, message is given up on.
+			h.deadLetter(queued{}, fields, err)
+			h.reportError(err)
+		}
+		r.Lock()
+		if r.first == seq {
+			// Not dropped by overflow meanwhile.
+			r.size -= len(r.msgs[0])
+			r.msgs[0] = nil
+			r.msgs = r.msgs[1:]
+			r.first++
+		}
+		r.Unlock()
+	}
+}
+
+// encodeError is failure to encode message before it is sent, e.g. to compress it.
+type encodeError struct {
+	err error
+}
+
+func (e *encodeError) Error() string {
+	return "encode LogDoc message: " + e.err.Error()
+}
+
+func (e *encodeError) Unwrap() error {
+	return e.err
+}
+
+// permanent reports whether sending message fails whatever the connection is, such message is not retried.
+func permanent(err error) bool {
+	var encodeErr *encodeError
+	return err == ErrOversized || errors.As(err, &encodeErr)
+}
@@ -0,0 +1,254 @@
+package logrusld
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LogDoc-org/logdoc-go-appender/common"
+	"github.com/sirupsen/logrus"
+)
+
+func TestReplayBufferAfterReconnect(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	address := ln.Addr().String()
+
+	hook := NewLazyHook("tcp", address)
+	hook.ReplayBuffer = 5
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	hook.MaxReconnectDelay = 20 * time.Millisecond
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = ln.Close()
+	hook.Lock()
+	_ = hook.links[0].conn.Close()
+	hook.Unlock()
+	var sent []string
+	for i := 0; i < 8; i++ {
+		sent = append(sent, fmt.Sprint("buffered ", i))
+		_ = hook.Fire(testEntry(sent[i]))
+	}
+	if hook.Dropped() != 3 {
+		t.Fatalf("dropped = %d", hook.Dropped())
+	}
+
+	ln, frames = serveFrames(t, address)
+	defer ln.Close()
+	waitMessages(t, frames, sent[3:])
+	_ = hook.Fire(testEntry("live"))
+	waitMessages(t, frames, []string{"live"})
+	if hook.keptPending() {
+		t.Fatal("replay buffer is not empty after replay")
+	}
+}
+
+func TestReplayBufferSkipsOversized(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	address := ln.Addr().String()
+	_ = ln.Close()
+
+	dead := make(chan error, 10)
+	hook := NewLazyHook("tcp", address)
+	hook.ReplayBuffer = 5
+	hook.MaxEventBytes = 300
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	hook.MaxReconnectDelay = 20 * time.Millisecond
+	hook.OnDeadLetter = func(payload []byte, entry *logrus.Entry, err error) {
+		dead <- err
+	}
+	hook.OnError = func(error) {}
+	defer hook.Close()
+
+	big := []byte(strings.Repeat("x", 500))
+	_ = hook.Fire(testEntry("before"))
+	_ = hook.keep(big)
+	// Replay gives up on oversized message which got into buffer anyway.
+	hook.ringAppend(big)
+	_ = hook.Fire(testEntry("after"))
+
+	ln, frames = serveFrames(t, address)
+	defer ln.Close()
+	waitMessages(t, frames, []string{"before", "after"})
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-dead:
+			if err != ErrOversized {
+				t.Fatalf("dead letter error = %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("oversized message was not dead-lettered")
+		}
+	}
+	if hook.Oversized() != 2 {
+		t.Fatalf("Oversized = %d", hook.Oversized())
+	}
+}
+
+func TestMessagePrefixReplayedOnce(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	address := ln.Addr().String()
+	_ = ln.Close()
+
+	fallback := recordingHook{entries: make(chan *logrus.Entry, 10)}
+	hook := NewLazyHook("tcp", address)
+	hook.MessagePrefix = "[worker] "
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	hook.MaxReconnectDelay = 20 * time.Millisecond
+	hook.Fallback = fallback
+	defer hook.Close()
+
+	_ = hook.Fire(testEntry("diverted"))
+	select {
+	case e := <-fallback.entries:
+		if e.Message != "diverted" {
+			t.Fatalf("fallback received %q", e.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not sent to fallback")
+	}
+
+	hook.ReplayBuffer = 5
+	_ = hook.Fire(testEntry("kept"))
+	ln, frames = serveFrames(t, address)
+	defer ln.Close()
+	waitMessages(t, frames, []string{"[worker] kept"})
+	_ = hook.Fire(testEntry("live"))
+	waitMessages(t, frames, []string{"[worker] live"})
+}
+
+func TestSequenceAcrossReconnect(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	address := ln.Addr().String()
+
+	hook := NewLazyHook("tcp", address)
+	hook.Sequence = true
+	hook.ReplayBuffer = 10
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	hook.MaxReconnectDelay = 20 * time.Millisecond
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var seqs []string
+	receive := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			select {
+			case f := <-frames:
+				seqs = append(seqs, f["seq"])
+			case <-time.After(2 * time.Second):
+				t.Fatalf("message %d was not delivered", len(seqs))
+			}
+		}
+	}
+	_ = hook.Fire(testEntry("before"))
+	receive(1)
+
+	_ = ln.Close()
+	hook.Lock()
+	_ = hook.links[0].conn.Close()
+	hook.Unlock()
+	for i := 0; i < 3; i++ {
+		_ = hook.Fire(testEntry("buffered"))
+	}
+
+	ln, frames = serveFrames(t, address)
+	defer ln.Close()
+	receive(3)
+	_ = hook.Fire(testEntry("after"))
+	receive(1)
+	for i, seq := range seqs {
+		if seq != fmt.Sprint(i+1) {
+			t.Fatalf("seq = %v", seqs)
+		}
+	}
+
+	hook.Sequence = false
+	if fields := string(hook.encodeFields(testEntry("off"), "", "")); strings.Contains(fields, "seq=") {
+		t.Fatalf("fields = %q", fields)
+	}
+}
+
+func TestEventIDKeptOnRetry(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+
+	var mu sync.Mutex
+	generated := map[string]bool{}
+	var writes atomic.Int32
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.EventID = func() string {
+		id := common.NewEventID()
+		mu.Lock()
+		generated[id] = true
+		mu.Unlock()
+		return id
+	}
+	hook.MaxSendRetries = 10
+	hook.ReconnectBaseDelay = time.Millisecond
+	hook.MaxReconnectDelay = 5 * time.Millisecond
+	hook.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", ln.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return flakyConn{Conn: conn, writes: &writes}, nil
+	}
+	hook.MakeAsync()
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		_ = hook.Fire(testEntry(fmt.Sprint("retried ", i)))
+	}
+	received := map[string]bool{}
+	for len(received) < 10 {
+		select {
+		case f := <-frames:
+			mu.Lock()
+			known := generated[f["event_id"]]
+			mu.Unlock()
+			if !known || received[f["event_id"]] {
+				t.Fatalf("event_id = %q, received = %v", f["event_id"], received)
+			}
+			received[f["event_id"]] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%d messages were not delivered", 10-len(received))
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(generated) != 10 || hook.Retries() == 0 {
+		t.Fatalf("generated %d IDs, retries = %d", len(generated), hook.Retries())
+	}
+}
+
+func TestEventIDInDeadLetter(t *testing.T) {
+	payloads := make(chan []byte, 1)
+	hook := NewLazyHook("tcp", "127.0.0.1:1")
+	hook.ReconnectBaseDelay = time.Hour
+	hook.EventID = func() string { return "0190163d-8694-739b-aea5-966c26f8ad91" }
+	hook.OnDeadLetter = func(payload []byte, entry *logrus.Entry, err error) { payloads <- payload }
+	defer hook.Close()
+
+	_ = hook.Fire(testEntry("undelivered"))
+	select {
+	case payload := <-payloads:
+		if !strings.Contains(string(payload), "\nevent_id=0190163d-8694-739b-aea5-966c26f8ad91\n") {
+			t.Fatalf("payload = %q", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dead letter was not reported")
+	}
+}
@@ -0,0 +1,72 @@
+package logrusld
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsCache pins resolved addresses of LogDoc host for DNSCacheTTL, guarded by its own lock.
+type dnsCache struct {
+	sync.Mutex
+	host    string
+	addrs   []string
+	expires time.Time
+}
+
+// resolve returns addresses to dial for address. Host name is resolved on every dial, so after
+// redeploy behind DNS name (e.g. Kubernetes service) reconnect goes to the new IP.
+// With DNSCacheTTL resolution is reused for that long to avoid lookups on frequent reconnects.
+func (h *Hook) resolve(ctx context.Context, address string) ([]string, error) {
+	if strings.HasPrefix(h.protocol, "unix") {
+		return []string{address}, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil || h.Resolver == nil && h.DNSCacheTTL <= 0 {
+		// Dialer resolves host itself on every dial, nothing is cached.
+		return []string{address}, nil
+	}
+
+	if h.DNSCacheTTL > 0 {
+		h.dns.Lock()
+		if h.dns.host == host && time.Now().Before(h.dns.expires) {
+			addrs := h.dns.addrs
+			h.dns.Unlock()
+			return joinPort(addrs, port), nil
+		}
+		h.dns.Unlock()
+	}
+
+	lookup := h.Resolver
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.DNSCacheTTL > 0 {
+		h.dns.Lock()
+		h.dns.host, h.dns.addrs, h.dns.expires = host, addrs, time.Now().Add(h.DNSCacheTTL)
+		h.dns.Unlock()
+	}
+	return joinPort(addrs, port), nil
+}
+
+// forgetDNS drops pinned resolution, e.g. when the resolved address stopped answering.
+func (h *Hook) forgetDNS() {
+	h.dns.Lock()
+	h.dns.expires = time.Time{}
+	h.dns.Unlock()
+}
+
+func joinPort(hosts []string, port string) []string {
+	addrs := make([]string, len(hosts))
+	for i, host := range hosts {
+		addrs[i] = net.JoinHostPort(host, port)
+	}
+	return addrs
+}
@@ -0,0 +1,87 @@
+package logrusld
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconnectResolvesAgain(t *testing.T) {
+	first := startTestServer(t, "127.0.0.1:0")
+	_, port, _ := net.SplitHostPort(first.ln.Addr().String())
+
+	var mu sync.Mutex
+	ip := "127.0.0.1"
+	var lookups atomic.Int32
+	hook := NewLazyHook("tcp", net.JoinHostPort("logdoc.svc", port))
+	hook.Resolver = func(ctx context.Context, host string) ([]string, error) {
+		lookups.Add(1)
+		if host != "logdoc.svc" {
+			t.Errorf("resolved %q", host)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return []string{ip}, nil
+	}
+	hook.ReconnectBaseDelay = 5 * time.Millisecond
+	hook.MaxReconnectDelay = 10 * time.Millisecond
+	defer hook.Close()
+
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.write([]byte("old pod\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !first.waitLine(t, "old pod", time.Second) {
+		t.Fatal("message was not delivered to the first address")
+	}
+
+	// Redeploy: service name now points to another IP.
+	first.stop()
+	second := startTestServer(t, net.JoinHostPort("127.0.0.2", port))
+	defer second.stop()
+	mu.Lock()
+	ip = "127.0.0.2"
+	mu.Unlock()
+
+	delivered := false
+	for i := 0; i < 200 && !delivered; i++ {
+		_ = hook.write([]byte("new pod\n"))
+		delivered = second.waitLine(t, "new pod", 10*time.Millisecond)
+	}
+	if !delivered {
+		t.Fatal("reconnect did not pick up the new address")
+	}
+	if lookups.Load() < 2 {
+		t.Fatalf("lookups = %d", lookups.Load())
+	}
+}
+
+func TestDNSCacheTTL(t *testing.T) {
+	var lookups atomic.Int32
+	hook := NewLazyHook("tcp", "logdoc.svc:5656")
+	hook.DNSCacheTTL = time.Hour
+	hook.Resolver = func(ctx context.Context, host string) ([]string, error) {
+		lookups.Add(1)
+		return []string{"10.0.0.1"}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		addrs, err := hook.resolve(context.Background(), hook.address)
+		if err != nil || len(addrs) != 1 || addrs[0] != "10.0.0.1:5656" {
+			t.Fatalf("addrs = %v, err = %v", addrs, err)
+		}
+	}
+	if lookups.Load() != 1 {
+		t.Fatalf("lookups = %d, want pinned resolution", lookups.Load())
+	}
+	hook.forgetDNS()
+	_, _ = hook.resolve(context.Background(), hook.address)
+	if lookups.Load() != 2 {
+		t.Fatalf("lookups = %d after forget", lookups.Load())
+	}
+}
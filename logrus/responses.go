@@ -0,0 +1,151 @@
+package logrusld
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	responseLineSize = 4096 // Longer response lines are skipped.
+	recentEventsSize = 1024 // Messages remembered for correlation of rejects.
+)
+
+// RejectedError is reported to OnError, Errors and OnDeadLetter when LogDoc server rejects message, see
+// ReadResponses. Ref is event_id or seq of message as server sent it, empty if server did not tell.
+type RejectedError struct {
+	Ref    string
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	if e.Ref == "" {
+		return fmt.Sprintf("LogDoc server rejected message: %s", e.Reason)
+	}
+	return fmt.Sprintf("LogDoc server rejected message %s: %s", e.Ref, e.Reason)
+}
+
+// Rejected returns how many messages LogDoc server rejected, see ReadResponses.
+func (h *Hook) Rejected() uint64 {
+	return h.rejected.Load()
+}
+
+// recentEvents keeps the last encoded messages by event_id or seq, so that reject is reported with message.
+type recentEvents struct {
+	sync.Mutex
+	fields map[string][]byte
+	refs   []string // Ring of keys, the oldest is replaced.
+	next   int
+}
+
+func (r *recentEvents) add(ref string, fields []byte) {
+	r.Lock()
+	defer r.Unlock()
+	if r.fields == nil {
+		r.fields = make(map[string][]byte, recentEventsSize)
+		r.refs = make([]string, recentEventsSize)
+	}
+	delete(r.fields, r.refs[r.next])
+	r.refs[r.next] = ref
+	r.next = (r.next + 1) % len(r.refs)
+	r.fields[ref] = fields
+}
+
+// take returns message with ref and forgets it, nil if it is not remembered.
+func (r *recentEvents) take(ref string) []byte {
+	r.Lock()
+	defer r.Unlock()
+	fields, ok := r.fields[ref]
+	if ok {
+		delete(r.fields, ref)
+	}
+	return fields
+}
+
+// remember keeps encoded message for correlation of server responses and returns it.
+func (h *Hook) remember(ref string, fields []byte) []byte {
+	if h.ReadResponses && ref != "" {
+		h.recent.add(ref, fields)
+	}
+	return fields
+}
+
+// readsResponses reports whether connections get response reader.
+func (h *Hook) readsResponses() bool {
+	return h.ReadResponses && !h.isDatagram() && !h.isHTTP()
+}
+
+// readResponses reads status lines of LogDoc server until connection is closed. Connection closed by server
+// is dropped at once and re-dialed in background, like one that failed on write.
+func (l *link) readResponses(conn net.Conn) {
+	h := l.hook
+	r := bufio.NewReaderSize(conn, responseLineSize)
+	for {
+		line, err := r.ReadSlice('\n')
+		for err == bufio.ErrBufferFull {
+			// Not a status line, skip it.
+			line = nil
+			_, err = r.ReadSlice('\n')
+		}
+		if err != nil {
+			break
+		}
+		if line != nil {
+			h.response(string(bytes.TrimRight(line, "\r\n")))
+		}
+	}
+
+	_ = conn.Close()
+	h.Lock()
+	if l.conn == conn {
+		l.conn = nil
+		l.startReconnect()
+	}
+	h.Unlock()
+}
+
+// response handles status line of LogDoc server, lines which are not rejects are ignored.
+func (h *Hook) response(line string) {
+	parse := ParseResponse
+	if h.ParseResponse != nil {
+		parse = h.ParseResponse
+	}
+	var rejected bool
+	var ref, reason string
+	if h.protect("ParseResponse", func() { rejected, ref, reason = parse(line) }) != nil || !rejected {
+		return
+	}
+
+	h.rejected.Add(1)
+	err := &RejectedError{Ref: ref, Reason: reason}
+	if ref != "" {
+		if fields := h.recent.take(ref); fields != nil {
+			h.deadLetter(queued{}, fields, err)
+		}
+	}
+	h.reportError(err)
+	if h.OnError == nil {
+		logrus.Errorf("LogDoc сервер отклонил сообщение, %s", err.Error())
+	}
+}
+
+// ParseResponse parses status line of LogDoc server: "OK [ref]" for accepted message and
+// "ERR <ref> <reason>" for rejected one, where ref is event_id or seq of message, "-" if unknown.
+// Status is case-insensitive, REJECTED means ERR, other lines are not rejects.
+func ParseResponse(line string) (rejected bool, ref, reason string) {
+	status, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	switch strings.ToUpper(status) {
+	case "ERR", "REJECTED":
+		ref, reason, _ = strings.Cut(strings.TrimSpace(rest), " ")
+		if ref == "-" {
+			ref = ""
+		}
+		return true, ref, strings.TrimSpace(reason)
+	}
+	return false, "", ""
+}
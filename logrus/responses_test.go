@@ -0,0 +1,192 @@
+package logrusld
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseResponse(t *testing.T) {
+	for _, tc := range []struct {
+		line     string
+		rejected bool
+		ref      string
+		reason   string
+	}{
+		{"OK 1", false, "", ""},
+		{"ok", false, "", ""},
+		{"ERR 7 field too long", true, "7", "field too long"},
+		{"rejected 0190a1b2 bad app\r", true, "0190a1b2", "bad app"},
+		{"ERR - quota exceeded", true, "", "quota exceeded"},
+		{"ERR", true, "", ""},
+		{"garbage", false, "", ""},
+		{"", false, "", ""},
+	} {
+		rejected, ref, reason := ParseResponse(tc.line)
+		if rejected != tc.rejected || ref != tc.ref || reason != tc.reason {
+			t.Errorf("ParseResponse(%q) = %v, %q, %q", tc.line, rejected, ref, reason)
+		}
+	}
+}
+
+func TestResponsesRejected(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	frames := make(chan map[string]string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received := make(chan map[string]string, 10)
+		go readFrames(conn, received, make(chan error, 1))
+		for f := range received {
+			if f["msg"] == "bad" {
+				_, _ = conn.Write([]byte("ERR " + f["seq"] + " too bad\n"))
+			} else {
+				_, _ = conn.Write([]byte("OK " + f["seq"] + "\n"))
+			}
+			frames <- f
+		}
+	}()
+
+	type letter struct {
+		payload string
+		err     error
+	}
+	letters := make(chan letter, 10)
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.ReadResponses = true
+	hook.Sequence = true
+	hook.OnDeadLetter = func(payload []byte, entry *logrus.Entry, err error) {
+		letters <- letter{string(payload), err}
+	}
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"good", "bad", "good"} {
+		if err := hook.Fire(testEntry(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case l := <-letters:
+		var rejected *RejectedError
+		if !errors.As(l.err, &rejected) || rejected.Ref != "2" || rejected.Reason != "too bad" {
+			t.Fatalf("dead letter error = %v", l.err)
+		}
+		if !strings.Contains(l.payload, "msg=bad\n") {
+			t.Fatalf("dead letter payload = %q", l.payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reject was not reported")
+	}
+	select {
+	case err := <-hook.Errors():
+		if _, ok := err.(*RejectedError); !ok {
+			t.Fatalf("error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reject was not reported to Errors")
+	}
+	if n := hook.Rejected(); n != 1 {
+		t.Fatalf("Rejected() = %d", n)
+	}
+	select {
+	case l := <-letters:
+		t.Fatalf("unexpected dead letter %q: %v", l.payload, l.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestResponsesServerClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.ReadResponses = true
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	first := <-accepted
+	_ = first.Close()
+
+	// Reconnect doesn't wait for the next message.
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("server close was not detected")
+	}
+}
+
+func TestResponsesIgnored(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	frames := make(chan map[string]string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Data which is not status lines, more than the reader buffer.
+		_, _ = conn.Write([]byte(strings.Repeat("x", 3*responseLineSize) + "\nhello\n"))
+		readFrames(conn, frames, make(chan error, 1))
+	}()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.ReadResponses = true
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := hook.Fire(testEntry("message")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case f := <-frames:
+			if f["msg"] != "message" {
+				t.Fatalf("frame = %v", f)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+		}
+	}
+	if n, reconnects := hook.Rejected(), hook.Reconnects(); n != 0 || reconnects != 0 {
+		t.Fatalf("Rejected() = %d, Reconnects() = %d", n, reconnects)
+	}
+	if !hook.Connected() {
+		t.Fatal("connection dropped")
+	}
+}
@@ -0,0 +1,97 @@
+package logrusld
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Route sends messages of Levels with another hook, e.g. errors synchronously with retries, info through
+// async batches and debug over UDP best-effort. The route hook has its own transport, buffer and retry
+// settings, and is closed by Close and Shutdown of hook routing to it.
+type Route struct {
+	Levels []logrus.Level
+	Hook   *Hook
+}
+
+// route returns hook for messages of level, nil if they are sent by h itself.
+func (h *Hook) route(level logrus.Level) *Hook {
+	for _, r := range h.Routes {
+		for _, l := range r.Levels {
+			if l == level {
+				return r.Hook
+			}
+		}
+	}
+	return nil
+}
+
+// routedLevels adds levels of Routes to levels, so that logger fires hook for them.
+func (h *Hook) routedLevels(levels []logrus.Level) []logrus.Level {
+	if len(h.Routes) == 0 {
+		return levels
+	}
+	all := append([]logrus.Level(nil), levels...)
+	for _, r := range h.Routes {
+		for _, l := range r.Levels {
+			if !containsLevel(all, l) {
+				all = append(all, l)
+			}
+		}
+	}
+	return all
+}
+
+func containsLevel(levels []logrus.Level, level logrus.Level) bool {
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// fireRoute sends entry with route hook, app of the route is used if app is not set.
+func (h *Hook) fireRoute(route *Hook, entry *logrus.Entry, app string) error {
+	if app == "" {
+		app = route.App
+	}
+	return route.fire(entry, app)
+}
+
+// checkRoutes validates Routes.
+func (h *Hook) checkRoutes() []error {
+	var errs []error
+	for i, r := range h.Routes {
+		switch {
+		case r.Hook == nil:
+			errs = append(errs, fmt.Errorf("LogDoc hook route %d has no Hook", i))
+		case r.Hook == h:
+			errs = append(errs, fmt.Errorf("LogDoc hook route %d routes to hook itself", i))
+		}
+		for _, l := range r.Levels {
+			if other := h.route(l); other != r.Hook {
+				errs = append(errs, fmt.Errorf("LogDoc hook level %s is routed twice", l))
+			}
+		}
+	}
+	return errs
+}
+
+// closeRoutes closes route hooks, with ctx if it is not nil.
+func (h *Hook) closeRoutes(ctx context.Context) error {
+	var errs []error
+	for _, r := range h.Routes {
+		if r.Hook == nil || r.Hook == h {
+			continue
+		}
+		if ctx != nil {
+			errs = append(errs, r.Hook.Shutdown(ctx))
+		} else {
+			errs = append(errs, r.Hook.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
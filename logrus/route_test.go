@@ -0,0 +1,112 @@
+package logrusld
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRoutes(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	errorsHook := NewLazyHook("tcp", ln.Addr().String())
+	errorsHook.Sync = true
+	errorsHook.MaxSendRetries = 2
+	if err := errorsHook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Async hook writing to a server which doesn't read, so its buffer is full.
+	servers := make(chan net.Conn, 1)
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.App = "routed"
+	hook.Level = logrus.InfoLevel
+	hook.CloseTimeout = 10 * time.Millisecond
+	hook.AsyncBufferSize = 1
+	hook.ReturnErrors = true
+	hook.Routes = []Route{{Levels: []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}, Hook: errorsHook}}
+	hook.DialFunc = func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		servers <- server
+		return client, nil
+	}
+	if err := hook.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	hook.MakeAsync()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer (<-servers).Close()
+
+	_ = hook.Fire(testEntry("stuck"))
+	for len(hook.fireChannel) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := hook.Fire(testEntry("queued")); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Fire(testEntry("overflow")); err != ErrQueueFull {
+		t.Fatalf("info fire = %v, want ErrQueueFull", err)
+	}
+
+	entry := testEntry("failure")
+	entry.Level = logrus.ErrorLevel
+	if err := hook.Fire(entry); err != nil {
+		t.Fatalf("error fire = %v", err)
+	}
+	select {
+	case f := <-frames:
+		if f["msg"] != "failure" || f["lvl"] != "error" || f["app"] != "routed" {
+			t.Fatalf("frame = %v", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("error was not sent by route")
+	}
+	if hook.Overflowed() != 1 || errorsHook.PoolStats()[0].Writes != 1 {
+		t.Fatalf("overflowed=%d route writes=%d", hook.Overflowed(), errorsHook.PoolStats()[0].Writes)
+	}
+
+	_ = hook.Close()
+	if err := errorsHook.Fire(testEntry("late")); err != ErrClosed {
+		t.Fatalf("route hook is not closed: %v", err)
+	}
+}
+
+func TestRoutesLevels(t *testing.T) {
+	debug := NewLazyHook("udp", "logdoc:5656")
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.Level = logrus.InfoLevel
+	hook.Routes = []Route{{Levels: []logrus.Level{logrus.DebugLevel, logrus.TraceLevel}, Hook: debug}}
+	levels := hook.Levels()
+	if len(levels) != 7 || levels[len(levels)-1] != logrus.TraceLevel {
+		t.Fatalf("levels = %v", levels)
+	}
+	if hook.route(logrus.InfoLevel) != nil || hook.route(logrus.TraceLevel) != debug {
+		t.Fatal("wrong route")
+	}
+}
+
+func TestRoutesValidate(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	other := NewLazyHook("udp", "logdoc:5656")
+	hook.Routes = []Route{
+		{Levels: []logrus.Level{logrus.ErrorLevel}},
+		{Levels: []logrus.Level{logrus.DebugLevel}, Hook: hook},
+		{Levels: []logrus.Level{logrus.WarnLevel}, Hook: other},
+		{Levels: []logrus.Level{logrus.WarnLevel}, Hook: NewLazyHook("tcp", "b:1")},
+	}
+	err := hook.Validate()
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{"route 0 has no Hook", "route 1 routes to hook itself", "level warning is routed twice"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	}
+}
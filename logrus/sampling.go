@@ -0,0 +1,41 @@
+package logrusld
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SampleKey is entry field making sampling decision deterministic: entries with the same key value
+// are all kept or all dropped.
+const SampleKey = "sample_key"
+
+// sampled reports whether entry is kept by Sampling.
+func (h *Hook) sampled(entry *logrus.Entry) bool {
+	rate, ok := h.Sampling[entry.Level]
+	if !ok || rate >= 1 {
+		return true
+	}
+
+	var x float64
+	if key, ok := entry.Data[SampleKey]; ok {
+		hash := fnv.New64a()
+		_, _ = fmt.Fprint(hash, key)
+		x = float64(hash.Sum64()) / math.MaxUint64
+	} else {
+		x = rand.Float64()
+	}
+	if x < rate {
+		return true
+	}
+	h.sampledOut.Add(1)
+	return false
+}
+
+// Sampled returns how many messages were dropped by Sampling.
+func (h *Hook) Sampled() uint64 {
+	return h.sampledOut.Load()
+}
@@ -0,0 +1,48 @@
+package logrusld
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSampling(t *testing.T) {
+	hook := NewLazyHook("tcp", "127.0.0.1:1")
+	hook.Sampling = map[logrus.Level]float64{logrus.DebugLevel: 0.1}
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		entry := testEntry("debug")
+		entry.Level = logrus.DebugLevel
+		if hook.sampled(entry) {
+			kept++
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Fatalf("kept %d of 10000 debug messages", kept)
+	}
+	if hook.Sampled() != uint64(10000-kept) {
+		t.Fatalf("sampled = %d", hook.Sampled())
+	}
+	if !hook.sampled(testEntry("info is not sampled")) {
+		t.Fatal("info message dropped")
+	}
+
+	// The same key gives the same decision.
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("request-", i)
+		var decisions []bool
+		for j := 0; j < 5; j++ {
+			entry := testEntry("keyed")
+			entry.Level = logrus.DebugLevel
+			entry.Data = logrus.Fields{SampleKey: key}
+			decisions = append(decisions, hook.sampled(entry))
+		}
+		for _, d := range decisions {
+			if d != decisions[0] {
+				t.Fatalf("decisions for %s differ: %v", key, decisions)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package logrusld
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SharedHook sends messages of another application over connections of base hook,
+// so several loggers stay within one connection to LogDoc server.
+// Every message is written with a single write, frames of different loggers are never interleaved.
+type SharedHook struct {
+	hook    *Hook
+	app     string
+	release sync.Once
+	key     string // Registry key, empty if hook is not from registry.
+}
+
+// registry holds hooks returned by Shared, one per LogDoc endpoint.
+var registry = struct {
+	sync.Mutex
+	hooks map[string]*registered
+}{hooks: map[string]*registered{}}
+
+type registered struct {
+	hook  *Hook // Nil until ready is closed, and if hook could not be created.
+	refs  int
+	ready chan struct{}
+	err   error // Error of creating hook.
+}
+
+// Share returns hook for logger of application app writing to h connections.
+// Connections are owned by h, closing SharedHook does nothing.
+func (h *Hook) Share(app string) *SharedHook {
+	return &SharedHook{hook: h, app: app}
+}
+
+// Shared returns hook for application app backed by the only hook per LogDoc endpoint in process.
+// The endpoint hook is created on first call, configured by opts like New, including validation and async
+// mode, and connected; like Init, connection error is returned, and hook keeps reconnecting in background.
+// Later calls for the same endpoint ignore opts and don't wait for connection, but return error of creating
+// hook. Connection is closed when the last hook for endpoint is closed.
+func Shared(protocol, address, app string, opts ...Option) (*SharedHook, error) {
+	scheme, rest := splitAddress(protocol, address)
+	key := scheme + "://" + rest
+
+	registry.Lock()
+	if r, ok := registry.hooks[key]; ok {
+		r.refs++
+		registry.Unlock()
+		<-r.ready
+		if r.err != nil {
+			return nil, r.err
+		}
+		return &SharedHook{hook: r.hook, app: app, key: key}, nil
+	}
+	// Other calls for endpoint wait for it, calls for other endpoints don't.
+	r := &registered{refs: 1, ready: make(chan struct{})}
+	registry.hooks[key] = r
+	registry.Unlock()
+
+	hook, err := newHook(protocol, address, opts)
+	registry.Lock()
+	if err != nil {
+		r.err = err
+		if registry.hooks[key] == r {
+			delete(registry.hooks, key)
+		}
+	}
+	r.hook = hook
+	registry.Unlock()
+	close(r.ready)
+	if err != nil {
+		return nil, err
+	}
+	return &SharedHook{hook: hook, app: app, key: key}, hook.Connect()
+}
+
+// ResetShared closes all hooks created by Shared and empties registry, e.g. between tests.
+func ResetShared() {
+	registry.Lock()
+	hooks := registry.hooks
+	registry.hooks = map[string]*registered{}
+	registry.Unlock()
+	for _, r := range hooks {
+		<-r.ready
+		if r.hook != nil {
+			_ = r.hook.Close()
+		}
+	}
+}
+
+func (s *SharedHook) Levels() []logrus.Level {
+	return s.hook.Levels()
+}
+
+func (s *SharedHook) Fire(entry *logrus.Entry) error {
+	return s.hook.fire(entry, s.app)
+}
+
+// Close releases hook from registry, closing endpoint connection if it was the last one.
+// Repeated calls are no-op.
+func (s *SharedHook) Close() error {
+	if s.key == "" {
+		return nil
+	}
+	var err error
+	s.release.Do(func() {
+		registry.Lock()
+		r, ok := registry.hooks[s.key]
+		if !ok || r.hook != s.hook {
+			// Registry was reset.
+			registry.Unlock()
+			return
+		}
+		r.refs--
+		if r.refs > 0 {
+			registry.Unlock()
+			return
+		}
+		delete(registry.hooks, s.key)
+		registry.Unlock()
+		err = s.hook.Close()
+	})
+	return err
+}
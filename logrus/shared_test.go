@@ -0,0 +1,204 @@
+package logrusld
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LogDoc-org/logdoc-go-appender/common"
+	"github.com/sirupsen/logrus"
+)
+
+// readFrames parses LogDoc Native Protocol frames, checking checksum field if there is one.
+func readFrames(conn net.Conn, frames chan<- map[string]string, errs chan<- error) {
+	r := bufio.NewReader(conn)
+	for {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		if header[0] != 6 || header[1] != 3 {
+			errs <- fmt.Errorf("bad header %v", header)
+			return
+		}
+		fields := map[string]string{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\n" {
+				break
+			}
+			key, value, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "=")
+			if !ok {
+				// Complex pair: key line, 4 bytes of length and value.
+				size := make([]byte, 4)
+				if _, err := io.ReadFull(r, size); err != nil {
+					return
+				}
+				buf := make([]byte, binary.BigEndian.Uint32(size))
+				if _, err := io.ReadFull(r, buf); err != nil {
+					return
+				}
+				value = string(buf)
+			}
+			fields[key] = value
+		}
+		if _, ok := fields[common.ChecksumKey]; ok {
+			var decoded []common.Field
+			for k, v := range fields {
+				decoded = append(decoded, common.Field{Key: k, Value: v})
+			}
+			if err := common.VerifyChecksum(decoded); err != nil {
+				errs <- err
+				return
+			}
+		}
+		frames <- fields
+	}
+}
+
+func TestSharedHookParallel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	frames := make(chan map[string]string, 1000)
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readFrames(conn, frames, errs)
+	}()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	const perApp = 200
+	var wg sync.WaitGroup
+	for _, app := range []string{"billing", "orders"} {
+		l := logrus.New()
+		l.SetOutput(io.Discard)
+		l.SetReportCaller(true)
+		l.AddHook(hook.Share(app))
+		wg.Add(1)
+		go func(app string) {
+			defer wg.Done()
+			for i := 0; i < perApp; i++ {
+				l.Infof("%s %d %s", app, i, strings.Repeat("x", 1000))
+			}
+		}(app)
+	}
+	wg.Wait()
+
+	for i := 0; i < 2*perApp; i++ {
+		select {
+		case f := <-frames:
+			if !strings.HasPrefix(f["msg"], f["app"]+" ") {
+				t.Fatalf("message %q in frame of %q", f["msg"], f["app"])
+			}
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d frames of %d", i, 2*perApp)
+		}
+	}
+}
+
+func TestSharedRegistry(t *testing.T) {
+	defer ResetShared()
+	srv := startTestServer(t, "127.0.0.1:0")
+	defer srv.stop()
+	address := srv.ln.Addr().String()
+
+	var wg sync.WaitGroup
+	hooks := make([]*SharedHook, 10)
+	for i := range hooks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			hooks[i], err = Shared("tcp", address, fmt.Sprint("app", i))
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, h := range hooks[1:] {
+		if h.hook != hooks[0].hook {
+			t.Fatal("endpoint hook is not shared")
+		}
+	}
+	if other, _ := Shared("tcp", "127.0.0.1:1", "other", func(h *Hook) { h.ReconnectBaseDelay = time.Hour }); other.hook == hooks[0].hook {
+		t.Fatal("hook shared between endpoints")
+	}
+
+	base := hooks[0].hook
+	for _, h := range hooks[1:] {
+		_ = h.Close()
+		_ = h.Close()
+	}
+	if !base.Connected() {
+		t.Fatal("connection closed while hook is still used")
+	}
+	_ = hooks[0].Close()
+	if base.Connected() {
+		t.Fatal("connection not closed after the last hook")
+	}
+	if again, _ := Shared("tcp", address, "app"); again.hook == base {
+		t.Fatal("closed hook returned from registry")
+	}
+}
+
+func TestSharedOptions(t *testing.T) {
+	defer ResetShared()
+	if _, err := Shared("udp", "127.0.0.1:1", "app", WithTLS(nil)); err == nil {
+		t.Fatal("invalid options accepted")
+	}
+	// Failed hook is not kept in registry.
+	shared, err := Shared("udp", "127.0.0.1:1", "app", WithQueue(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared.hook.fireChannel == nil || cap(shared.hook.fireChannel) != 16 {
+		t.Fatal("WithQueue is not applied")
+	}
+}
+
+// serveFrames accepts connections on address and collects frames received over all of them.
+func serveFrames(t *testing.T, address string) (net.Listener, chan map[string]string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := make(chan map[string]string, 1000)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				readFrames(conn, frames, make(chan error, 1))
+			}()
+		}
+	}()
+	return ln, frames
+}
@@ -0,0 +1,262 @@
+package logrusld
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSpoolMaxBytes = 64 << 20
+	spoolFiles           = 8 // Spool is rotated over about that many files.
+	spoolExt             = ".spool"
+)
+
+// spool keeps encoded messages on disk while LogDoc server is unreachable, guarded by its own lock.
+// Every record is 4 bytes of length and message fields, files are replayed oldest first.
+type spool struct {
+	sync.Mutex
+	loaded     bool
+	files      []string // Spool files, oldest first.
+	sizes      map[string]int64
+	size       int64    // Total size of files.
+	active     *os.File // File appended to, nil if the newest one is sealed.
+	seq        uint64
+	replaying  bool
+	replayFile string
+	replayed   int // Offset of the first record of replayFile not sent yet.
+}
+
+// spoolMessage appends message to spool, message is dropped if it fails.
+func (h *Hook) spoolMessage(fields []byte) error {
+	if err := h.spoolAppend(fields); err != nil {
+		h.drop(1)
+		h.deadLetter(queued{}, fields, err)
+		h.reportError(err)
+		if h.OnError == nil {
+			logrus.Errorf("Ошибка записи в спул, %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func (h *Hook) spoolAppend(fields []byte) error {
+	s := &h.spool
+	s.Lock()
+	defer s.Unlock()
+	if err := h.loadSpool(); err != nil {
+		return err
+	}
+
+	record := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(fields)), uint32(len(fields)))
+	record = append(record, fields...)
+	fileMax := h.spoolMaxBytes() / spoolFiles
+	if s.active != nil && s.sizes[s.active.Name()]+int64(len(record)) > fileMax {
+		// Rotate.
+		_ = s.active.Close()
+		s.active = nil
+	}
+	if s.active == nil {
+		s.seq++
+		f, err := os.OpenFile(filepath.Join(h.SpoolDir, fmt.Sprintf("%020d%s", s.seq, spoolExt)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		s.active = f
+		s.files = append(s.files, f.Name())
+	}
+	if _, err := s.active.Write(record); err != nil {
+		return err
+	}
+	s.sizes[s.active.Name()] += int64(len(record))
+	s.size += int64(len(record))
+
+	// Spool is full, the oldest messages are dropped.
+	for s.size > h.spoolMaxBytes() && len(s.files) > 1 {
+		h.removeSpoolFile(s.files[0])
+	}
+	h.startReplay()
+	return nil
+}
+
+// loadSpool picks up files left by previous run, spool lock should be held.
+func (h *Hook) loadSpool() error {
+	s := &h.spool
+	if s.loaded {
+		return nil
+	}
+	if err := os.MkdirAll(h.SpoolDir, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(h.SpoolDir)
+	if err != nil {
+		return err
+	}
+	s.sizes = map[string]int64{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), spoolExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		name := filepath.Join(h.SpoolDir, e.Name())
+		s.files = append(s.files, name)
+		s.sizes[name] = info.Size()
+		s.size += info.Size()
+		var seq uint64
+		if _, err := fmt.Sscanf(e.Name(), "%d", &seq); err == nil && seq > s.seq {
+			s.seq = seq
+		}
+	}
+	sort.Strings(s.files)
+	s.loaded = true
+	return nil
+}
+
+// removeSpoolFile deletes spool file, spool lock should be held.
+func (h *Hook) removeSpoolFile(name string) {
+	s := &h.spool
+	for i, f := range s.files {
+		if f == name {
+			s.files = append(s.files[:i], s.files[i+1:]...)
+			break
+		}
+	}
+	if s.active != nil && s.active.Name() == name {
+		_ = s.active.Close()
+		s.active = nil
+	}
+	if s.replayFile == name {
+		s.replayFile, s.replayed = "", 0
+	}
+	s.size -= s.sizes[name]
+	delete(s.sizes, name)
+	_ = os.Remove(name)
+}
+
+// spoolPending reports whether spool has messages not replayed yet, then new messages go after them.
+func (h *Hook) spoolPending() bool {
+	s := &h.spool
+	s.Lock()
+	defer s.Unlock()
+	if err := h.loadSpool(); err != nil {
+		return false
+	}
+	h.startReplay()
+	return len(s.files) > 0
+}
+
+// startReplay starts sending spool in background, spool lock should be held.
+func (h *Hook) startReplay() {
+	s := &h.spool
+	if s.replaying || len(s.files) == 0 {
+		return
+	}
+	s.replaying = true
+	go h.replaySpool()
+}
+
+// replaySpool sends spooled messages in order, as soon as connection is back.
+func (h *Hook) replaySpool() {
+	s := &h.spool
+	for {
+		if !h.waitConnected() {
+			s.Lock()
+			s.replaying = false
+			s.Unlock()
+			return
+		}
+
+		s.Lock()
+		if len(s.files) == 0 {
+			s.replaying = false
+			s.Unlock()
+			return
+		}
+		name := s.files[0]
+		if s.active != nil && s.active.Name() == name {
+			// New messages go to the next file.
+			_ = s.active.Close()
+			s.active = nil
+		}
+		if s.replayFile != name {
+			s.replayFile, s.replayed = name, 0
+		}
+		offset := s.replayed
+		s.Unlock()
+
+		data, err := os.ReadFile(name)
+		for err == nil && offset+4 <= len(data) {
+			size := int(binary.BigEndian.Uint32(data[offset:]))
+			if offset+4+size > len(data) {
+				// Truncated record, e.g. after crash.
+				break
+			}
+			fields := data[offset+4 : offset+4+size]
+			if err = h.sendFields(fields); err != nil && permanent(err) {
+				// Retry won't help, record is given up on.
+				h.deadLetter(queued{}, fields, err)
+				h.reportError(err)
+				err = nil
+			}
+			if err == nil {
+				offset += 4 + size
+			}
+		}
+
+		s.Lock()
+		if s.replayFile == name {
+			s.replayed = offset
+			if err == nil || os.IsNotExist(err) {
+				h.removeSpoolFile(name)
+			}
+		}
+		s.Unlock()
+
+		if err != nil && !os.IsNotExist(err) {
+			// Wait for reconnect before the next try.
+			select {
+			case <-time.After(h.spoolRetryDelay()):
+			case <-h.done:
+				s.Lock()
+				s.replaying = false
+				s.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// closeSpool closes file appended to, spooled messages are replayed by the next run.
+func (h *Hook) closeSpool() {
+	s := &h.spool
+	s.Lock()
+	defer s.Unlock()
+	if s.active != nil {
+		_ = s.active.Close()
+		s.active = nil
+	}
+}
+
+func (h *Hook) spoolMaxBytes() int64 {
+	if h.SpoolMaxBytes > 0 {
+		return h.SpoolMaxBytes
+	}
+	return defaultSpoolMaxBytes
+}
+
+func (h *Hook) spoolRetryDelay() time.Duration {
+	if h.ReconnectBaseDelay > 0 {
+		return h.ReconnectBaseDelay
+	}
+	return defaultReconnectBaseDelay
+}
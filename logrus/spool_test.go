@@ -0,0 +1,158 @@
+package logrusld
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func waitMessages(t *testing.T, frames chan map[string]string, want []string) {
+	t.Helper()
+	for _, msg := range want {
+		select {
+		case f := <-frames:
+			if f["msg"] != msg {
+				t.Fatalf("received %q, want %q", f["msg"], msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%q was not delivered", msg)
+		}
+	}
+}
+
+func TestSpoolOutage(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	address := ln.Addr().String()
+
+	hook := NewLazyHook("tcp", address)
+	hook.SpoolDir = t.TempDir()
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	hook.MaxReconnectDelay = 20 * time.Millisecond
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Kill server with its connections, wait until hook notices.
+	_ = ln.Close()
+	hook.Lock()
+	for _, l := range hook.links {
+		_ = l.conn.Close()
+	}
+	hook.Unlock()
+	// Failed message is spooled too.
+	want := []string{"failed"}
+	_ = hook.Fire(testEntry("failed"))
+	if hook.Connected() {
+		t.Fatal("broken connection was not detected")
+	}
+	for i := 0; i < 50; i++ {
+		want = append(want, fmt.Sprint("spooled ", i))
+		_ = hook.Fire(testEntry(want[len(want)-1]))
+	}
+	if files, _ := os.ReadDir(hook.SpoolDir); len(files) == 0 {
+		t.Fatal("nothing spooled")
+	}
+
+	ln, frames = serveFrames(t, address)
+	defer ln.Close()
+	_ = hook.Fire(testEntry("live"))
+	waitMessages(t, frames, append(want, "live"))
+	for hook.spoolPending() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if files, _ := os.ReadDir(hook.SpoolDir); len(files) != 0 {
+		t.Fatalf("%d spool files left after replay", len(files))
+	}
+}
+
+func TestSpoolReplayedAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	address := ln.Addr().String()
+	_ = ln.Close()
+
+	// Previous run could not reach server at all.
+	hook := NewLazyHook("tcp", address)
+	hook.SpoolDir = dir
+	hook.ReconnectBaseDelay = time.Hour
+	var want []string
+	for i := 0; i < 10; i++ {
+		want = append(want, fmt.Sprint("before restart ", i))
+		_ = hook.Fire(testEntry(want[i]))
+	}
+	_ = hook.Close()
+
+	ln, frames = serveFrames(t, address)
+	defer ln.Close()
+	hook = NewLazyHook("tcp", address)
+	hook.SpoolDir = dir
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	waitMessages(t, frames, want)
+}
+
+func TestSpoolFullDropsOldestFile(t *testing.T) {
+	hook := NewLazyHook("tcp", "127.0.0.1:1")
+	hook.SpoolDir = t.TempDir()
+	hook.SpoolMaxBytes = 8 << 10
+	defer hook.Close()
+
+	fields := make([]byte, 100)
+	for i := 0; i < 1000; i++ {
+		if err := hook.spoolAppend(fields); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hook.spool.Lock()
+	size, files := hook.spool.size, len(hook.spool.files)
+	hook.spool.Unlock()
+	if size > hook.SpoolMaxBytes || files < 2 {
+		t.Fatalf("spool size = %d in %d files", size, files)
+	}
+}
+
+func TestSpoolSkipsOversized(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	address := ln.Addr().String()
+	_ = ln.Close()
+
+	dead := make(chan error, 10)
+	hook := NewLazyHook("tcp", address)
+	hook.SpoolDir = t.TempDir()
+	hook.MaxEventBytes = 300
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	hook.MaxReconnectDelay = 20 * time.Millisecond
+	hook.OnDeadLetter = func(payload []byte, entry *logrus.Entry, err error) {
+		dead <- err
+	}
+	hook.OnError = func(error) {}
+	defer hook.Close()
+
+	_ = hook.Fire(testEntry("before"))
+	if err := hook.spoolAppend([]byte(strings.Repeat("x", 500))); err != nil {
+		t.Fatal(err)
+	}
+	_ = hook.Fire(testEntry("after"))
+
+	ln, frames = serveFrames(t, address)
+	defer ln.Close()
+	waitMessages(t, frames, []string{"before", "after"})
+	select {
+	case err := <-dead:
+		if err != ErrOversized {
+			t.Fatalf("dead letter error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("oversized record was not dead-lettered")
+	}
+	for hook.spoolPending() {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
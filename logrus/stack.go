@@ -0,0 +1,72 @@
+package logrusld
+
+import (
+	"sort"
+
+	"github.com/LogDoc-org/logdoc-go-appender/common"
+	"github.com/sirupsen/logrus"
+)
+
+// loggingPackages are skipped in stack trace of logging goroutine, with hook methods called by them.
+var loggingPackages = []string{"github.com/sirupsen/logrus."}
+
+// stackTraceLevel returns the least severe level sent with stack trace.
+func (h *Hook) stackTraceLevel() logrus.Level {
+	if h.StackTraceLevel == logrus.PanicLevel {
+		return logrus.ErrorLevel
+	}
+	return h.StackTraceLevel
+}
+
+// withStackTrace returns copy of entry with stacktrace field, entry as is if it has the field already.
+// It is called by Fire, so that stack is of the logging goroutine, in async mode too.
+func (h *Hook) withStackTrace(entry *logrus.Entry) *logrus.Entry {
+	if _, ok := entry.Data[common.StackTraceKey]; ok {
+		return entry
+	}
+	stack := valueStack(entry.Data, h.StackTraceDepth)
+	if stack == "" {
+		stack = common.CallerStack(loggingPackages, h.StackTraceDepth)
+	}
+	c := snapshot(entry)
+	c.Data[common.StackTraceKey] = stack
+	return c
+}
+
+// valueStack returns stack trace of the first field by order of keys which carries it, see common.ValueStack.
+func valueStack(data logrus.Fields, depth int) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if pcs := common.ValueStack(data[k]); pcs != nil {
+			return common.FormatStack(pcs, depth)
+		}
+	}
+	return ""
+}
+
+// withCaller returns copy of entry with Caller skipping CallerSkip frames and frames of CallerSkipPackages.
+// Like withStackTrace, it is called by Fire, while the logging goroutine stack has the caller.
+func (h *Hook) withCaller(entry *logrus.Entry) *logrus.Entry {
+	caller, ok := common.CallerAbove(*entry.Caller, h.CallerSkip, h.CallerSkipPackages)
+	if !ok {
+		return entry
+	}
+	c := snapshot(entry)
+	c.Caller = &caller
+	return c
+}
+
+// withGoroutineID returns copy of entry with gid field, entry as is if it has the field already.
+// Like withStackTrace, it is called by Fire, in the logging goroutine.
+func (h *Hook) withGoroutineID(entry *logrus.Entry) *logrus.Entry {
+	if _, ok := entry.Data[common.GoroutineIDKey]; ok {
+		return entry
+	}
+	c := snapshot(entry)
+	c.Data[common.GoroutineIDKey] = common.GoroutineID()
+	return c
+}
@@ -0,0 +1,193 @@
+package logrusld
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LogDoc-org/logdoc-go-appender/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Like github.com/pkg/errors.
+type frame uintptr
+
+type stackError struct{ stack []frame }
+
+func (e *stackError) Error() string { return "failed" }
+
+func (e *stackError) StackTrace() []frame { return e.stack }
+
+func failingCall() error {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(1, pcs)
+	e := &stackError{}
+	for _, pc := range pcs[:n] {
+		e.stack = append(e.stack, frame(pc))
+	}
+	return e
+}
+
+func TestStackTrace(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	hook, err := New(ln.Addr().String(), WithQueue(10), func(h *Hook) {
+		h.StackTrace = true
+		h.StackTraceDepth = 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	l.AddHook(hook)
+	next := func() map[string]string {
+		t.Helper()
+		select {
+		case f := <-frames:
+			return f
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+		}
+		return nil
+	}
+
+	l.Warn("no stack")
+	if f := next(); f["stacktrace"] != "" {
+		t.Fatalf("warn stack = %q", f["stacktrace"])
+	}
+
+	l.WithField("status", 500).Error("failed")
+	stack := strings.Split(next()["stacktrace"], "\n")
+	if len(stack) != 2 || stack[0] != "github.com/LogDoc-org/logdoc-go-appender/logrus.TestStackTrace()" ||
+		!strings.Contains(stack[1], "stack_test.go:") {
+		t.Fatalf("stack = %q", stack)
+	}
+
+	l.WithError(fmt.Errorf("handler: %w", failingCall())).Error("failed")
+	if stack := next()["stacktrace"]; !strings.HasPrefix(stack, "github.com/LogDoc-org/logdoc-go-appender/logrus.failingCall()\n\t") {
+		t.Fatalf("error stack = %q", stack)
+	}
+
+	l.WithField("stacktrace", "own").Error("failed")
+	if stack := next()["stacktrace"]; stack != "own" {
+		t.Fatalf("own stack = %q", stack)
+	}
+}
+
+func TestStackTraceLevel(t *testing.T) {
+	hook := NewLazyHook("tcp", "logdoc:5656")
+	hook.StackTrace = true
+	hook.StackTraceLevel = logrus.WarnLevel
+	if hook.stackTraceLevel() != logrus.WarnLevel {
+		t.Fatalf("level = %s", hook.stackTraceLevel())
+	}
+	entry := testEntry("warn")
+	entry.Data = logrus.Fields{"err": errors.New("plain")}
+	if c := hook.withStackTrace(entry); c == entry || c.Data["stacktrace"] == "" || len(entry.Data) != 1 {
+		t.Fatalf("entry = %v, copy = %v", entry.Data, c.Data)
+	}
+}
+
+// Two-level logging facade.
+func facadeInfo(l *logrus.Logger, msg string) {
+	facadeLog(l, msg)
+}
+
+func facadeLog(l *logrus.Logger, msg string) {
+	l.Info(msg)
+}
+
+func TestCallerSkip(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	hook, err := New(ln.Addr().String(), func(h *Hook) {
+		h.SourceFormat = common.SourceShortFunc
+		h.CallerSkip = 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	l.SetReportCaller(true)
+	l.AddHook(hook)
+	next := func() string {
+		t.Helper()
+		select {
+		case f := <-frames:
+			return f["src"]
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+		}
+		return ""
+	}
+
+	_, _, line, _ := runtime.Caller(0)
+	facadeInfo(l, "wrapped")
+	if src, want := next(), fmt.Sprintf("logrus.TestCallerSkip:%d", line+1); src != want {
+		t.Fatalf("src = %q, want %q", src, want)
+	}
+
+	hook.CallerSkip = 1000
+	facadeInfo(l, "short stack")
+	if src := next(); !strings.HasPrefix(src, "logrus.facadeLog:") {
+		t.Fatalf("src of short stack = %q", src)
+	}
+}
+
+func TestGoroutineID(t *testing.T) {
+	ln, frames := serveFrames(t, "127.0.0.1:0")
+	defer ln.Close()
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.IncludeGoroutineID = true
+	hook.MakeAsync()
+	defer hook.Close()
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	const messages = 5
+	var wg sync.WaitGroup
+	for g := 0; g < 2; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				_ = hook.Fire(testEntry(fmt.Sprint("worker ", g)))
+			}
+		}(g)
+	}
+	wg.Wait()
+	gids := map[string]map[string]bool{}
+	for i := 0; i < 2*messages; i++ {
+		select {
+		case f := <-frames:
+			if gids[f["msg"]] == nil {
+				gids[f["msg"]] = map[string]bool{}
+			}
+			gids[f["msg"]][f[common.GoroutineIDKey]] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%d messages were delivered", i)
+		}
+	}
+	first, second := gids["worker 0"], gids["worker 1"]
+	if len(first) != 1 || len(second) != 1 || first[""] || second[""] {
+		t.Fatalf("gids = %v", gids)
+	}
+	for gid := range first {
+		if second[gid] {
+			t.Fatalf("both goroutines have gid %s", gid)
+		}
+	}
+}
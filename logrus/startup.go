@@ -0,0 +1,140 @@
+package logrusld
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultStartupTimeout = 30 * time.Second
+
+// startupBuffer keeps messages fired before the first connect, guarded by its own lock.
+type startupBuffer struct {
+	sync.Mutex
+	msgs     []batched // Oldest first.
+	size     int
+	closed   bool // Hook connected and buffer is sent, or StartupTimeout passed.
+	flushing bool
+	timer    *time.Timer
+}
+
+// startupHold keeps message in startup buffer if hook has not connected yet, or buffer is still being sent.
+// Message is encoded at once, so it keeps its time. Messages that don't fit are handled as usual, with fields
+// returned if they are encoded already.
+func (h *Hook) startupHold(msg queued) (bool, []byte) {
+	s := &h.startup
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return false, nil
+	}
+	if len(s.msgs) == 0 && h.Connected() {
+		s.closed = true
+		h.startupDone.Store(true)
+		return false, nil
+	}
+	if len(s.msgs) >= h.StartupBuffer {
+		// Not encoded, so that message doesn't take sequence number twice.
+		return false, nil
+	}
+
+	fields := h.encoded(msg)
+	if h.StartupBufferBytes > 0 && s.size+len(fields) > h.StartupBufferBytes {
+		return false, fields
+	}
+	msg.entry, msg.fields = snapshot(msg.entry), fields
+	s.msgs = append(s.msgs, batched{msg: msg, fields: fields})
+	s.size += len(fields)
+	if s.timer == nil {
+		timeout := h.StartupTimeout
+		if timeout <= 0 {
+			timeout = defaultStartupTimeout
+		}
+		s.timer = time.AfterFunc(timeout, h.expireStartup)
+	}
+	if !s.flushing {
+		s.flushing = true
+		go h.flushStartup()
+	}
+	return true, nil
+}
+
+// flushStartup sends startup buffer in order as soon as hook connects, messages fired meanwhile
+// are appended to it.
+func (h *Hook) flushStartup() {
+	s := &h.startup
+	for {
+		if !h.waitConnected() {
+			// Dialing gave up for now or hook is closed.
+			select {
+			case <-time.After(h.spoolRetryDelay()):
+				continue
+			case <-h.done:
+				return
+			}
+		}
+
+		s.Lock()
+		if s.closed {
+			s.Unlock()
+			return
+		}
+		if len(s.msgs) == 0 {
+			s.closed, s.flushing = true, false
+			h.startupDone.Store(true)
+			if s.timer != nil {
+				s.timer.Stop()
+			}
+			s.Unlock()
+			return
+		}
+		msg := s.msgs[0]
+		s.Unlock()
+
+		err := h.sendFields(msg.fields)
+		if err != nil && permanent(err) {
+			// Retry won't help, message is given up on.
+			h.deadLetter(msg.msg, msg.fields, err)
+			h.reportError(err)
+		} else if err != nil {
+			// Wait for reconnect before the next try.
+			select {
+			case <-time.After(h.spoolRetryDelay()):
+				continue
+			case <-h.done:
+				return
+			}
+		}
+
+		s.Lock()
+		if len(s.msgs) > 0 {
+			s.size -= len(s.msgs[0].fields)
+			s.msgs = s.msgs[1:]
+		}
+		s.Unlock()
+	}
+}
+
+// expireStartup passes startup buffer to OnDeadLetter and Fallback if hook has not connected within StartupTimeout.
+func (h *Hook) expireStartup() {
+	s := &h.startup
+	s.Lock()
+	if s.closed || h.Connected() {
+		// Buffer is being sent.
+		s.Unlock()
+		return
+	}
+	msgs := s.msgs
+	s.msgs, s.size, s.closed = nil, 0, true
+	h.startupDone.Store(true)
+	s.Unlock()
+
+	h.drop(len(msgs))
+	h.deadLetterBatch(msgs, ErrNotConnected)
+}
+
+// startupPending returns number of messages in startup buffer.
+func (h *Hook) startupPending() int {
+	h.startup.Lock()
+	defer h.startup.Unlock()
+	return len(h.startup.msgs)
+}
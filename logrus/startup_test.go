@@ -0,0 +1,135 @@
+package logrusld
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestStartupBuffer(t *testing.T) {
+	// Reserve address for the server, which is down at start.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	_ = ln.Close()
+
+	hook := NewLazyHook("tcp", address)
+	hook.ReconnectBaseDelay = 5 * time.Millisecond
+	hook.MaxReconnectDelay = 10 * time.Millisecond
+	hook.StartupBuffer = 3
+	defer hook.Close()
+
+	for _, msg := range []string{"config loaded", "migrations applied", "listening"} {
+		_ = hook.Fire(testEntry(msg))
+	}
+	time.Sleep(20 * time.Millisecond)
+	ln, frames := serveFrames(t, address)
+	defer ln.Close()
+	waitMessages(t, frames, []string{"config loaded", "migrations applied", "listening"})
+	if hook.Dropped() != 0 {
+		t.Fatalf("dropped = %d", hook.Dropped())
+	}
+
+	// Buffer is not used after the first connect.
+	_ = hook.Fire(testEntry("running"))
+	waitMessages(t, frames, []string{"running"})
+	if !hook.startupDone.Load() {
+		t.Fatal("startup buffer is still open")
+	}
+}
+
+func TestStartupBufferTimeout(t *testing.T) {
+	dead := make(chan *logrus.Entry, 10)
+	hook := NewLazyHook("tcp", "127.0.0.1:1")
+	hook.ReconnectBaseDelay = 5 * time.Millisecond
+	hook.MaxReconnectDelay = 10 * time.Millisecond
+	hook.StartupBuffer = 2
+	hook.StartupTimeout = 50 * time.Millisecond
+	hook.OnDeadLetter = func(payload []byte, entry *logrus.Entry, err error) {
+		dead <- entry
+	}
+	defer hook.Close()
+
+	first, second := testEntry("first"), testEntry("second")
+	_ = hook.Fire(first)
+	_ = hook.Fire(second)
+	for _, want := range []*logrus.Entry{first, second} {
+		select {
+		case entry := <-dead:
+			if entry.Message != want.Message {
+				t.Fatalf("dead letter %q, want %q", entry.Message, want.Message)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q was not reported", want.Message)
+		}
+	}
+	if hook.Dropped() != 2 {
+		t.Fatalf("dropped = %d", hook.Dropped())
+	}
+}
+
+func TestStartupBufferSkipsOversized(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	_ = ln.Close()
+
+	dead := make(chan error, 10)
+	hook := NewLazyHook("tcp", address)
+	hook.ReconnectBaseDelay = 5 * time.Millisecond
+	hook.MaxReconnectDelay = 10 * time.Millisecond
+	hook.StartupBuffer = 3
+	hook.MaxEventBytes = 300
+	hook.OnDeadLetter = func(payload []byte, entry *logrus.Entry, err error) {
+		dead <- err
+	}
+	hook.OnError = func(error) {}
+	defer hook.Close()
+
+	_ = hook.Fire(testEntry("before"))
+	hook.startup.Lock()
+	hook.startup.msgs = append(hook.startup.msgs, batched{fields: []byte(strings.Repeat("x", 500))})
+	hook.startup.Unlock()
+	_ = hook.Fire(testEntry("after"))
+
+	ln, frames := serveFrames(t, address)
+	defer ln.Close()
+	waitMessages(t, frames, []string{"before", "after"})
+	select {
+	case err := <-dead:
+		if err != ErrOversized {
+			t.Fatalf("dead letter error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("oversized message was not dead-lettered")
+	}
+}
+
+func TestStartupBufferFullEncodesOnce(t *testing.T) {
+	var ids atomic.Int32
+	hook := NewLazyHook("tcp", "127.0.0.1:1")
+	hook.ReconnectBaseDelay = time.Hour
+	hook.StartupBuffer = 1
+	hook.Sequence = true
+	hook.EventID = func() string { return fmt.Sprint(ids.Add(1)) }
+	hook.OnError = func(error) {}
+	defer hook.Close()
+
+	_ = hook.Fire(testEntry("held"))
+	_ = hook.Fire(testEntry("not held"))
+	if n := ids.Load(); n != 2 {
+		t.Fatalf("EventID called %d times", n)
+	}
+	if n := hook.seq.Load(); n != 2 {
+		t.Fatalf("seq = %d", n)
+	}
+}
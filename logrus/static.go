@@ -0,0 +1,75 @@
+package logrusld
+
+import (
+	"runtime/debug"
+	"sync"
+
+	"github.com/LogDoc-org/logdoc-go-appender/common"
+	"github.com/sirupsen/logrus"
+)
+
+// staticFields are StaticFields encoded once, with the first message.
+type staticFields struct {
+	once    sync.Once
+	fields  []common.Field
+	encoded []byte
+}
+
+func (h *Hook) staticFields() *staticFields {
+	s := &h.static
+	s.once.Do(func() {
+		for _, f := range common.StaticFields(h.staticFieldMap()) {
+			s.fields = h.appendStaticField(s.fields, f)
+		}
+		for _, f := range s.fields {
+			h.writeValue(f.Key, f.Value, &s.encoded)
+		}
+	})
+	return s
+}
+
+// readBuildInfo reads build information for IncludeBuildInfo, replaced by tests.
+var readBuildInfo = debug.ReadBuildInfo
+
+// staticFieldMap returns StaticFields with build information and Kubernetes metadata.
+func (h *Hook) staticFieldMap() map[string]string {
+	if !h.IncludeBuildInfo && !h.IncludeKubernetes {
+		return h.StaticFields
+	}
+	var build, kubernetes map[string]string
+	if h.IncludeBuildInfo {
+		build = common.BuildFields(readBuildInfo, h.BuildInfoKeys)
+	}
+	if h.IncludeKubernetes {
+		kubernetes = common.KubernetesFields(nil)
+	}
+	return common.MergeStaticFields(build, kubernetes, h.StaticFields)
+}
+
+// appendDynamicFields appends fields returned by StaticFieldsFunc, except ones set in StaticFields.
+func (h *Hook) appendDynamicFields(fields []common.Field) []common.Field {
+	if h.StaticFieldsFunc == nil {
+		return fields
+	}
+	var dynamic map[string]string
+	if h.protect("StaticFieldsFunc", func() { dynamic = h.StaticFieldsFunc() }) != nil {
+		return fields
+	}
+	for _, f := range common.StaticFields(dynamic) {
+		if _, ok := h.StaticFields[f.Key]; !ok {
+			fields = h.appendStaticField(fields, f)
+		}
+	}
+	return fields
+}
+
+// appendStaticField appends field renamed or dropped if its key is reserved, like appendUserField, but not
+// passed through ReplaceField. Validate rejects such StaticFields, but hook may be used without it.
+func (h *Hook) appendStaticField(fields []common.Field, f common.Field) []common.Field {
+	key := h.userKey(f.Key)
+	if key == "" {
+		logrus.Warnf("Поле %s зарезервировано LogDoc и не отправляется", f.Key)
+		return fields
+	}
+	return append(fields, common.Field{Key: key, Value: f.Value})
+}
@@ -0,0 +1,156 @@
+package logrusld
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSignedCert creates certificate valid for 127.0.0.1 and returns it with pool trusting it.
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "logdoc-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+func TestTLSDelivery(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveTestServer(ln)
+	defer srv.stop()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.TLSConfig = &tls.Config{RootCAs: pool}
+	defer hook.Close()
+
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.write([]byte("over tls\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.waitLine(t, "over tls", time.Second) {
+		t.Fatal("message was not delivered over TLS")
+	}
+}
+
+func TestTLSHandshakeFailure(t *testing.T) {
+	cert, _ := selfSignedCert(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveTestServer(ln)
+	defer srv.stop()
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	// Server certificate is not trusted.
+	hook.TLSConfig = &tls.Config{}
+	defer hook.Close()
+
+	if err := hook.Connect(); err == nil {
+		t.Fatal("handshake with untrusted certificate succeeded")
+	}
+	if hook.Connected() {
+		t.Fatal("hook connected after failed handshake")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		// With TLS 1.2 client certificate rejection is seen by the client during handshake.
+		MaxVersion: tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveTestServer(ln)
+	defer srv.stop()
+
+	certFile, keyFile := writeCertFiles(t, cert)
+
+	hook := NewLazyHook("tcp", ln.Addr().String())
+	hook.TLSConfig = &tls.Config{RootCAs: pool}
+	hook.ClientCertFile = certFile
+	hook.ClientKeyFile = keyFile
+	hook.ReloadClientCert = true
+	defer hook.Close()
+
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.write([]byte("mtls\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.waitLine(t, "mtls", time.Second) {
+		t.Fatal("message was not delivered over mutual TLS")
+	}
+
+	rejected := NewLazyHook("tcp", ln.Addr().String())
+	rejected.TLSConfig = &tls.Config{RootCAs: pool}
+	defer rejected.Close()
+	if err := rejected.Connect(); err == nil {
+		t.Fatal("server accepted client without certificate")
+	}
+}
+
+func writeCertFiles(t *testing.T, cert tls.Certificate) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
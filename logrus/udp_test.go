@@ -0,0 +1,46 @@
+package logrusld
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPDatagrams(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	hook := NewLazyHook("tcp", "udp://"+pc.LocalAddr().String())
+	hook.MaxDatagramSize = 16
+	defer hook.Close()
+
+	if hook.protocol != "udp" {
+		t.Fatalf("protocol = %q", hook.protocol)
+	}
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.write([]byte("datagram\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.write(bytes.Repeat([]byte("x"), 17)); err != ErrOversized {
+		t.Fatalf("oversized write error = %v", err)
+	}
+	if hook.Oversized() != 1 {
+		t.Fatalf("oversized = %d", hook.Oversized())
+	}
+
+	buf := make([]byte, 1024)
+	_ = pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "datagram\n" {
+		t.Fatalf("received %q", buf[:n])
+	}
+}
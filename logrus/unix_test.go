@@ -0,0 +1,59 @@
+package logrusld
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "logdoc.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveTestServer(ln)
+
+	hook := NewLazyHook("tcp", "unix://"+sock)
+	hook.ReconnectBaseDelay = 10 * time.Millisecond
+	hook.MaxReconnectDelay = 20 * time.Millisecond
+	defer hook.Close()
+
+	if err := hook.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if hook.sourceIP() != hostIP() {
+		t.Fatalf("ip = %q, want host IP %q", hook.sourceIP(), hostIP())
+	}
+	if err := hook.write([]byte("unix\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.waitLine(t, "unix", time.Second) {
+		t.Fatal("message was not delivered over unix socket")
+	}
+
+	// Relay agent restarts and recreates the socket file.
+	srv.stop()
+	_ = os.Remove(sock)
+	for i := 0; i < 100 && hook.Connected(); i++ {
+		_ = hook.write([]byte("lost\n"))
+		time.Sleep(5 * time.Millisecond)
+	}
+	ln, err = net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv = serveTestServer(ln)
+	defer srv.stop()
+
+	delivered := false
+	for i := 0; i < 200 && !delivered; i++ {
+		_ = hook.write([]byte("again\n"))
+		delivered = srv.waitLine(t, "again", 10*time.Millisecond)
+	}
+	if !delivered {
+		t.Fatal("logging did not resume after socket was recreated")
+	}
+}
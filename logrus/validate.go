@@ -0,0 +1,123 @@
+package logrusld
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/LogDoc-org/logdoc-go-appender/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Validate checks hook configuration and returns all problems found, joined. NewHook calls it before dialing,
+// with NewLazyHook it may be called after fields are set, before MakeAsync.
+func (h *Hook) Validate() error {
+	var errs []error
+	if h.DialFunc == nil {
+		if h.address == "" {
+			errs = append(errs, errors.New("LogDoc server address is empty"))
+		}
+		if !knownProtocol(h.protocol) {
+			errs = append(errs, fmt.Errorf("unsupported LogDoc protocol %q", h.protocol))
+		}
+	}
+
+	if h.App != "" && common.SanitizeApp(h.App) == "" {
+		// Sent without application otherwise, empty App means the default one.
+		errs = append(errs, fmt.Errorf("LogDoc hook App %q is blank", h.App))
+	}
+
+	levels := []struct {
+		name  string
+		level logrus.Level
+	}{{"Level", h.Level}, {"FlushOnLevel", h.FlushOnLevel}, {"HeartbeatLevel", h.HeartbeatLevel}, {"StackTraceLevel", h.StackTraceLevel}}
+	for _, l := range levels {
+		if l.level > logrus.TraceLevel {
+			errs = append(errs, fmt.Errorf("invalid LogDoc hook %s %d", l.name, l.level))
+		}
+	}
+
+	sizes := []struct {
+		name string
+		size int64
+	}{
+		{"AsyncBufferSize", int64(h.AsyncBufferSize)},
+		{"AsyncWorkers", int64(h.AsyncWorkers)},
+		{"MaxSendRetries", int64(h.MaxSendRetries)},
+		{"RetryBudget", int64(h.RetryBudget)},
+		{"MaxReconnectRetries", int64(h.MaxReconnectRetries)},
+		{"MaxDatagramSize", int64(h.MaxDatagramSize)},
+		{"PoolSize", int64(h.PoolSize)},
+		{"ReplayBuffer", int64(h.ReplayBuffer)},
+		{"ReplayBufferBytes", int64(h.ReplayBufferBytes)},
+		{"StartupBuffer", int64(h.StartupBuffer)},
+		{"StartupBufferBytes", int64(h.StartupBufferBytes)},
+		{"RateBurst", int64(h.RateBurst)},
+		{"BatchSize", int64(h.BatchSize)},
+		{"MaxEventBytes", int64(h.MaxEventBytes)},
+		{"MaxBodyLines", int64(h.MaxBodyLines)},
+		{"MaxFields", int64(h.MaxFields)},
+		{"StackTraceDepth", int64(h.StackTraceDepth)},
+		{"CallerSkip", int64(h.CallerSkip)},
+		{"CompressionThreshold", int64(h.CompressionThreshold)},
+		{"SpoolMaxBytes", h.SpoolMaxBytes},
+		{"WriteBufferSize", int64(h.WriteBufferSize)},
+		{"FailoverThreshold", int64(h.FailoverThreshold)},
+		{"BreakerThreshold", int64(h.BreakerThreshold)},
+	}
+	for _, s := range sizes {
+		if s.size < 0 {
+			errs = append(errs, fmt.Errorf("LogDoc hook %s is negative: %d", s.name, s.size))
+		}
+	}
+	if h.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("LogDoc hook RateLimit is negative: %g", h.RateLimit))
+	}
+	for level, rate := range h.Sampling {
+		if rate < 0 || rate > 1 {
+			errs = append(errs, fmt.Errorf("LogDoc hook sampling rate of %s is out of [0, 1]: %g", level, rate))
+		}
+	}
+	if err := h.FieldNames.Check(); err != nil {
+		errs = append(errs, fmt.Errorf("LogDoc hook FieldNames: %w", err))
+	}
+	if err := (common.KeyFilter{AllowKeys: h.AllowKeys, DenyKeys: h.DenyKeys}).Check(); err != nil {
+		errs = append(errs, fmt.Errorf("LogDoc hook AllowKeys or DenyKeys: %w", err))
+	}
+	if err := h.FieldNames.CheckStaticFields(h.StaticFields); err != nil {
+		errs = append(errs, fmt.Errorf("LogDoc hook StaticFields: %w", err))
+	}
+	switch {
+	case h.Checksum < common.ChecksumNone || h.Checksum > common.ChecksumSHA256:
+		errs = append(errs, fmt.Errorf("unsupported LogDoc hook Checksum %d", h.Checksum))
+	case h.Checksum != common.ChecksumNone && h.Format == common.FormatJSON:
+		errs = append(errs, errors.New("LogDoc hook Checksum is not supported with FormatJSON"))
+	}
+	switch h.Compression {
+	case common.CompressionNone:
+	case common.CompressionGzip:
+		if len(h.Protocol.CompressedHeader) == 0 {
+			errs = append(errs, errors.New("LogDoc hook Compression requires Protocol.CompressedHeader"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported LogDoc hook Compression %d", h.Compression))
+	}
+	if h.isDatagram() && (h.TLSConfig != nil || h.ClientCertificate != nil || h.ClientCertFile != "") {
+		errs = append(errs, errors.New("LogDoc hook TLS is not supported over UDP"))
+	}
+	if (h.ClientCertFile == "") != (h.ClientKeyFile == "") {
+		errs = append(errs, errors.New("LogDoc hook ClientCertFile and ClientKeyFile should be set together"))
+	}
+	errs = append(errs, h.checkRoutes()...)
+	return errors.Join(errs...)
+}
+
+func knownProtocol(protocol string) bool {
+	switch {
+	case protocol == "http" || protocol == "https":
+		return true
+	case strings.HasPrefix(protocol, "tcp"), strings.HasPrefix(protocol, "udp"), strings.HasPrefix(protocol, "unix"):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package logrusld
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		hook *Hook
+		want []string
+	}{
+		"valid":    {NewLazyHook("tcp", "logdoc:5656"), nil},
+		"url":      {NewLazyHook("", "udp://logdoc:5656"), nil},
+		"empty":    {NewLazyHook("tcp", ""), []string{"address is empty"}},
+		"protocol": {NewLazyHook("smtp", "logdoc:25"), []string{`unsupported LogDoc protocol "smtp"`}},
+		"app":      {func() *Hook { h := NewLazyHook("tcp", "logdoc:5656"); h.App = "  "; return h }(), []string{`App "  " is blank`}},
+		"level":    {func() *Hook { h := NewLazyHook("tcp", "logdoc:5656"); h.Level = 42; return h }(), []string{"Level 42"}},
+		"sizes": {func() *Hook {
+			h := NewLazyHook("tcp", "logdoc:5656")
+			h.AsyncBufferSize = -1
+			h.PoolSize = -2
+			return h
+		}(), []string{"AsyncBufferSize is negative: -1", "PoolSize is negative: -2"}},
+		"sampling": {func() *Hook {
+			h := NewLazyHook("tcp", "logdoc:5656")
+			h.Sampling = map[logrus.Level]float64{logrus.DebugLevel: 2}
+			return h
+		}(), []string{"sampling rate of debug"}},
+		"client cert": {func() *Hook { h := NewLazyHook("tcp", "logdoc:5656"); h.ClientCertFile = "cert.pem"; return h }(), []string{"ClientKeyFile"}},
+		"static fields": {func() *Hook {
+			h := NewLazyHook("tcp", "logdoc:5656")
+			h.StaticFields = map[string]string{"env": "prod", "pid": "1", "app": "other"}
+			return h
+		}(), []string{"StaticFields: fields app, pid are reserved"}},
+		"key pattern":  {func() *Hook { h := NewLazyHook("tcp", "logdoc:5656"); h.DenyKeys = []string{"internal.["}; return h }(), []string{`DenyKeys: invalid key pattern "internal.["`}},
+		"tls over udp": {func() *Hook { h := NewLazyHook("udp", "logdoc:5656"); h.TLSConfig = &tls.Config{}; return h }(), []string{"TLS is not supported over UDP"}},
+		"dial func": {func() *Hook {
+			h := NewLazyHook("", "")
+			h.DialFunc = func(ctx context.Context) (net.Conn, error) { return nil, nil }
+			return h
+		}(), nil},
+		"several bugs": {func() *Hook { h := NewLazyHook("smtp", ""); h.BatchSize = -1; return h }(), []string{"address is empty", "protocol", "BatchSize"}},
+	}
+	for name, test := range tests {
+		err := test.hook.Validate()
+		if len(test.want) == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: no error", name)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error = %v, want %q", name, err, want)
+			}
+		}
+	}
+}
+
+func TestNewHookValidates(t *testing.T) {
+	if hook, err := NewHook("smtp", "logdoc:25"); err == nil || hook != nil {
+		t.Fatalf("NewHook = %v, %v", hook, err)
+	}
+}
@@ -0,0 +1,14 @@
+module github.com/LogDoc-org/logdoc-go-appender/otel
+
+go 1.20
+
+require (
+	go.opentelemetry.io/otel/trace v1.24.0
+	go.uber.org/zap v1.24.0
+)
+
+require (
+	go.opentelemetry.io/otel v1.24.0 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+)
@@ -0,0 +1,53 @@
+// Package otelld tags LogDoc messages with trace_id and span_id of OpenTelemetry span. It is a separate module
+// depending on OpenTelemetry only, so that appender itself doesn't depend on OpenTelemetry.
+package otelld
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Keys of trace context fields.
+const (
+	TraceIDKey = "trace_id"
+	SpanIDKey  = "span_id"
+)
+
+// ExtractTraceContext returns trace_id and span_id of span in ctx, if span is valid and sampled, for
+// logrusld.Hook.ContextFields:
+//
+//	hook.ContextFields = otelld.ExtractTraceContext
+//	logger.WithContext(ctx).Info("request handled")
+func ExtractTraceContext(ctx context.Context) map[string]interface{} {
+	return extract(ctx, false)
+}
+
+// TraceContextExtractor is ExtractTraceContext which also tags messages of unsampled spans, if includeUnsampled
+// is set.
+func TraceContextExtractor(includeUnsampled bool) func(ctx context.Context) map[string]interface{} {
+	return func(ctx context.Context) map[string]interface{} {
+		return extract(ctx, includeUnsampled)
+	}
+}
+
+// ZapFields returns trace_id and span_id of span in ctx as zap fields, if span is valid and sampled, since zap
+// entries have no context:
+//
+//	logger.Info("request handled", otelld.ZapFields(ctx)...)
+func ZapFields(ctx context.Context) []zap.Field {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() {
+		return nil
+	}
+	return []zap.Field{zap.String(TraceIDKey, sc.TraceID().String()), zap.String(SpanIDKey, sc.SpanID().String())}
+}
+
+func extract(ctx context.Context, includeUnsampled bool) map[string]interface{} {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() && !includeUnsampled {
+		return nil
+	}
+	return map[string]interface{}{TraceIDKey: sc.TraceID().String(), SpanIDKey: sc.SpanID().String()}
+}
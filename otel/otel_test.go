@@ -0,0 +1,65 @@
+package otelld
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span context as recorded from a real request.
+const (
+	traceID = "0af7651916cd43dd8448eb211c80319c"
+	spanID  = "b7ad6b7169203331"
+)
+
+func spanContext(t *testing.T, flags trace.TraceFlags) context.Context {
+	t.Helper()
+	tid, err := trace.TraceIDFromHex(traceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, err := trace.SpanIDFromHex(spanID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid, TraceFlags: flags, Remote: true})
+	return trace.ContextWithSpanContext(context.Background(), sc)
+}
+
+func TestExtractTraceContext(t *testing.T) {
+	sampled := spanContext(t, trace.FlagsSampled)
+	unsampled := spanContext(t, 0)
+
+	if fields := ExtractTraceContext(sampled); fields[TraceIDKey] != traceID || fields[SpanIDKey] != spanID {
+		t.Fatalf("sampled = %v", fields)
+	}
+	if fields := ExtractTraceContext(unsampled); fields != nil {
+		t.Fatalf("unsampled = %v", fields)
+	}
+	if fields := ExtractTraceContext(context.Background()); fields != nil {
+		t.Fatalf("no span = %v", fields)
+	}
+	if fields := TraceContextExtractor(true)(unsampled); fields[TraceIDKey] != traceID {
+		t.Fatalf("unsampled included = %v", fields)
+	}
+	if fields := TraceContextExtractor(true)(context.Background()); fields != nil {
+		t.Fatalf("invalid span included = %v", fields)
+	}
+}
+
+func TestZapFields(t *testing.T) {
+	fields := ZapFields(spanContext(t, trace.FlagsSampled))
+	if len(fields) != 2 || fields[0].Key != TraceIDKey || fields[0].String != traceID || fields[1].String != spanID {
+		t.Fatalf("fields = %v", fields)
+	}
+	if fields := ZapFields(spanContext(t, 0)); fields != nil {
+		t.Fatalf("unsampled = %v", fields)
+	}
+}
+
+// Extractors fit logrusld.Hook.ContextFields without importing it.
+var (
+	_ func(ctx context.Context) map[string]interface{} = ExtractTraceContext
+	_ func(ctx context.Context) map[string]interface{} = TraceContextExtractor(true)
+)
@@ -1,6 +1,7 @@
 package zapld
 
 import (
+	"errors"
 	"fmt"
 	"github.com/LogDoc-org/logdoc-go-appender/common"
 	"go.uber.org/zap"
@@ -8,17 +9,370 @@ import (
 	"log"
 	"net"
 	"os"
-	"strconv"
+	"runtime"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var application string
 
+// appSource is where application comes from.
+var appSource common.AppSource
+
+// KubernetesApp sends name of Deployment or StatefulSet of pod in HOSTNAME in app field if neither app given to Init
+// nor LOGDOC_APP is set, see common.DefaultApp. It should be set before Init.
+var KubernetesApp bool
+
+// AppSource returns application name sent in app field and where it comes from, see common.ResolveApp. It is
+// logged by Init too.
+func AppSource() (string, common.AppSource) {
+	return application, appSource
+}
+
 var lgr *zap.Logger
 
 var connection net.Conn
 
+// localIP is local IP of connection, sent in ip field.
+var localIP string
+
+// SourceIP, if set, is sent in ip field instead of local IP of connection, e.g. for NAT.
+var SourceIP string
+
+// Hostname, if set, is sent in host field instead of os.Hostname(), e.g. for proxy. It should be set before Init.
+var Hostname string
+
+// hostname is sent in host field, the field is not sent if it is empty.
+var hostname string
+
+// LevelMapper, if set, returns LogDoc level name of message instead of LogDocLevel.
+// It should be set before Init.
+var LevelMapper func(level zapcore.Level) string
+
+// LevelNames, if set, are LogDoc level names of custom levels, e.g. zapcore.Level(-2) for trace, or standard ones
+// sent under another name. Level without name is sent with name of the nearest less severe standard or named level,
+// or as LogDocLevel if there is no such. LevelMapper, if set, is used instead. It should be set before Init.
+var LevelNames map[zapcore.Level]string
+
+// MessageFormatter, if set, returns msg of entry from msg being text of message without custom fields after "@@".
+// MessagePrefix, e.g. "[billing-worker] ", is then added in front of msg. Both should be set before Init.
+var MessageFormatter func(entry zapcore.Entry, msg string) string
+var MessagePrefix string
+
+// ReplaceField, if set, is called for msg, lvl, src, custom fields and fields of entry before encoding,
+// e.g. to rename or redact them; empty key drops the field. It should be set before Init.
+var ReplaceField common.ReplaceField
+
+// RedactKeys are keys of fields never sent as is, e.g. password, authorization, token and cookie: their values are
+// replaced with common.Redacted or by Redact, e.g. common.KeepLast(4). Keys are case-insensitive and match the whole
+// key, joined with namespaces, or its last part after ".". Fields are redacted after ReplaceField. Both should be
+// set before Init.
+var RedactKeys []string
+
+// Redact, if set, returns value sent instead of value of field with one of RedactKeys.
+var Redact func(key, value string) string
+
+var redactor *common.Redactor
+
+// Marshaler encodes structs, maps and slices in fields, json.Marshal if nil. If it fails, value is sent
+// formatted with %+v and a warning is logged. It should be set before Init.
+var Marshaler common.Marshaler
+
+// TimeFormat is format of tsrc field, common.DefaultTimeFormat if not set. It should be set before Init.
+var TimeFormat string
+
+// TimeLocation is time zone of tsrc field, UTC if nil.
+var TimeLocation *time.Location
+
+// SplitMultiline sends multi-line message as its first line in msg and the rest in body field, at most
+// MaxBodyLines lines (no limit if 0). By default line breaks are kept in msg.
+var SplitMultiline bool
+var MaxBodyLines int
+
+// StackTrace adds stacktrace field with stack of the logging goroutine, formatted like panic output, to messages
+// of StackTraceLevel and more severe, at most StackTraceDepth frames (32 if not set). Stack of field with StackTrace
+// method, e.g. error of github.com/pkg/errors, or of entry, if logger has zap.AddStacktrace, is sent instead.
+// Less severe messages skip stack capture entirely.
+var StackTrace bool
+var StackTraceLevel = zapcore.ErrorLevel
+var StackTraceDepth int
+
+// IncludeGoroutineID adds gid field with ID of the logging goroutine. Reading it costs microseconds per message,
+// see common.GoroutineID. It should be set before Init.
+var IncludeGoroutineID bool
+
+// loggingPackages are skipped in stack trace of logging goroutine, with appender functions called by them.
+var loggingPackages = []string{"go.uber.org/zap.", "go.uber.org/zap/"}
+
+// GroupSeparator joins namespace or object name with names of fields in it, "." if not set: fields after
+// zap.Namespace("http") are sent e.g. as http.method, nested namespaces as http.request.method.
+var GroupSeparator string
+
+// FlattenGroups sends fields of namespaces and objects without prefix, like GroupMode GroupIgnore in KV format.
+var FlattenGroups bool
+
+// Grouping is how fields of namespaces and objects are sent, see GroupMode.
+type Grouping int
+
+const (
+	GroupDefault   Grouping = iota // GroupNest in JSON format, GroupIgnore with FlattenGroups, GroupPrefix otherwise.
+	GroupPrefix                    // Keys get names of namespaces, e.g. http.client.ip, in JSON format too.
+	GroupIgnore                    // Names of namespaces are dropped, e.g. ip.
+	GroupIgnoreTop                 // Only names of top-level namespaces are dropped, e.g. client.ip.
+	GroupNest                      // Namespaces are nested objects in JSON format, GroupPrefix in KV format.
+)
+
+// GroupMode is how fields of namespaces, both of logger.With and of message, and objects are sent. Namespace
+// with empty name adds nothing, its fields are sent as fields of the enclosing one.
+var GroupMode Grouping
+
+func groupMode() Grouping {
+	switch {
+	case GroupMode == GroupNest && Format != common.FormatJSON:
+		return GroupPrefix
+	case GroupMode != GroupDefault:
+		return GroupMode
+	case Format == common.FormatJSON:
+		return GroupNest
+	case FlattenGroups:
+		return GroupIgnore
+	}
+	return GroupPrefix
+}
+
+func groupSeparator() string {
+	if GroupSeparator == "" {
+		return "."
+	}
+	return GroupSeparator
+}
+
+// DisableSanitize sends field keys and values as is, by default invalid UTF-8 and control characters are
+// replaced, see common.Sanitize.
+var DisableSanitize bool
+
+// MaxValueSize is max size of field value in bytes, common.DefaultMaxValueSize if 0, negative – no limit.
+var MaxValueSize int
+
+// ValueFormat is how durations, times and floats of fields are sent, e.g.
+// common.ValueFormat{Duration: common.DurationMillis} for dashboards of milliseconds.
+var ValueFormat common.ValueFormat
+
+// MaxFields limits number of fields of message other than ones written by appender, counted after namespaces
+// and objects are flattened: the rest, in order of keys, are dropped and fields_truncated field with their number
+// is added. No limit if 0. Dropped fields and cut values are counted in FieldsDropped and ValuesCut.
+var MaxFields int
+
+// AllowKeys and DenyKeys filter fields by keys joined with namespaces, e.g. DenyKeys internal.* keeps diagnostics
+// meant for local logs out of LogDoc, see common.KeyFilter. With AllowKeys only fields matching them are sent,
+// DenyKeys win. Fields are filtered after ReplaceField, before MaxFields, and counted in FieldsFiltered. Both should
+// be set before Init.
+var AllowKeys, DenyKeys []string
+
+var fieldsDropped, fieldsFiltered, valuesCut atomic.Uint64
+
+// FieldsDropped returns how many fields were dropped by MaxFields.
+func FieldsDropped() uint64 {
+	return fieldsDropped.Load()
+}
+
+// FieldsFiltered returns how many fields were dropped by AllowKeys and DenyKeys.
+func FieldsFiltered() uint64 {
+	return fieldsFiltered.Load()
+}
+
+// ValuesCut returns how many field values were cut to MaxValueSize.
+func ValuesCut() uint64 {
+	return valuesCut.Load()
+}
+
+// Event is entry with its fields passed to Filter and Hooks before it is encoded. Hooks may change it, e.g. add tenant field
+// looked up by another one.
+type Event struct {
+	Level   zapcore.Level
+	Message string // With custom fields after "@@", if any.
+	Time    time.Time
+	// Fields are fields of entry and ones added by With, encoded by zapcore.MapObjectEncoder: fields of namespaces
+	// and objects are in maps under their names.
+	Fields map[string]interface{}
+}
+
+// Filter, if set, is called with every entry before it is encoded; entry is dropped if it returns false and counted
+// in Filtered. See FilterByMessageSubstring, FilterByAttr, Not, And and Or. Filter should not change Event, entry
+// passes it if Filter panics. It should be set before Init.
+var Filter func(e *Event) bool
+
+// Hooks are called in order with every entry before it is encoded. They may change Event; hook returning false
+// drops the entry, such entries are counted in Vetoed. Panicking hook is skipped. It should be set before Init.
+var Hooks []func(e *Event) (keep bool)
+
+var filtered, vetoed atomic.Uint64
+
+// Filtered returns how many entries were dropped by Filter.
+func Filtered() uint64 {
+	return filtered.Load()
+}
+
+// Vetoed returns how many entries were dropped by Hooks.
+func Vetoed() uint64 {
+	return vetoed.Load()
+}
+
+// FilterByMessageSubstring returns Filter reporting whether message contains substr, e.g.
+// Not(FilterByMessageSubstring("GET /healthz")) drops health checks.
+func FilterByMessageSubstring(substr string) func(e *Event) bool {
+	return func(e *Event) bool { return strings.Contains(e.Message, substr) }
+}
+
+// FilterByAttr returns Filter reporting whether event has field key with value, compared as they are sent, so that
+// 200 matches "200" too. Fields of namespaces are matched by key joined with namespaces by ".", e.g. http.method.
+func FilterByAttr(key string, value interface{}) func(e *Event) bool {
+	want := common.FormatValue(value)
+	return func(e *Event) bool {
+		v, ok := lookupField(e.Fields, key)
+		return ok && common.FormatValue(v) == want
+	}
+}
+
+// lookupField returns field key of fields or of namespace its prefix before "." names.
+func lookupField(fields map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := fields[key]; ok {
+		return v, true
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] != '.' {
+			continue
+		}
+		if group, ok := fields[key[:i]].(map[string]interface{}); ok {
+			if v, ok := lookupField(group, key[i+1:]); ok {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Not returns Filter negating f.
+func Not(f func(e *Event) bool) func(e *Event) bool {
+	return func(e *Event) bool { return !f(e) }
+}
+
+// And returns Filter reporting whether all filters pass, evaluated in order until one fails.
+func And(filters ...func(e *Event) bool) func(e *Event) bool {
+	return func(e *Event) bool {
+		for _, f := range filters {
+			if !f(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or returns Filter reporting whether any of filters passes, evaluated in order until one passes.
+func Or(filters ...func(e *Event) bool) func(e *Event) bool {
+	return func(e *Event) bool {
+		for _, f := range filters {
+			if f(e) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// runHooks passes event to Hooks in order and reports whether it should be sent.
+func runHooks(event *Event) bool {
+	for _, hook := range Hooks {
+		keep := true
+		if protect("Hooks", func() { keep = hook(event) }) && !keep {
+			vetoed.Add(1)
+			return false
+		}
+	}
+	return true
+}
+
+// Protocol is framing of messages, common.DefaultProtocol if not set. It should be set before Init.
+var Protocol common.Protocol
+
+// Format is payload of frame: key=value fields (common.FormatKV) by default, or JSON object made by Marshaler
+// (common.FormatJSON), where namespaces and objects stay nested objects.
+var Format common.Format
+
+// Encoding is encoding of fields in frame, common.TextEncoding if not set. common.BinaryEncoding sends
+// any bytes without escaping, with DisableSanitize, for server which accepts it.
+var Encoding common.FieldEncoder
+
+// Checksum adds checksum field computed over the other fields of message, so that LogDoc side detects messages
+// changed or cut, see common.VerifyChecksum. It is not supported with FormatJSON, Init fails then.
+var Checksum common.Checksum
+
+// MaxEventBytes limits size of message frame: the longest values of msg and fields are cut, and truncated=true
+// and original_size fields are added. Messages which are still too big are dropped. No limit if 0.
+var MaxEventBytes int
+
+// FieldCollision is what is done with fields named as fields written by appender (common.ReservedFields):
+// by default they are sent with "attr_" prefix.
+var FieldCollision common.Collision
+
+// FieldNames renames fields written by appender, e.g. lvl to level, in both formats. Fields named as renamed
+// fields get "attr_" prefix, see FieldCollision. It should be set before Init.
+var FieldNames common.FieldNames
+
+// SourceFormat is format of src field, pkg.Func:line by default.
+var SourceFormat common.SourceFormat
+
+// TrimPrefixes, e.g. build directory or module path, are stripped from function and file of src field,
+// the first one they start with.
+var TrimPrefixes []string
+
+// CallerSkip reports in src caller of the frame zap reports, through CallerSkip more frames, and skips frames of
+// CallerSkipPackages, e.g. "github.com/acme/log" of logging facade. Unlike zap.AddCallerSkip it changes src of
+// LogDoc only. Frame reported by zap is kept if the stack is shorter.
+var CallerSkip int
+var CallerSkipPackages []string
+
+// StaticFields are added to every message after fields of entry, e.g. env, region and version. They are
+// encoded once by Init, names of fields written by appender are not allowed. StaticFieldsFunc, if set, is called
+// for every message for fields which change rarely; its keys set in StaticFields are ignored.
+var StaticFields map[string]string
+var StaticFieldsFunc func() map[string]string
+
+// IncludeBuildInfo adds build information to static fields: vcs.revision, vcs.time, go.version and module.version,
+// read by Init and renamed by BuildInfoKeys, see common.BuildFields. Fields missing in build information are not
+// sent; StaticFields with the same keys win. Both should be set before Init.
+var IncludeBuildInfo bool
+var BuildInfoKeys common.BuildInfoKeys
+
+// IncludeKubernetes adds metadata of Kubernetes pod to static fields: k8s.pod, k8s.namespace, k8s.node and
+// k8s.container_id, resolved by Init with common.KubernetesFields; missing ones are not sent. It should be set
+// before Init.
+var IncludeKubernetes bool
+
+// readBuildInfo reads build information for IncludeBuildInfo, replaced by tests.
+var readBuildInfo = debug.ReadBuildInfo
+
+// staticRecord and staticEncoded are StaticFields prepared by Init.
+var staticRecord []common.Field
+var staticEncoded []byte
+
+// Sequence adds seq field numbering messages from 1 since process start, so that gaps show lost messages.
+var Sequence bool
+
+var sequence atomic.Uint64
+
+// EventID, if set, returns ID of message sent in event_id field, e.g. common.NewEventID for UUIDv7, so receivers
+// can drop duplicates.
+var EventID func() string
+
+// writeMu serializes writes, so frames of messages logged concurrently are not interleaved.
+var writeMu sync.Mutex
+
 func GetLogger() *zap.Logger {
 	return lgr
 }
@@ -53,6 +407,23 @@ func Init(config *zap.Config, initialLevel zapcore.Level, proto string, address
 		cfg = *config
 	}
 
+	if Checksum != common.ChecksumNone && Format == common.FormatJSON {
+		log.Print("Контрольная сумма не поддерживается в формате JSON")
+		return nil, errors.New("LogDoc Checksum is not supported with FormatJSON")
+	}
+	if err := FieldNames.Check(); err != nil {
+		log.Print("Ошибка конфигурации имен полей")
+		return nil, err
+	}
+	if err := (common.KeyFilter{AllowKeys: AllowKeys, DenyKeys: DenyKeys}).Check(); err != nil {
+		log.Print("Ошибка конфигурации фильтра полей")
+		return nil, err
+	}
+	if err := FieldNames.CheckStaticFields(StaticFields); err != nil {
+		log.Print("Ошибка конфигурации статических полей")
+		return nil, err
+	}
+
 	logger, err := cfg.Build()
 	if err != nil {
 		log.Print("Ошибка создания конфигурации")
@@ -66,57 +437,486 @@ func Init(config *zap.Config, initialLevel zapcore.Level, proto string, address
 	}
 
 	connection = conn
+	localIP = ""
+	hostname = Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	if host, _, err := net.SplitHostPort(conn.LocalAddr().String()); err == nil {
+		localIP = host
+	}
+	static := StaticFields
+	if IncludeBuildInfo || IncludeKubernetes {
+		var build, kubernetes map[string]string
+		if IncludeBuildInfo {
+			build = common.BuildFields(readBuildInfo, BuildInfoKeys)
+		}
+		if IncludeKubernetes {
+			kubernetes = common.KubernetesFields(nil)
+		}
+		static = common.MergeStaticFields(build, kubernetes, StaticFields)
+	}
+	staticRecord = common.StaticFields(static)
+	staticEncoded = nil
+	for _, f := range staticRecord {
+		writeValue(f.Key, f.Value, &staticEncoded)
+	}
+
+	redactor = newRedactor()
+
+	// Events without app are not grouped by LogDoc.
+	application, appSource = common.ResolveApp(app, KubernetesApp)
 
-	logger = logger.WithOptions(zap.Hooks(sendLogDocEvent))
+	level := cfg.Level
+	logger = logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(c, &core{LevelEnabler: level})
+	}))
 
-	logger.Info("LogDoc subsystem initialized successfully")
+	logger.Info("LogDoc subsystem initialized successfully", zap.String("app_source", string(appSource)))
 
-	application = app
 	lgr = logger
 
 	return logger, nil
 }
 
-func sendLogDocEvent(entry zapcore.Entry) error {
-	header := []byte{6, 3}
+// core sends entries to LogDoc with fields of the call and fields added by With, next to cores of the logger.
+type core struct {
+	zapcore.LevelEnabler
+	fields []zapcore.Field
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	return &core{LevelEnabler: c.LevelEnabler, fields: append(c.fields[:len(c.fields):len(c.fields)], fields...)}
+}
+
+func (c *core) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *core) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return sendLogDocEvent(entry, append(c.fields[:len(c.fields):len(c.fields)], fields...))
+}
+
+func (c *core) Sync() error {
+	return nil
+}
+
+func sendLogDocEvent(entry zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	if Filter != nil || len(Hooks) > 0 {
+		event := &Event{Level: entry.Level, Message: entry.Message, Time: entry.Time, Fields: enc.Fields}
+		if Filter != nil {
+			keep := true
+			if protect("Filter", func() { keep = Filter(event) }) && !keep {
+				filtered.Add(1)
+				return nil
+			}
+		}
+		if !runHooks(event) {
+			return nil
+		}
+		entry.Level, entry.Message, entry.Time, enc.Fields = event.Level, event.Message, event.Time, event.Fields
+	}
 	app := application
-	var lvl string
-	if strings.Compare(entry.Level.String(), "warning") == 0 {
-		lvl = "warn"
-	} else {
-		lvl = entry.Level.String()
+	lvl := levelName(entry.Level)
+	if LevelMapper != nil {
+		mapped := lvl
+		if protect("LevelMapper", func() { mapped = LevelMapper(entry.Level) }) {
+			lvl = mapped
+		}
+	}
+	ip := SourceIP
+	if ip == "" {
+		ip = localIP
 	}
-	ip := connection.RemoteAddr().String()
 	pid := fmt.Sprintf("%d", os.Getpid())
-	src := entry.Caller.Function + ":" + strconv.Itoa(entry.Caller.Line)
+	var src string
+	if entry.Caller.Defined && (CallerSkip > 0 || len(CallerSkipPackages) > 0) {
+		reported := runtime.Frame{Function: entry.Caller.Function, File: entry.Caller.File, Line: entry.Caller.Line}
+		if caller, ok := common.CallerAbove(reported, CallerSkip, CallerSkipPackages); ok {
+			entry.Caller = zapcore.EntryCaller{Defined: true, PC: caller.PC, File: caller.File, Line: caller.Line, Function: caller.Function}
+		}
+	}
+	if entry.Caller.Defined {
+		// Caller is set only if logger reports it, see zap.AddCaller.
+		src = common.SourceTrimmed(entry.Caller.Function, entry.Caller.File, entry.Caller.Line, SourceFormat, TrimPrefixes)
+	}
 
-	t := time.Now()
-	tsrc := t.Format("060201150405.000") + "\n"
+	tsrc := common.TimestampIn(entry.Time, TimeFormat, TimeLocation)
 
-	// Пишем заголовок
-	result := header
-	// Записываем само сообщение
-	common.WritePair("msg", entry.Message, &result)
-	// Обрабатываем кастомные поля
-	common.ProcessCustomFields(entry.Message, &result)
+	names := FieldNames
+	var record []common.Field
+	var userAt int
+	// Сообщение и кастомные поля из него
+	for i, f := range common.MessageFields(entry.Message) {
+		if i == 0 {
+			text, body := formatMessage(entry, f.Value.(string)), ""
+			if SplitMultiline {
+				text, body = common.SplitLines(text, MaxBodyLines)
+			}
+			record = appendField(record, names.Name(f.Key), text)
+			if body != "" {
+				record = appendField(record, names.Name("body"), body)
+			}
+			userAt = len(record)
+		} else {
+			record = appendUserField(record, f.Key, f.Value)
+		}
+	}
+	// Поля записи, включая добавленные через With; поля после Namespace и объектов получают префикс "namespace."
+	record = appendFields(record, "", true, enc.Fields)
+	if StackTrace && entry.Level >= StackTraceLevel {
+		if _, ok := enc.Fields[common.StackTraceKey]; !ok {
+			record = appendUserField(record, common.StackTraceKey, stackTrace(entry, fields))
+		}
+	}
+	if IncludeGoroutineID {
+		if _, ok := enc.Fields[common.GoroutineIDKey]; !ok {
+			record = appendUserField(record, common.GoroutineIDKey, common.GoroutineID())
+		}
+	}
+	if MaxFields > 0 {
+		user, dropped := common.LimitFields(record[userAt:], MaxFields)
+		record = append(record[:userAt], user...)
+		fieldsDropped.Add(uint64(dropped))
+	}
+	// Статические поля, в формате KV записываются заранее закодированными
+	staticAt := len(record)
+	if Format == common.FormatJSON {
+		record = append(record, staticRecord...)
+	}
+	record = appendDynamicFields(record)
 	// Служебные поля
-	common.WritePair("app", app, &result)
-	common.WritePair("tsrc", tsrc, &result)
-	common.WritePair("lvl", lvl, &result)
-	common.WritePair("ip", ip, &result)
-	common.WritePair("pid", pid, &result)
-	common.WritePair("src", src, &result)
+	record = append(record, common.Field{Key: names.Name("app"), Value: common.PairValue(app)})
+	record = append(record, common.Field{Key: names.Name("tsrc"), Value: tsrc})
+	record = appendField(record, names.Name("lvl"), lvl)
+	record = append(record, common.Field{Key: names.Name("ip"), Value: ip})
+	if hostname != "" {
+		record = append(record, common.Field{Key: names.Name("host"), Value: hostname})
+	}
+	record = append(record, common.Field{Key: names.Name("pid"), Value: pid})
+	if Sequence {
+		record = append(record, common.Field{Key: names.Name("seq"), Value: fmt.Sprintf("%d", sequence.Add(1))})
+	}
+	if EventID != nil {
+		var id string
+		if protect("EventID", func() { id = EventID() }) && id != "" {
+			record = append(record, common.Field{Key: names.Name("event_id"), Value: id})
+		}
+	}
+	if src != "" {
+		record = appendField(record, names.Name("src"), src)
+	}
 
-	// Финальный байт, завершаем
-	result = append(result, []byte("\n")...)
+	var result []byte
+	if Format == common.FormatJSON {
+		result = encodeJSON(record)
+	} else {
+		for i, f := range record {
+			if i == staticAt {
+				result = append(result, staticEncoded...)
+			}
+			writeValue(f.Key, f.Value, &result)
+		}
+	}
+	if MaxEventBytes > 0 {
+		// JSON is not truncated, only checked.
+		var ok bool
+		limit := MaxEventBytes - Protocol.Overhead() - common.ChecksumSize(Encoding, Checksum)
+		if result, ok = names.TruncateWith(Encoding, result, limit); !ok {
+			log.Print("Сообщение больше MaxEventBytes не отправлено, ", len(result)+Protocol.Overhead(), " байт")
+			return nil
+		}
+	}
+	if Checksum != common.ChecksumNone && Format != common.FormatJSON {
+		result, _ = common.AppendChecksum(Encoding, result, Checksum)
+	}
 
-	_, err := connection.Write(result)
+	writeMu.Lock()
+	_, err := connection.Write(Protocol.Frame(result))
+	writeMu.Unlock()
 	if err != nil {
 		log.Print("Ошибка записи в соединение, ", err)
 	}
 	return nil
 }
 
+// LogDocLevel returns LogDoc name of zap level. DPanic is sent as error, since it panics only in development;
+// custom levels are sent as the nearest standard one.
+func LogDocLevel(level zapcore.Level) string {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return "debug"
+	case level == zapcore.InfoLevel:
+		return "info"
+	case level == zapcore.WarnLevel:
+		return "warn"
+	case level <= zapcore.DPanicLevel:
+		return "error"
+	case level == zapcore.PanicLevel:
+		return "panic"
+	default:
+		return "fatal"
+	}
+}
+
+// levelName returns LogDoc name of level, see LevelNames.
+func levelName(level zapcore.Level) string {
+	if len(LevelNames) == 0 {
+		return LogDocLevel(level)
+	}
+	if name, ok := LevelNames[level]; ok {
+		return name
+	}
+	if level >= zapcore.DebugLevel && level <= zapcore.FatalLevel {
+		return LogDocLevel(level)
+	}
+	// Custom level: the nearest named level below it, or FatalLevel for levels above it.
+	nearest, found := zapcore.FatalLevel, level > zapcore.FatalLevel
+	for l := range LevelNames {
+		if l < level && (!found || l > nearest) {
+			nearest, found = l, true
+		}
+	}
+	if name, ok := LevelNames[nearest]; ok && found {
+		return name
+	}
+	return LogDocLevel(level)
+}
+
+// appendFields appends encoded fields sorted by key, so that encoding is stable. Fields of namespaces and
+// objects are sent according to GroupMode, top is set for fields outside of them.
+func appendFields(record []common.Field, prefix string, top bool, fields map[string]interface{}) []common.Field {
+	mode := groupMode()
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		group, ok := fields[k].(map[string]interface{})
+		switch {
+		case !ok:
+			record = appendUserField(record, prefix+k, fields[k])
+		case k == "":
+			record = appendFields(record, prefix, top, group)
+		case mode == GroupNest:
+			group, _ = nestGroups(group)
+			record = appendUserField(record, k, group)
+		case mode == GroupIgnore || mode == GroupIgnoreTop && top:
+			record = appendFields(record, prefix, false, group)
+		default:
+			record = appendFields(record, prefix+k+groupSeparator(), false, group)
+		}
+	}
+	return record
+}
+
+// nestGroups returns fields of namespace for JSON object, with fields of nested namespaces with empty name
+// moved to the enclosing one. Fields are copied only if there are such namespaces, then changed is set.
+func nestGroups(fields map[string]interface{}) (nested map[string]interface{}, changed bool) {
+	for k, v := range fields {
+		group, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		moved, groupChanged := nestGroups(group)
+		if k != "" && !groupChanged {
+			continue
+		}
+		if !changed {
+			nested = make(map[string]interface{}, len(fields))
+			for k, v := range fields {
+				nested[k] = v
+			}
+			changed = true
+		}
+		if k == "" {
+			delete(nested, "")
+			for k, v := range moved {
+				nested[k] = v
+			}
+		} else {
+			nested[k] = moved
+		}
+	}
+	if !changed {
+		return fields, false
+	}
+	return nested, true
+}
+
+// stackTrace returns stack trace of entry: of error or other field which carries it, see common.ValueStack,
+// of zap.AddStacktrace or of the logging goroutine.
+func stackTrace(entry zapcore.Entry, fields []zapcore.Field) string {
+	for _, f := range fields {
+		if pcs := common.ValueStack(f.Interface); pcs != nil {
+			return common.FormatStack(pcs, StackTraceDepth)
+		}
+	}
+	if entry.Stack != "" {
+		return entry.Stack
+	}
+	return common.CallerStack(loggingPackages, StackTraceDepth)
+}
+
+// appendDynamicFields appends fields returned by StaticFieldsFunc, except ones set in StaticFields.
+func appendDynamicFields(record []common.Field) []common.Field {
+	if StaticFieldsFunc == nil {
+		return record
+	}
+	var dynamic map[string]string
+	if !protect("StaticFieldsFunc", func() { dynamic = StaticFieldsFunc() }) {
+		return record
+	}
+	for _, f := range common.StaticFields(dynamic) {
+		if _, ok := StaticFields[f.Key]; ok {
+			continue
+		}
+		key := userKey(f.Key)
+		if key == "" {
+			log.Print("Поле ", f.Key, " зарезервировано LogDoc и не отправляется")
+			continue
+		}
+		record = append(record, common.Field{Key: key, Value: f.Value})
+	}
+	return record
+}
+
+// appendField appends field passed through ReplaceField.
+func appendField(record []common.Field, key string, value interface{}) []common.Field {
+	if ReplaceField != nil {
+		if key, value = replaceField(key, value); key == "" {
+			return record
+		}
+	}
+	return append(record, common.Field{Key: key, Value: value})
+}
+
+// appendUserField appends custom or entry field passed through ReplaceField and AllowKeys and DenyKeys, renamed
+// or dropped if its key is reserved, see FieldCollision, redacted by RedactKeys and formatted by ValueFormat.
+func appendUserField(record []common.Field, key string, value interface{}) []common.Field {
+	if ReplaceField != nil {
+		if key, value = replaceField(key, value); key == "" {
+			return record
+		}
+	}
+	if filter := (common.KeyFilter{AllowKeys: AllowKeys, DenyKeys: DenyKeys}); !filter.Empty() {
+		var keep bool
+		var filtered int
+		value, keep, filtered = filter.Filter(key, value)
+		fieldsFiltered.Add(uint64(filtered))
+		if !keep {
+			return record
+		}
+	}
+	name := userKey(key)
+	if name == "" {
+		log.Print("Поле ", key, " зарезервировано LogDoc и не отправляется")
+		return record
+	}
+	value, _ = redactor.Redact(key, value)
+	return append(record, common.Field{Key: name, Value: ValueFormat.Apply(value)})
+}
+
+// newRedactor returns Redactor of RedactKeys, value is Redacted entirely if Redact panics.
+func newRedactor() *common.Redactor {
+	var redact func(key, value string) string
+	if fn := Redact; fn != nil {
+		redact = func(key, value string) string {
+			result := common.Redacted
+			protect("Redact", func() { result = fn(key, value) })
+			return result
+		}
+	}
+	return common.NewRedactor(RedactKeys, redact)
+}
+
+// userKey returns key field is written with, see FieldCollision. With SplitMultiline body is reserved too.
+func userKey(key string) string {
+	if SplitMultiline && key == FieldNames.Name("body") {
+		if FieldCollision == common.CollisionDrop {
+			return ""
+		}
+		return common.ReservedPrefix + key
+	}
+	return FieldNames.UserKey(key, FieldCollision)
+}
+
+// encodeJSON encodes fields as JSON object with Marshaler.
+func encodeJSON(record []common.Field) []byte {
+	var result []byte
+	var err error
+	if !protect("Marshaler", func() { result, err = common.EncodeJSON(record, Marshaler) }) {
+		return common.EncodeJSONStrings(record)
+	}
+	if err != nil {
+		log.Print("Ошибка кодирования сообщения в JSON, ", err)
+	}
+	return result
+}
+
+// formatMessage returns msg passed through MessageFormatter, kept as is if it panics, with MessagePrefix.
+func formatMessage(entry zapcore.Entry, msg string) string {
+	if MessageFormatter != nil {
+		formatted := msg
+		if protect("MessageFormatter", func() { formatted = MessageFormatter(entry, msg) }) {
+			msg = formatted
+		}
+	}
+	return MessagePrefix + msg
+}
+
+// replaceField calls ReplaceField, field is kept as is if it panics.
+func replaceField(key string, value interface{}) (string, interface{}) {
+	newKey, newValue := key, value
+	if !protect("ReplaceField", func() { newKey, newValue = ReplaceField(key, value) }) {
+		return key, value
+	}
+	return newKey, newValue
+}
+
+// protect calls fn and logs its panic with stack, it returns false if fn panicked.
+func protect(callback string, fn func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Print("Паника в ", callback, ", ", r, "\n", string(debug.Stack()))
+			ok = false
+		}
+	}()
+	fn()
+	return true
+}
+
+func writeValue(key string, value interface{}, result *[]byte) {
+	var s string
+	var err error
+	if !protect("Marshaler", func() { s, err = ValueFormat.Format(value, Marshaler) }) {
+		s, err = fmt.Sprintf("%+v", value), nil
+	}
+	if err != nil {
+		log.Print("Ошибка кодирования поля ", key, ", ", err)
+	}
+	if !DisableSanitize {
+		maxSize := MaxValueSize
+		if maxSize == 0 {
+			maxSize = common.DefaultMaxValueSize
+		}
+		var cut bool
+		key = common.Sanitize(key, 0)
+		if s, cut = common.SanitizeValue(s, maxSize); cut {
+			valuesCut.Add(1)
+		}
+	}
+	common.Encoding(Encoding).WriteField(key, s, result)
+}
+
 func networkWriter(proto string, address string) (net.Conn, error) {
 	switch {
 	case proto == "tcp":
@@ -0,0 +1,1233 @@
+package zapld
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LogDoc-org/logdoc-go-appender/common"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// readFrames parses LogDoc frames from conn until it is closed.
+func readFrames(conn net.Conn, frames chan<- map[string]string, errs chan<- error) {
+	r := bufio.NewReader(conn)
+	for {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		if header[0] != 6 || header[1] != 3 {
+			errs <- fmt.Errorf("bad header %v", header)
+			return
+		}
+		fields := map[string]string{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\n" {
+				break
+			}
+			key, value, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "=")
+			if !ok {
+				// Complex pair: key line, 4 bytes of length and value.
+				size := make([]byte, 4)
+				if _, err := io.ReadFull(r, size); err != nil {
+					return
+				}
+				buf := make([]byte, binary.BigEndian.Uint32(size))
+				if _, err := io.ReadFull(r, buf); err != nil {
+					return
+				}
+				value = string(buf)
+			}
+			fields[key] = value
+		}
+		frames <- fields
+	}
+}
+
+// initLogger starts LogDoc server stub and initializes logger sending to it.
+func initLogger(t *testing.T, app string) (*zap.Logger, chan map[string]string, chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	frames := make(chan map[string]string, 10000)
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readFrames(conn, frames, errs)
+	}()
+
+	config := zap.Config{
+		Encoding:      "json",
+		Level:         zap.NewAtomicLevelAt(zap.DebugLevel),
+		EncoderConfig: zapcore.EncoderConfig{MessageKey: "msg"},
+	}
+	logger, err := Init(&config, zap.DebugLevel, "tcp", ln.Addr().String(), app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = connection.Close() })
+	return logger, frames, errs
+}
+
+// nextMessage returns the next frame, skipping Init notice.
+func nextMessage(t *testing.T, frames chan map[string]string, errs chan error) map[string]string {
+	t.Helper()
+	for {
+		select {
+		case f := <-frames:
+			if f["msg"] != "LogDoc subsystem initialized successfully" {
+				return f
+			}
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not received")
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	logger, frames, errs := initLogger(t, "fields")
+	logger.With(zap.String("request_id", "42")).With(zap.Namespace("http")).Info("handled", zap.Int("status", 200))
+
+	f := nextMessage(t, frames, errs)
+	if f["msg"] != "handled" || f["request_id"] != "42" || f["http.status"] != "200" {
+		t.Fatalf("frame = %v", f)
+	}
+
+	type user struct {
+		Name string `json:"name"`
+	}
+	logger.Info("kinds",
+		zap.Bool("ok", false),
+		zap.Float64("ratio", 0.25),
+		zap.Duration("elapsed", 1500*time.Millisecond),
+		zap.Time("at", time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)),
+		zap.Error(errors.New("timeout")),
+		zap.Any("user", user{"bob"}),
+		zap.Strings("tags", []string{"a", "b"}),
+	)
+	f = nextMessage(t, frames, errs)
+	for key, want := range map[string]string{
+		"ok":      "false",
+		"ratio":   "0.25",
+		"elapsed": "1.5s",
+		"at":      "2023-04-05T06:07:08Z",
+		"error":   "timeout",
+		"user":    `{"name":"bob"}`,
+		"tags":    `["a","b"]`,
+	} {
+		if f[key] != want {
+			t.Errorf("%s = %q, want %q", key, f[key], want)
+		}
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	logger, frames, errs := initLogger(t, "stress")
+
+	const workers, messages = 100, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				logger.Info(fmt.Sprintf("worker %d message %d", w, i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	want := map[string]bool{}
+	for w := 0; w < workers; w++ {
+		for i := 0; i < messages; i++ {
+			want[fmt.Sprintf("worker %d message %d", w, i)] = true
+		}
+	}
+	for len(want) > 0 {
+		f := nextMessage(t, frames, errs)
+		if !want[f["msg"]] || f["app"] != "stress" {
+			t.Fatalf("corrupted frame %v", f)
+		}
+		delete(want, f["msg"])
+	}
+}
+
+func TestLogDocLevel(t *testing.T) {
+	for _, tc := range []struct {
+		level zapcore.Level
+		want  string
+	}{
+		{zapcore.DebugLevel - 2, "debug"},
+		{zapcore.DebugLevel, "debug"},
+		{zapcore.InfoLevel, "info"},
+		{zapcore.WarnLevel, "warn"},
+		{zapcore.ErrorLevel, "error"},
+		{zapcore.DPanicLevel, "error"},
+		{zapcore.PanicLevel, "panic"},
+		{zapcore.FatalLevel, "fatal"},
+		{zapcore.FatalLevel + 3, "fatal"},
+	} {
+		if got := LogDocLevel(tc.level); got != tc.want {
+			t.Errorf("LogDocLevel(%d) = %q, want %q", tc.level, got, tc.want)
+		}
+	}
+}
+
+func TestLevelMapper(t *testing.T) {
+	LevelMapper = func(level zapcore.Level) string { return "custom-" + LogDocLevel(level) }
+	defer func() { LevelMapper = nil }()
+	logger, frames, errs := initLogger(t, "levels")
+	logger.Warn("mapped")
+	if f := nextMessage(t, frames, errs); f["lvl"] != "custom-warn" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	logger, frames, errs := initLogger(t, "time")
+	entry := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Date(2023, 4, 5, 6, 7, 8, 9e6, time.UTC), Message: "timed"}
+	if err := logger.Core().Write(entry, nil); err != nil {
+		t.Fatal(err)
+	}
+	if f := nextMessage(t, frames, errs); f["tsrc"] != "230504060708.009" {
+		t.Fatalf("frame = %v", f)
+	}
+
+	TimeLocation = time.FixedZone("UTC+3", 3*3600)
+	defer func() { TimeLocation = nil }()
+	if err := logger.Core().Write(entry, nil); err != nil {
+		t.Fatal(err)
+	}
+	if f := nextMessage(t, frames, errs); f["tsrc"] != "230504090708.009" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestMarshaler(t *testing.T) {
+	type credentials struct {
+		User     string
+		Password string
+	}
+	Marshaler = func(v interface{}) ([]byte, error) {
+		if c, ok := v.(credentials); ok {
+			return []byte(c.User + ":***"), nil
+		}
+		return nil, errors.New("unsupported")
+	}
+	defer func() { Marshaler = nil }()
+	logger, frames, errs := initLogger(t, "marshaler")
+	logger.Info("login", zap.Any("auth", credentials{"bob", "secret"}), zap.Any("ids", []int{1, 2}))
+	f := nextMessage(t, frames, errs)
+	if f["auth"] != "bob:***" || f["ids"] != "[1 2]" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestReplaceField(t *testing.T) {
+	ReplaceField = func(key string, value interface{}) (string, interface{}) {
+		if key == "http.password" {
+			return "", nil
+		}
+		return key, value
+	}
+	defer func() { ReplaceField = nil }()
+	logger, frames, errs := initLogger(t, "replace")
+	logger.Info("login", zap.Namespace("http"), zap.String("user", "bob"), zap.String("password", "secret"))
+	f := nextMessage(t, frames, errs)
+	if _, ok := f["http.password"]; ok || f["http.user"] != "bob" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	logger, frames, errs := initLogger(t, "ip")
+	logger.Info("client address")
+	if f := nextMessage(t, frames, errs); f["ip"] != "127.0.0.1" {
+		t.Fatalf("frame = %v", f)
+	}
+
+	SourceIP = "203.0.113.7"
+	defer func() { SourceIP = "" }()
+	logger.Info("behind NAT")
+	if f := nextMessage(t, frames, errs); f["ip"] != "203.0.113.7" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestWithoutCaller(t *testing.T) {
+	logger, frames, errs := initLogger(t, "caller")
+	logger.WithOptions(zap.WithCaller(false)).Info("no caller")
+	if f := nextMessage(t, frames, errs); f["msg"] != "no caller" || f["src"] != "" {
+		t.Fatalf("frame = %v", f)
+	}
+	logger.WithOptions(zap.WithCaller(true)).Info("caller")
+	if f := nextMessage(t, frames, errs); !strings.Contains(f["src"], "TestWithoutCaller:") {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestSourceLine(t *testing.T) {
+	logger, frames, errs := initLogger(t, "source")
+	SourceFormat = common.SourceFile
+	defer func() { SourceFormat = common.SourceFunc }()
+	_, file, line, _ := runtime.Caller(0)
+	logger.Info("caller")
+	want := fmt.Sprintf("%s:%d", file, line+1)
+	if f := nextMessage(t, frames, errs); f["src"] != want {
+		t.Fatalf("src = %q, want %q", f["src"], want)
+	}
+}
+
+func TestSourceFormats(t *testing.T) {
+	logger, frames, errs := initLogger(t, "source")
+	defer func() { SourceFormat, TrimPrefixes = common.SourceFunc, nil }()
+	_, file, _, _ := runtime.Caller(0)
+	dir := filepath.Dir(filepath.Dir(file)) + "/"
+	tests := []struct {
+		format       common.SourceFormat
+		trimPrefixes []string
+		want         string
+	}{
+		{common.SourceFunc, nil, "github.com/LogDoc-org/logdoc-go-appender/zap.TestSourceFormats:%d"},
+		{common.SourceFunc, []string{"github.com/LogDoc-org/logdoc-go-appender/"}, "zap.TestSourceFormats:%d"},
+		{common.SourceShortFunc, nil, "zap.TestSourceFormats:%d"},
+		{common.SourceFile, []string{dir}, "zap/zap_test.go:%d"},
+		{common.SourceRelFile, nil, "zap/zap_test.go:%d"},
+		{common.SourceRelFile, []string{filepath.Dir(file) + "/"}, "zap_test.go:%d"},
+	}
+	for _, tc := range tests {
+		SourceFormat, TrimPrefixes = tc.format, tc.trimPrefixes
+		_, _, line, _ := runtime.Caller(0)
+		logger.Info("caller")
+		want := fmt.Sprintf(tc.want, line+1)
+		if f := nextMessage(t, frames, errs); f["src"] != want {
+			t.Errorf("src = %q, want %q", f["src"], want)
+		}
+	}
+}
+
+// Two-level logging facade.
+func facadeInfo(logger *zap.Logger, msg string) {
+	facadeLog(logger, msg)
+}
+
+func facadeLog(logger *zap.Logger, msg string) {
+	logger.Info(msg)
+}
+
+func TestCallerSkip(t *testing.T) {
+	logger, frames, errs := initLogger(t, "skip")
+	logger = logger.WithOptions(zap.AddCaller())
+	SourceFormat, CallerSkip = common.SourceShortFunc, 2
+	defer func() { SourceFormat, CallerSkip, CallerSkipPackages = common.SourceFunc, 0, nil }()
+
+	_, _, line, _ := runtime.Caller(0)
+	facadeInfo(logger, "wrapped")
+	if f, want := nextMessage(t, frames, errs), fmt.Sprintf("zap.TestCallerSkip:%d", line+1); f["src"] != want {
+		t.Fatalf("src = %q, want %q", f["src"], want)
+	}
+
+	CallerSkip, CallerSkipPackages = 0, []string{"github.com/LogDoc-org/logdoc-go-appender/zap"}
+	facadeInfo(logger, "package skipped")
+	if f := nextMessage(t, frames, errs); !strings.HasPrefix(f["src"], "testing.tRunner:") {
+		t.Fatalf("src = %q", f["src"])
+	}
+
+	CallerSkip, CallerSkipPackages = 1000, nil
+	facadeInfo(logger, "short stack")
+	if f := nextMessage(t, frames, errs); !strings.HasPrefix(f["src"], "zap.facadeLog:") {
+		t.Fatalf("src of short stack = %q", f["src"])
+	}
+}
+
+func TestHostname(t *testing.T) {
+	logger, frames, errs := initLogger(t, "host")
+	logger.Info("resolved")
+	if name, err := os.Hostname(); err == nil {
+		if f := nextMessage(t, frames, errs); f["host"] != name {
+			t.Fatalf("host = %q, want %q", f["host"], name)
+		}
+	}
+
+	Hostname = "edge-proxy"
+	defer func() { Hostname = "" }()
+	logger, frames, errs = initLogger(t, "host")
+	logger.Info("override")
+	if f := nextMessage(t, frames, errs); f["host"] != "edge-proxy" {
+		t.Fatalf("host = %q, want Hostname", f["host"])
+	}
+}
+
+func TestDynamicLevel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	frames := make(chan map[string]string, 100)
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readFrames(conn, frames, errs)
+	}()
+
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	config := zap.Config{Encoding: "json", Level: level, EncoderConfig: zapcore.EncoderConfig{MessageKey: "msg"}}
+	logger, err := Init(&config, zap.InfoLevel, "tcp", ln.Addr().String(), "level")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	logger.Debug("dropped")
+	level.SetLevel(zap.DebugLevel)
+	logger.Debug("sent")
+	if f := nextMessage(t, frames, errs); f["msg"] != "sent" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestApp(t *testing.T) {
+	logger, frames, errs := initLogger(t, "")
+	logger.Info("default app")
+	if f := nextMessage(t, frames, errs); f["app"] != filepath.Base(os.Args[0]) {
+		t.Fatalf("app = %q, want executable name", f["app"])
+	}
+	logger, frames, errs = initLogger(t, "billing")
+	logger.Info("app", zap.String("app", "other"))
+	if f := nextMessage(t, frames, errs); f["app"] != "billing" || f["attr_app"] != "other" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestReservedFields(t *testing.T) {
+	logger, frames, errs := initLogger(t, "reserved")
+	Sequence = true
+	defer func() { Sequence = false }()
+	for _, key := range common.ReservedFields {
+		logger.Info("reserved", zap.String(key, "other"))
+		f := nextMessage(t, frames, errs)
+		if f["attr_"+key] != "other" || f["app"] != "reserved" || f["lvl"] != "info" || f["seq"] == "" {
+			t.Fatalf("%s: frame = %v", key, f)
+		}
+	}
+
+	FieldCollision = common.CollisionDrop
+	defer func() { FieldCollision = common.CollisionPrefix }()
+	logger.Info("reserved", zap.String("app", "other"))
+	if f := nextMessage(t, frames, errs); f["app"] != "reserved" || f["attr_app"] != "" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestFieldNames(t *testing.T) {
+	FieldNames = common.FieldNames{Message: "message", Level: "level", Source: "source"}
+	defer func() { FieldNames = common.FieldNames{} }()
+	logger, frames, errs := initLogger(t, "names")
+	logger.Info("renamed", zap.String("level", "custom"))
+	f := nextMessage(t, frames, errs)
+	if f["message"] == "LogDoc subsystem initialized successfully" {
+		f = nextMessage(t, frames, errs)
+	}
+	if f["message"] != "renamed" || f["level"] != "info" || f["attr_level"] != "custom" {
+		t.Fatalf("frame = %v", f)
+	}
+	for _, key := range []string{"msg", "lvl", "src"} {
+		if _, ok := f[key]; ok {
+			t.Fatalf("old key %s in %v", key, f)
+		}
+	}
+}
+
+func TestMultilineMessage(t *testing.T) {
+	SplitMultiline = true
+	defer func() { SplitMultiline = false }()
+	logger, frames, errs := initLogger(t, "multiline")
+	stack := "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n"
+	logger.Error("request failed\nSELECT *\nFROM users", zap.Error(errors.New(stack)))
+	f := nextMessage(t, frames, errs)
+	if f["msg"] != "request failed" || f["body"] != "SELECT *\nFROM users" || f["error"] != stack {
+		t.Fatalf("frame = %q", f)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	Checksum = common.ChecksumCRC32
+	defer func() { Checksum = common.ChecksumNone }()
+	logger, frames, errs := initLogger(t, "checksum")
+	logger.Info("verified", zap.Int("status", 200))
+	f := nextMessage(t, frames, errs)
+	var fields []common.Field
+	for k, v := range f {
+		fields = append(fields, common.Field{Key: k, Value: v})
+	}
+	if err := common.VerifyChecksum(fields); err != nil {
+		t.Fatalf("frame = %q: %v", f, err)
+	}
+
+	Format = common.FormatJSON
+	defer func() { Format = common.FormatKV }()
+	if _, err := Init(nil, zap.DebugLevel, "tcp", "127.0.0.1:1", "checksum"); err == nil || !strings.Contains(err.Error(), "Checksum") {
+		t.Fatalf("Init error = %v, want Checksum error", err)
+	}
+}
+
+func TestEventID(t *testing.T) {
+	EventID = common.NewEventID
+	defer func() { EventID = nil }()
+	logger, frames, errs := initLogger(t, "event")
+	logger.Info("first")
+	logger.Info("second")
+	first, second := nextMessage(t, frames, errs), nextMessage(t, frames, errs)
+	if len(first["event_id"]) != 36 || first["event_id"] == second["event_id"] {
+		t.Fatalf("event_id = %q, %q", first["event_id"], second["event_id"])
+	}
+}
+
+func TestStaticFields(t *testing.T) {
+	StaticFields = map[string]string{"app": "other"}
+	defer func() { StaticFields, StaticFieldsFunc = nil, nil }()
+	if _, err := Init(nil, zap.DebugLevel, "tcp", "127.0.0.1:1", "static"); err == nil || !strings.Contains(err.Error(), "app") {
+		t.Fatalf("Init error = %v, want reserved field", err)
+	}
+
+	StaticFields = map[string]string{"env": "prod", "region": "eu-1"}
+	StaticFieldsFunc = func() map[string]string { return map[string]string{"cohort": "b", "env": "dev"} }
+	logger, frames, errs := initLogger(t, "static")
+	logger.Info("static", zap.Int("status", 200))
+	f := nextMessage(t, frames, errs)
+	if f["env"] != "prod" || f["region"] != "eu-1" || f["cohort"] != "b" || f["status"] != "200" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	defer func(read func() (*debug.BuildInfo, bool)) { readBuildInfo = read }(readBuildInfo)
+	readBuildInfo = func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{GoVersion: "go1.20.4", Main: debug.Module{Path: "command-line-arguments", Version: "(devel)"}}, true
+	}
+	IncludeBuildInfo, BuildInfoKeys = true, common.BuildInfoKeys{GoVersion: "go"}
+	defer func() { IncludeBuildInfo, BuildInfoKeys = false, common.BuildInfoKeys{} }()
+	logger, frames, errs := initLogger(t, "build")
+	logger.Info("build")
+	f := nextMessage(t, frames, errs)
+	if _, ok := f["module.version"]; f["go"] != "go1.20.4" || ok {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestKubernetesFields(t *testing.T) {
+	t.Setenv("POD_NAME", "billing-7d9f8b6c5-x2kqz")
+	t.Setenv("POD_NAMESPACE", "payments")
+	t.Setenv("NODE_NAME", "")
+	IncludeKubernetes = true
+	defer func() { IncludeKubernetes = false }()
+	logger, frames, errs := initLogger(t, "pod")
+	logger.Info("pod")
+	f := nextMessage(t, frames, errs)
+	if _, ok := f["k8s.node"]; f["k8s.pod"] != "billing-7d9f8b6c5-x2kqz" || f["k8s.namespace"] != "payments" || ok {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	logger, frames, errs := initLogger(t, "groups")
+	request := zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("method", "GET")
+		return enc.AddObject("url", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("path", "/")
+			return nil
+		}))
+	})
+	child := logger.With(zap.Namespace("http"), zap.String("method", "POST"))
+	child.Info("grouped", zap.Namespace("client"), zap.String("method", "PUT"), zap.Object("request", request))
+	f := nextMessage(t, frames, errs)
+	want := map[string]string{
+		"http.method":                  "POST",
+		"http.client.method":           "PUT",
+		"http.client.request.method":   "GET",
+		"http.client.request.url.path": "/",
+	}
+	for k, v := range want {
+		if f[k] != v {
+			t.Errorf("%s = %q, want %q in %v", k, f[k], v, f)
+		}
+	}
+
+	GroupSeparator = "_"
+	logger.Info("grouped", zap.Namespace("http"), zap.String("method", "GET"))
+	if f := nextMessage(t, frames, errs); f["http_method"] != "GET" {
+		t.Errorf("frame = %v", f)
+	}
+	GroupSeparator = ""
+
+	FlattenGroups = true
+	defer func() { FlattenGroups = false }()
+	logger.Info("flat", zap.Namespace("http"), zap.String("status", "200"))
+	if f := nextMessage(t, frames, errs); f["status"] != "200" {
+		t.Errorf("frame = %v", f)
+	}
+}
+
+func TestGroupMode(t *testing.T) {
+	defer func() { GroupMode, FlattenGroups, Format = GroupDefault, false, common.FormatKV }()
+	client := zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("addr", "10.0.0.1")
+		return nil
+	})
+	fields := []zap.Field{zap.Namespace("http"), zap.String("method", "GET"), zap.Namespace(""), zap.Object("client", client)}
+	tests := []struct {
+		name    string
+		mode    Grouping
+		flatten bool
+		format  common.Format
+		want    string
+	}{
+		{"default", GroupDefault, false, common.FormatKV, `http.client.addr=10.0.0.1 http.method=GET`},
+		{"default flatten", GroupDefault, true, common.FormatKV, `addr=10.0.0.1 method=GET`},
+		{"prefix", GroupPrefix, true, common.FormatKV, `http.client.addr=10.0.0.1 http.method=GET`},
+		{"ignore", GroupIgnore, false, common.FormatKV, `addr=10.0.0.1 method=GET`},
+		{"ignore top", GroupIgnoreTop, false, common.FormatKV, `client.addr=10.0.0.1 method=GET`},
+		{"nest kv", GroupNest, false, common.FormatKV, `http.client.addr=10.0.0.1 http.method=GET`},
+		{"default json", GroupDefault, true, common.FormatJSON, `http={"client":{"addr":"10.0.0.1"},"method":"GET"}`},
+		{"nest json", GroupNest, false, common.FormatJSON, `http={"client":{"addr":"10.0.0.1"},"method":"GET"}`},
+		{"prefix json", GroupPrefix, false, common.FormatJSON, `http.client.addr=10.0.0.1 http.method=GET`},
+		{"ignore top json", GroupIgnoreTop, false, common.FormatJSON, `client.addr=10.0.0.1 method=GET`},
+	}
+	for _, tc := range tests {
+		GroupMode, FlattenGroups, Format = tc.mode, tc.flatten, tc.format
+		enc := zapcore.NewMapObjectEncoder()
+		for _, f := range fields {
+			f.AddTo(enc)
+		}
+		var got []string
+		for _, f := range appendFields(nil, "", true, enc.Fields) {
+			value := f.Value
+			if group, ok := value.(map[string]interface{}); ok {
+				b, err := json.Marshal(group)
+				if err != nil {
+					t.Fatal(err)
+				}
+				value = string(b)
+			}
+			got = append(got, fmt.Sprintf("%s=%v", f.Key, value))
+		}
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("%s: fields = %s, want %s", tc.name, strings.Join(got, " "), tc.want)
+		}
+	}
+}
+
+func TestValueFormat(t *testing.T) {
+	logger, frames, errs := initLogger(t, "values")
+	ValueFormat = common.ValueFormat{Duration: common.DurationMillis, TimeLayout: time.RFC3339, FloatPrecision: 1}
+	defer func() { ValueFormat = common.ValueFormat{} }()
+	at := time.Date(2023, 4, 5, 6, 7, 8, 9e6, time.UTC)
+	logger.Info("formatted", zap.Duration("latency", 1500*time.Microsecond), zap.Time("at", at), zap.Float64("ratio", 0.25),
+		zap.Namespace("db"), zap.Duration("took", time.Second))
+	f := nextMessage(t, frames, errs)
+	if f["latency"] != "1.5" || f["at"] != "2023-04-05T06:07:08Z" || f["ratio"] != "0.2" || f["db.took"] != "1000.0" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestNestedValues(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+	loop := &node{Name: "loop"}
+	loop.Next = loop
+	logger, frames, errs := initLogger(t, "nested")
+	logger.Info("nested", zap.Any("list", &node{Name: "a", Next: &node{Name: "b"}}), zap.Any("loop", loop))
+	f := nextMessage(t, frames, errs)
+	if f["list"] != `{"Name":"a","Next":{"Name":"b","Next":null}}` || f["loop"] != "[*zapld.node]" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestMessageFormatter(t *testing.T) {
+	logger, frames, errs := initLogger(t, "prefix")
+	MessagePrefix = "[billing-worker] "
+	MessageFormatter = func(entry zapcore.Entry, msg string) string {
+		if entry.Level == zapcore.WarnLevel {
+			panic("formatter")
+		}
+		return entry.Level.CapitalString() + ": " + msg
+	}
+	defer func() { MessagePrefix, MessageFormatter = "", nil }()
+	logger.Info("payment failed@@order=42")
+	if f := nextMessage(t, frames, errs); f["msg"] != "[billing-worker] INFO: payment failed" || f["order"] != "42" {
+		t.Fatalf("frame = %v", f)
+	}
+	logger.Warn("payment retried")
+	if f := nextMessage(t, frames, errs); f["msg"] != "[billing-worker] payment retried" {
+		t.Fatalf("frame after panic = %v", f)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	logger, frames, errs := initLogger(t, "hooks")
+	Hooks = []func(e *Event) bool{
+		func(e *Event) bool { return !strings.HasPrefix(e.Message, "GET /healthz") },
+		func(e *Event) bool {
+			if e.Message == "panic" {
+				panic("hook")
+			}
+			return true
+		},
+		func(e *Event) bool {
+			if http, ok := e.Fields["http"].(map[string]interface{}); ok {
+				e.Fields["tenant"] = strings.SplitN(http["user"].(string), ":", 2)[0]
+			}
+			e.Level = zapcore.ErrorLevel
+			return true
+		},
+	}
+	defer func() { Hooks = nil }()
+	vetoedBefore := Vetoed()
+	logger.Info("GET /healthz 200")
+	logger.Info("panic", zap.Namespace("http"), zap.String("user", "acme:42"))
+	f := nextMessage(t, frames, errs)
+	if f["msg"] != "panic" || f["tenant"] != "acme" || f["http.user"] != "acme:42" || f["lvl"] != "error" {
+		t.Fatalf("frame = %v", f)
+	}
+	if n := Vetoed() - vetoedBefore; n != 1 {
+		t.Fatalf("Vetoed() = %d", n)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	logger, frames, errs := initLogger(t, "filter")
+	Filter = Not(Or(
+		FilterByMessageSubstring("GET /healthz"),
+		And(FilterByAttr("http.lib", "grpc"), func(e *Event) bool { return e.Level == zapcore.DebugLevel }),
+	))
+	defer func() { Filter = nil }()
+	filteredBefore := Filtered()
+	logger.Info("GET /healthz 200")
+	logger.Debug("spam", zap.Namespace("http"), zap.String("lib", "grpc"))
+	logger.Info("handled", zap.Namespace("http"), zap.String("lib", "grpc"))
+	if f := nextMessage(t, frames, errs); f["msg"] != "handled" {
+		t.Fatalf("frame = %v", f)
+	}
+	if n := Filtered() - filteredBefore; n != 2 {
+		t.Fatalf("Filtered() = %d", n)
+	}
+}
+
+func TestLevelNames(t *testing.T) {
+	const traceLevel, verboseLevel, noticeLevel, alertLevel = zapcore.Level(-2), zapcore.Level(-4), zapcore.Level(7), zapcore.Level(9)
+	for name, tc := range map[string]struct {
+		names map[zapcore.Level]string
+		level zapcore.Level
+		want  string
+	}{
+		"default":             {nil, zapcore.InfoLevel, "info"},
+		"default custom":      {nil, traceLevel, "debug"},
+		"default above fatal": {nil, noticeLevel, "fatal"},
+		"trace":               {map[zapcore.Level]string{traceLevel: "trace"}, traceLevel, "trace"},
+		"below trace":         {map[zapcore.Level]string{traceLevel: "trace"}, verboseLevel, "debug"},
+		"between trace":       {map[zapcore.Level]string{verboseLevel: "trace"}, traceLevel, "trace"},
+		"standard":            {map[zapcore.Level]string{traceLevel: "trace"}, zapcore.WarnLevel, "warn"},
+		"renamed":             {map[zapcore.Level]string{zapcore.DPanicLevel: "critical"}, zapcore.DPanicLevel, "critical"},
+		"notice":              {map[zapcore.Level]string{noticeLevel: "notice"}, noticeLevel, "notice"},
+		"between notice":      {map[zapcore.Level]string{noticeLevel: "notice", alertLevel: "alert"}, noticeLevel + 1, "notice"},
+		"above named":         {map[zapcore.Level]string{noticeLevel: "notice", alertLevel: "alert"}, alertLevel + 5, "alert"},
+		"above renamed fatal": {map[zapcore.Level]string{zapcore.FatalLevel: "fatal!"}, noticeLevel, "fatal!"},
+		"below notice":        {map[zapcore.Level]string{noticeLevel: "notice"}, zapcore.FatalLevel + 1, "fatal"},
+	} {
+		LevelNames = tc.names
+		if got := levelName(tc.level); got != tc.want {
+			t.Errorf("%s: levelName(%d) = %q, want %q", name, tc.level, got, tc.want)
+		}
+	}
+	LevelNames = nil
+
+	logger, frames, errs := initLogger(t, "levels")
+	LevelNames = map[zapcore.Level]string{noticeLevel: "notice"}
+	defer func() { LevelNames = nil }()
+	logger.Log(noticeLevel, "custom")
+	logger.Info("standard")
+	if f := nextMessage(t, frames, errs); f["msg"] != "custom" || f["lvl"] != "notice" {
+		t.Fatalf("frame = %v", f)
+	}
+	if f := nextMessage(t, frames, errs); f["lvl"] != "info" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestAppSource(t *testing.T) {
+	t.Setenv("LOGDOC_APP", "from-env")
+	initLogger(t, "")
+	if app, source := AppSource(); app != "from-env" || source != common.AppEnv {
+		t.Fatalf("AppSource() = %q, %s", app, source)
+	}
+	logger, frames, errs := initLogger(t, "billing worker")
+	if app, source := AppSource(); app != "billing_worker" || source != common.AppExplicit {
+		t.Fatalf("explicit AppSource() = %q, %s", app, source)
+	}
+	logger.Info("sanitized")
+	if f := nextMessage(t, frames, errs); f["app"] != "billing_worker" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestGoroutineID(t *testing.T) {
+	logger, frames, errs := initLogger(t, "gid")
+	IncludeGoroutineID = true
+	defer func() { IncludeGoroutineID = false }()
+	var wg sync.WaitGroup
+	for g := 0; g < 2; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			logger.Info(fmt.Sprint("worker ", g))
+		}(g)
+	}
+	wg.Wait()
+	gids := map[string]string{}
+	for i := 0; i < 2; i++ {
+		f := nextMessage(t, frames, errs)
+		gids[f["msg"]] = f[common.GoroutineIDKey]
+	}
+	if gids["worker 0"] == "" || gids["worker 0"] == gids["worker 1"] {
+		t.Fatalf("gids = %v", gids)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	logger, frames, errs := initLogger(t, "sanitize")
+	MaxValueSize = 12
+	defer func() { MaxValueSize = 0 }()
+	logger.Info("bad \xff\x00 payload", zap.String("body", "0123456789abcdef"))
+	if f := nextMessage(t, frames, errs); f["msg"] != "bad �\\x00 ..." || f["body"] != "0123456789ab..." {
+		t.Fatalf("frame = %q", f)
+	}
+}
+
+func TestRedactKeys(t *testing.T) {
+	RedactKeys = []string{"password", "Authorization", "token", "card"}
+	Redact = func(key, value string) string {
+		if key == "card" {
+			return common.KeepLast(4)(key, value)
+		}
+		return common.Redacted
+	}
+	defer func() { RedactKeys, Redact, Format = nil, nil, common.FormatKV }()
+
+	written := func(format common.Format) string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		data := make(chan []byte, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				data <- nil
+				return
+			}
+			defer conn.Close()
+			b, _ := io.ReadAll(conn)
+			data <- b
+		}()
+		Format = format
+		logger, err := Init(&zap.Config{Encoding: "json", Level: zap.NewAtomicLevelAt(zap.DebugLevel)}, zap.DebugLevel, "tcp", ln.Addr().String(), "redact")
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.With(zap.String("TOKEN", "raw-token-1")).Info("login@@password=raw-password-2@user=bob",
+			zap.String("card", "4242424242424242"), zap.Namespace("http"), zap.String("authorization", "raw-auth-3"))
+		_ = connection.Close()
+		return string(<-data)
+	}
+
+	kv, object := written(common.FormatKV), written(common.FormatJSON)
+	for _, raw := range []string{"raw-token-1", "raw-password-2", "raw-auth-3", "42424242"} {
+		if strings.Contains(kv, raw) || strings.Contains(object, raw) {
+			t.Fatalf("%s is written: %q, %q", raw, kv, object)
+		}
+	}
+	for _, redacted := range []string{"TOKEN=[REDACTED]\n", "password=[REDACTED]\n", "http.authorization=[REDACTED]\n", "card=************4242\n", "user=bob\n"} {
+		if !strings.Contains(kv, redacted) {
+			t.Fatalf("%s is not written: %q", redacted, kv)
+		}
+	}
+	if !strings.Contains(object, `"http":{"authorization":"[REDACTED]"}`) {
+		t.Fatalf("JSON = %q", object)
+	}
+}
+
+func TestKeyFilter(t *testing.T) {
+	DenyKeys = []string{"["}
+	if _, err := Init(nil, zap.DebugLevel, "tcp", "127.0.0.1:1", "filter"); err == nil || !strings.Contains(err.Error(), "pattern") {
+		t.Fatalf("Init error = %v, want pattern error", err)
+	}
+
+	AllowKeys, DenyKeys, RedactKeys = []string{"http", "status"}, []string{"http.internal.*"}, []string{"token"}
+	defer func() { AllowKeys, DenyKeys, RedactKeys = nil, nil, nil }()
+	logger, frames, errs := initLogger(t, "filter")
+	filtered := FieldsFiltered()
+	logger.Info("filtered", zap.Int("status", 200), zap.String("user", "bob"), zap.Namespace("http"),
+		zap.String("method", "GET"), zap.String("token", "raw"), zap.Namespace("internal"), zap.String("dump", "big"))
+	f := nextMessage(t, frames, errs)
+	if f["status"] != "200" || f["http.method"] != "GET" || f["http.token"] != common.Redacted {
+		t.Fatalf("frame = %v", f)
+	}
+	for _, k := range []string{"user", "http.internal.dump"} {
+		if _, ok := f[k]; ok {
+			t.Fatalf("filtered field %s sent: %v", k, f)
+		}
+	}
+	if n := FieldsFiltered() - filtered; n != 2 {
+		t.Fatalf("FieldsFiltered() = %d", n)
+	}
+}
+
+// Like github.com/pkg/errors.
+type frame uintptr
+
+type stackError struct{ stack []frame }
+
+func (e *stackError) Error() string { return "failed" }
+
+func (e *stackError) StackTrace() []frame { return e.stack }
+
+func failingCall() error {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(1, pcs)
+	e := &stackError{}
+	for _, pc := range pcs[:n] {
+		e.stack = append(e.stack, frame(pc))
+	}
+	return e
+}
+
+func TestStackTrace(t *testing.T) {
+	StackTrace, StackTraceDepth = true, 1
+	defer func() { StackTrace, StackTraceDepth, StackTraceLevel = false, 0, zap.ErrorLevel }()
+	logger, frames, errs := initLogger(t, "stack")
+
+	logger.Info("no stack")
+	if f := nextMessage(t, frames, errs); f["stacktrace"] != "" {
+		t.Fatalf("info stack = %q", f["stacktrace"])
+	}
+
+	// Logger built by zap.Config adds its own stack to Error messages only.
+	StackTraceLevel = zap.WarnLevel
+	logger.Warn("failed", zap.Int("status", 500))
+	stack := strings.Split(nextMessage(t, frames, errs)["stacktrace"], "\n")
+	if len(stack) != 2 || stack[0] != "github.com/LogDoc-org/logdoc-go-appender/zap.TestStackTrace()" ||
+		!strings.Contains(stack[1], "zap_test.go:") {
+		t.Fatalf("stack = %q", stack)
+	}
+
+	logger.Error("failed")
+	if stack := nextMessage(t, frames, errs)["stacktrace"]; !strings.HasPrefix(stack, "github.com/LogDoc-org/logdoc-go-appender/zap.TestStackTrace\n\t") {
+		t.Fatalf("zap stack = %q", stack)
+	}
+
+	logger.Error("failed", zap.Error(fmt.Errorf("handler: %w", failingCall())))
+	if stack := nextMessage(t, frames, errs)["stacktrace"]; !strings.HasPrefix(stack, "github.com/LogDoc-org/logdoc-go-appender/zap.failingCall()\n\t") {
+		t.Fatalf("error stack = %q", stack)
+	}
+
+	logger.Warn("warn stack", zap.String("stacktrace", "own"))
+	if stack := nextMessage(t, frames, errs)["stacktrace"]; stack != "own" {
+		t.Fatalf("own stack = %q", stack)
+	}
+}
+
+func TestMaxFields(t *testing.T) {
+	logger, frames, errs := initLogger(t, "maxfields")
+	MaxFields, MaxValueSize = 5, 80
+	defer func() { MaxFields, MaxValueSize = 0, 0 }()
+	dropped, cut := FieldsDropped(), ValuesCut()
+
+	// Nested namespaces and objects expand to 2*2*3 = 12 fields.
+	leaf := zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		for _, k := range []string{"a", "b", "c"} {
+			enc.AddString(k, k+strings.Repeat("v", 100))
+		}
+		return nil
+	})
+	var fields []zap.Field
+	for _, group := range []string{"g1", "g2"} {
+		fields = append(fields, zap.Object(group, zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			if err := enc.AddObject("x", leaf); err != nil {
+				return err
+			}
+			return enc.AddObject("y", leaf)
+		})))
+	}
+	logger.With(zap.Namespace("req")).Info("many", fields...)
+	f := nextMessage(t, frames, errs)
+	if f["fields_truncated"] != "7" || f["req.g1.x.a"] != "a"+strings.Repeat("v", 79)+"..." || f["req.g1.y.b"] != "b"+strings.Repeat("v", 79)+"..." {
+		t.Fatalf("frame = %v", f)
+	}
+	if _, ok := f["req.g1.y.c"]; ok {
+		t.Fatalf("field over limit sent: %v", f)
+	}
+	if f["msg"] != "many" || f["lvl"] != "info" {
+		t.Fatalf("service fields lost: %v", f)
+	}
+	if n := FieldsDropped() - dropped; n != 7 {
+		t.Fatalf("FieldsDropped() = %d", n)
+	}
+	if n := ValuesCut() - cut; n != 5 {
+		t.Fatalf("ValuesCut() = %d", n)
+	}
+}
+
+func TestCallbackPanics(t *testing.T) {
+	ReplaceField = func(key string, value interface{}) (string, interface{}) {
+		if key == "user" {
+			panic("replace")
+		}
+		return key, value
+	}
+	Marshaler = func(v interface{}) ([]byte, error) { panic("marshal") }
+	LevelMapper = func(level zapcore.Level) string { panic("level") }
+	defer func() { ReplaceField, Marshaler, LevelMapper = nil, nil, nil }()
+	logger, frames, errs := initLogger(t, "panics")
+	logger.Info("survived", zap.String("user", "bob"), zap.Any("ids", []int{1, 2}))
+	if f := nextMessage(t, frames, errs); f["msg"] != "survived" || f["user"] != "bob" || f["ids"] != "[1 2]" || f["lvl"] != "info" {
+		t.Fatalf("frame = %v", f)
+	}
+}
+
+func TestWithSiblings(t *testing.T) {
+	logger, frames, errs := initLogger(t, "siblings")
+	// Fields added one by one leave spare capacity, which siblings must not share.
+	parent := logger.With(zap.String("service", "api")).With(zap.String("region", "eu")).With(zap.String("zone", "a"))
+	first := parent.With(zap.String("first", "1"))
+	second := parent.With(zap.String("second", "2"))
+
+	var wg sync.WaitGroup
+	for _, l := range []*zap.Logger{first, second} {
+		wg.Add(1)
+		go func(l *zap.Logger) {
+			defer wg.Done()
+			l.Info("sibling")
+		}(l)
+	}
+	wg.Wait()
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		f := nextMessage(t, frames, errs)
+		if f["service"] != "api" || f["zone"] != "a" || (f["first"] == "") == (f["second"] == "") {
+			t.Fatalf("frame = %v", f)
+		}
+		got[f["first"]+f["second"]] = true
+	}
+	if !got["1"] || !got["2"] {
+		t.Fatalf("frames = %v", got)
+	}
+}
+
+func TestMaxEventBytes(t *testing.T) {
+	logger, frames, errs := initLogger(t, "max")
+	MaxEventBytes = 300
+	defer func() { MaxEventBytes = 0 }()
+	logger.Info(strings.Repeat("m", 1000), zap.String("dump", strings.Repeat("d", 10000)), zap.String("user", "bob"))
+	f := nextMessage(t, frames, errs)
+	if f["user"] != "bob" || f["truncated"] != "true" || f["app"] != "max" || !strings.HasSuffix(f["dump"], "...") {
+		t.Fatalf("frame = %v", f)
+	}
+	size := 3
+	for k, v := range f {
+		size += len(k) + len(v) + 2
+	}
+	if size > 300 {
+		t.Fatalf("frame is about %d bytes", size)
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []byte, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			data, err := r.ReadBytes(0)
+			if err != nil {
+				return
+			}
+			received <- data
+		}
+	}()
+
+	Protocol = common.Protocol{Header: []byte{7, 1}, Terminator: []byte{0}}
+	defer func() { Protocol = common.Protocol{} }()
+	config := zap.Config{Encoding: "json", Level: zap.NewAtomicLevelAt(zap.DebugLevel)}
+	logger, err := Init(&config, zap.DebugLevel, "tcp", ln.Addr().String(), "protocol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	logger.Info("revision")
+	for {
+		select {
+		case data := <-received:
+			if !bytes.HasPrefix(data, []byte{7, 1}) {
+				t.Fatalf("frame = %q", data)
+			}
+			if bytes.Contains(data, []byte("msg=revision\n")) {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+		}
+	}
+}
+
+func TestBinaryEncoding(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan map[string]interface{}, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			fields, err := common.ReadFrame(r, common.Protocol{}, common.BinaryEncoding)
+			if err != nil {
+				return
+			}
+			values := map[string]interface{}{}
+			for _, f := range fields {
+				values[f.Key] = f.Value
+			}
+			received <- values
+		}
+	}()
+
+	Encoding, DisableSanitize = common.BinaryEncoding, true
+	defer func() { Encoding, DisableSanitize = nil, false }()
+	logger, err := Init(&zap.Config{Encoding: "json", Level: zap.NewAtomicLevelAt(zap.DebugLevel)}, zap.DebugLevel, "tcp", ln.Addr().String(), "binary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	logger.Info("binary", zap.String("payload", "\x00\xff\n=\r"))
+	for {
+		select {
+		case values := <-received:
+			if values["msg"] == "binary" {
+				if values["payload"] != "\x00\xff\n=\r" || values["app"] != "binary" {
+					t.Fatalf("fields = %q", values)
+				}
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+		}
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan map[string]interface{}, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			var object map[string]interface{}
+			if !bytes.HasPrefix(line, []byte{6, 3}) || json.Unmarshal(line[2:], &object) != nil {
+				t.Errorf("frame = %q", line)
+				return
+			}
+			received <- object
+		}
+	}()
+
+	Format = common.FormatJSON
+	defer func() { Format = common.FormatKV }()
+	logger, err := Init(&zap.Config{Encoding: "json", Level: zap.NewAtomicLevelAt(zap.DebugLevel)}, zap.DebugLevel, "tcp", ln.Addr().String(), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	logger.Info("handled", zap.Int("status", 200), zap.Bool("cached", true), zap.Duration("took", time.Second),
+		zap.Namespace("http"), zap.String("method", "GET"), zap.Namespace("client"), zap.String("ip", "10.0.0.1"))
+	for {
+		select {
+		case object := <-received:
+			if object["msg"] != "handled" {
+				continue
+			}
+			want := `{"cached":true,"http":{"client":{"ip":"10.0.0.1"},"method":"GET"},"lvl":"info","msg":"handled","status":200,"took":"1s"}`
+			app := object["app"]
+			for _, k := range []string{"app", "tsrc", "ip", "host", "pid", "src"} {
+				delete(object, k)
+			}
+			if got, _ := json.Marshal(object); string(got) != want || app != "json" {
+				t.Fatalf("JSON = %s, want %s", got, want)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+		}
+	}
+}